@@ -18,6 +18,7 @@ package main
 
 import (
 	// The set of controllers this controller process runs.
+	"github.com/vincentpli/cel-tekton/pkg/reconciler/transaction"
 	"github.com/vincentpli/cel-tekton/pkg/reconciler/variablestore"
 
 	// This defines the shared main for injected controllers.
@@ -27,5 +28,6 @@ import (
 func main() {
 	sharedmain.Main("controller",
 		variablestore.NewController,
+		transaction.NewController,
 	)
 }
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
@@ -32,18 +33,42 @@ import (
 	"knative.dev/pkg/webhook/certificates"
 	"knative.dev/pkg/webhook/configmaps"
 	"knative.dev/pkg/webhook/resourcesemantics"
+	"knative.dev/pkg/webhook/resourcesemantics/conversion"
 	"knative.dev/pkg/webhook/resourcesemantics/defaulting"
 	"knative.dev/pkg/webhook/resourcesemantics/validation"
 
+	tektonv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/admission"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
 	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1beta1"
+	variablestoreclient "github.com/vincentpli/cel-tekton/pkg/client/injection/client"
 )
 
 var types = map[schema.GroupVersionKind]resourcesemantics.GenericCRD{
 	// List the types to validate.
 	v1alpha1.SchemeGroupVersion.WithKind("VariableStore"): &v1alpha1.VariableStore{},
+	v1beta1.SchemeGroupVersion.WithKind("VariableStore"):  &v1beta1.VariableStore{},
 }
 
-var callbacks = map[schema.GroupVersionKind]validation.Callback{}
+var callbacks = map[schema.GroupVersionResource]admission.Callback{
+	// Reject the Runs whose CEL expressions are invalid or exceed the limits of their namespace before
+	// they are run, and the Pipelines and PipelineRuns which would create such Runs. The Runs and the
+	// PipelineRuns are only validated when created.
+	tektonv1alpha1.SchemeGroupVersion.WithResource("runs"): {
+		Validate:   admission.ValidateRun,
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+	},
+	tektonv1beta1.SchemeGroupVersion.WithResource("pipelines"): {
+		Validate:   admission.ValidatePipeline,
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+	},
+	tektonv1beta1.SchemeGroupVersion.WithResource("pipelineruns"): {
+		Validate:   admission.ValidatePipelineRun,
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+	},
+}
 
 func NewDefaultingAdmissionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
 	return defaulting.NewAdmissionController(ctx,
@@ -83,16 +108,65 @@ func NewValidationAdmissionController(ctx context.Context, cmw configmap.Watcher
 
 		// A function that infuses the context passed to Validate/SetDefaults with custom metadata.
 		func(ctx context.Context) context.Context {
-			// Here is where you would infuse the context with state
-			// (e.g. attach a store with configmap data)
 			return ctx
 		},
 
 		// Whether to disallow unknown fields.
 		true,
+	)
+}
+
+// NewCallbackAdmissionController serves the validation of the Tekton resources, which are not ours, from a
+// webhook of its own: they are decoded leniently, their status is left alone, and only the namespaces which
+// opt in are validated, so that neither newer Tekton fields nor an outage of the webhook hold up Tekton.
+func NewCallbackAdmissionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	store := config.NewStore(logging.FromContext(ctx).Named("config-store"))
+	store.WatchConfigs(cmw)
+
+	// The callbacks look up the VariableStores with the client of the webhook.
+	client := variablestoreclient.Get(ctx)
 
-		// Extra validating callbacks to be applied to resources.
+	return admission.NewAdmissionController(ctx,
+
+		// Name of the resource webhook.
+		fmt.Sprintf("callbacks.webhook.%s.tekton.dev", system.Namespace()),
+
+		// The path on which to serve the webhook.
+		"/callback-validation",
+
+		// The resources to validate, and how.
 		callbacks,
+
+		// A function that infuses the context passed to the callbacks with custom metadata.
+		func(ctx context.Context) context.Context {
+			ctx = context.WithValue(ctx, variablestoreclient.Key{}, client)
+			return store.ToContext(ctx)
+		},
+	)
+}
+
+func NewConversionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	return conversion.NewConversionController(ctx,
+
+		// The path on which to serve the webhook.
+		"/resource-conversion",
+
+		// The resources to convert, through their hub version.
+		map[schema.GroupKind]conversion.GroupKindConversion{
+			v1beta1.Kind("VariableStore"): {
+				DefinitionName: "variablestores.custom.tekton.dev",
+				HubVersion:     v1beta1.SchemeGroupVersion.Version,
+				Zygotes: map[string]conversion.ConvertibleObject{
+					v1alpha1.SchemeGroupVersion.Version: &v1alpha1.VariableStore{},
+					v1beta1.SchemeGroupVersion.Version:  &v1beta1.VariableStore{},
+				},
+			},
+		},
+
+		// A function that infuses the context passed to ConvertTo/ConvertFrom/SetDefaults with custom metadata.
+		func(ctx context.Context) context.Context {
+			return ctx
+		},
 	)
 }
 
@@ -107,8 +181,9 @@ func NewConfigValidationController(ctx context.Context, cmw configmap.Watcher) *
 
 		// The configmaps to validate.
 		configmap.Constructors{
-			logging.ConfigMapName(): logging.NewConfigFromConfigMap,
-			metrics.ConfigMapName(): metrics.NewObservabilityConfigFromConfigMap,
+			logging.ConfigMapName():      logging.NewConfigFromConfigMap,
+			metrics.ConfigMapName():      metrics.NewObservabilityConfigFromConfigMap,
+			config.GetLimitsConfigName(): config.NewLimitsFromConfigMap,
 		},
 	)
 }
@@ -124,6 +199,8 @@ func main() {
 		certificates.NewController,
 		NewDefaultingAdmissionController,
 		NewValidationAdmissionController,
+		NewCallbackAdmissionController,
+		NewConversionController,
 		NewConfigValidationController,
 	)
 }
@@ -4,9 +4,12 @@ go 1.15
 
 require (
 	github.com/google/cel-go v0.7.3
+	github.com/google/go-cmp v0.5.5
 	github.com/hashicorp/go-multierror v1.1.0
 	github.com/tektoncd/pipeline v0.22.0
 	go.uber.org/zap v1.16.0
+	google.golang.org/genproto v0.0.0-20201214200347-8c77b98c765d
+	google.golang.org/protobuf v1.25.0
 	k8s.io/api v0.19.7
 	k8s.io/apimachinery v0.19.7
 	k8s.io/client-go v0.19.7
@@ -15,6 +18,7 @@ require (
 	knative.dev/hack v0.0.0-20210325223819-b6ab329907d3
 	knative.dev/hack/schema v0.0.0-20210325223819-b6ab329907d3
 	knative.dev/pkg v0.0.0-20210331065221-952fdd90dbb0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace knative.dev/pkg => knative.dev/pkg v0.0.0-20210331065221-952fdd90dbb0
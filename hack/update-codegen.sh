@@ -36,6 +36,12 @@ ${CODEGEN_PKG}/generate-groups.sh "deepcopy,client,informer,lister" \
   "variablestores:v1alpha1" \
   --go-header-file ${REPO_ROOT_DIR}/hack/boilerplate/boilerplate.go.txt
 
+# v1beta1 is only served through the conversion webhook, the clients read and write the storage version v1alpha1.
+${CODEGEN_PKG}/generate-groups.sh "deepcopy" \
+  github.com/vincentpli/cel-tekton/pkg/client github.com/vincentpli/cel-tekton/pkg/apis \
+  "variablestores:v1beta1" \
+  --go-header-file ${REPO_ROOT_DIR}/hack/boilerplate/boilerplate.go.txt
+
 group "Knative Codegen"
 
 # Knative Injection
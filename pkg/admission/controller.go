@@ -0,0 +1,292 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	admissionlisters "k8s.io/client-go/listers/admissionregistration/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	vwhinformer "knative.dev/pkg/client/injection/kube/informers/admissionregistration/v1/validatingwebhookconfiguration"
+	"knative.dev/pkg/controller"
+	secretinformer "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret"
+	"knative.dev/pkg/kmp"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	"knative.dev/pkg/system"
+	"knative.dev/pkg/webhook"
+	certresources "knative.dev/pkg/webhook/certificates/resources"
+)
+
+// NamespaceLabel is the label of the namespaces whose resources are validated by the callbacks. Only the
+// namespaces labeled with NamespaceLabelEnabled opt in, so that the resources of Tekton, which cel-tekton
+// does not own, are not held up by the webhook anywhere else.
+const NamespaceLabel = "custom.tekton.dev/validate-expressions"
+
+// NamespaceLabelEnabled is the value of NamespaceLabel opting a namespace in.
+const NamespaceLabelEnabled = "enabled"
+
+// Callback validates the resources of a kind on some operations.
+type Callback struct {
+	// Validate is called with the resource of the request, decoded leniently so that the fields of other
+	// Tekton releases are ignored rather than rejected.
+	Validate func(context.Context, *unstructured.Unstructured) error
+
+	// Operations are the operations on the resource which are validated.
+	Operations []admissionregistrationv1.OperationType
+}
+
+// reconciler is the admission controller of the resources which are not ours. Unlike the validation admission
+// controller of knative, it neither decodes the resources strictly nor calls their own Validate, and it leaves
+// their status subresource alone: only the callbacks validate the resources, on their own operations.
+type reconciler struct {
+	webhook.StatelessAdmissionImpl
+	pkgreconciler.LeaderAwareFuncs
+
+	key       types.NamespacedName
+	path      string
+	callbacks map[schema.GroupVersionResource]Callback
+
+	withContext func(context.Context) context.Context
+
+	client       kubernetes.Interface
+	vwhlister    admissionlisters.ValidatingWebhookConfigurationLister
+	secretlister corelisters.SecretLister
+
+	secretName string
+}
+
+var _ controller.Reconciler = (*reconciler)(nil)
+var _ pkgreconciler.LeaderAware = (*reconciler)(nil)
+var _ webhook.AdmissionController = (*reconciler)(nil)
+var _ webhook.StatelessAdmissionController = (*reconciler)(nil)
+
+// NewAdmissionController constructs the admission controller calling the callbacks of the resources, keyed by
+// their group, version and plural name, and the reconciler of its ValidatingWebhookConfiguration.
+func NewAdmissionController(
+	ctx context.Context,
+	name, path string,
+	callbacks map[schema.GroupVersionResource]Callback,
+	wc func(context.Context) context.Context,
+) *controller.Impl {
+	vwhInformer := vwhinformer.Get(ctx)
+	secretInformer := secretinformer.Get(ctx)
+
+	wh := &reconciler{
+		LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
+			// Have this reconciler enqueue our singleton whenever it becomes leader.
+			PromoteFunc: func(bkt pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+				enq(bkt, types.NamespacedName{Name: name})
+				return nil
+			},
+		},
+
+		key:       types.NamespacedName{Name: name},
+		path:      path,
+		callbacks: callbacks,
+
+		withContext: wc,
+		secretName:  webhook.GetOptions(ctx).SecretName,
+
+		client:       kubeclient.Get(ctx),
+		vwhlister:    vwhInformer.Lister(),
+		secretlister: secretInformer.Lister(),
+	}
+
+	logger := logging.FromContext(ctx)
+	const queueName = "CallbackWebhook"
+	c := controller.NewImpl(wh, logger.Named(queueName), queueName)
+
+	// Reconcile when the named ValidatingWebhookConfiguration changes.
+	vwhInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterWithName(name),
+		Handler:    controller.HandleAll(c.Enqueue),
+	})
+
+	// Reconcile when the cert bundle changes.
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterWithNameAndNamespace(system.Namespace(), wh.secretName),
+		Handler:    controller.HandleAll(c.Enqueue),
+	})
+
+	return c
+}
+
+// Path implements AdmissionController
+func (ac *reconciler) Path() string {
+	return ac.path
+}
+
+// Admit implements AdmissionController
+func (ac *reconciler) Admit(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if ac.withContext != nil {
+		ctx = ac.withContext(ctx)
+	}
+	logger := logging.FromContext(ctx)
+
+	if request.SubResource != "" {
+		// The status is updated by the controllers of the resources, which aren't ours to hold up
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	gvr := schema.GroupVersionResource{
+		Group:    request.Resource.Group,
+		Version:  request.Resource.Version,
+		Resource: request.Resource.Resource,
+	}
+	c, ok := ac.callbacks[gvr]
+	if !ok || !c.validates(request.Operation) {
+		logger.Infof("Unhandled resource %v or operation %v, letting it through", gvr, request.Operation)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	uns := &unstructured.Unstructured{}
+	if err := json.Unmarshal(request.Object.Raw, &uns.Object); err != nil {
+		return webhook.MakeErrorStatus("decoding request failed: cannot decode incoming new object: %v", err)
+	}
+	if err := c.Validate(ctx, uns); err != nil {
+		return webhook.MakeErrorStatus("validation callback failed: %v", err)
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// validates returns whether the callback validates the operation.
+func (c Callback) validates(operation admissionv1.Operation) bool {
+	for _, op := range c.Operations {
+		if string(op) == string(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile implements controller.Reconciler
+func (ac *reconciler) Reconcile(ctx context.Context, key string) error {
+	logger := logging.FromContext(ctx)
+
+	if !ac.IsLeaderFor(ac.key) {
+		return controller.NewSkipKey(key)
+	}
+
+	// Look up the webhook secret, and fetch the CA cert bundle.
+	secret, err := ac.secretlister.Secrets(system.Namespace()).Get(ac.secretName)
+	if err != nil {
+		logger.Errorw("Error fetching secret", zap.Error(err))
+		return err
+	}
+	caCert, ok := secret.Data[certresources.CACert]
+	if !ok {
+		return fmt.Errorf("secret %q is missing %q key", ac.secretName, certresources.CACert)
+	}
+
+	return ac.reconcileValidatingWebhook(ctx, caCert)
+}
+
+// rules returns the rules of the webhook: the operations of the callbacks on the resources, but not on their
+// status subresource.
+func (ac *reconciler) rules() []admissionregistrationv1.RuleWithOperations {
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(ac.callbacks))
+	for gvr, c := range ac.callbacks {
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: c.Operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{gvr.Group},
+				APIVersions: []string{gvr.Version},
+				Resources:   []string{gvr.Resource},
+			},
+		})
+	}
+
+	// Sort the rules by Group, Version, Resource so that things are deterministically ordered.
+	sort.Slice(rules, func(i, j int) bool {
+		lhs, rhs := rules[i], rules[j]
+		if lhs.APIGroups[0] != rhs.APIGroups[0] {
+			return lhs.APIGroups[0] < rhs.APIGroups[0]
+		}
+		if lhs.APIVersions[0] != rhs.APIVersions[0] {
+			return lhs.APIVersions[0] < rhs.APIVersions[0]
+		}
+		return lhs.Resources[0] < rhs.Resources[0]
+	})
+	return rules
+}
+
+func (ac *reconciler) reconcileValidatingWebhook(ctx context.Context, caCert []byte) error {
+	logger := logging.FromContext(ctx)
+
+	configuredWebhook, err := ac.vwhlister.Get(ac.key.Name)
+	if err != nil {
+		return fmt.Errorf("error retrieving webhook: %w", err)
+	}
+
+	current := configuredWebhook.DeepCopy()
+	current.OwnerReferences = nil
+
+	rules := ac.rules()
+	for i, wh := range current.Webhooks {
+		if wh.Name != current.Name {
+			continue
+		}
+		cur := &current.Webhooks[i]
+		cur.Rules = rules
+
+		cur.NamespaceSelector = webhook.EnsureLabelSelectorExpressions(
+			cur.NamespaceSelector,
+			&metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      "webhooks.knative.dev/exclude",
+					Operator: metav1.LabelSelectorOpDoesNotExist,
+				}, {
+					Key:      NamespaceLabel,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   []string{NamespaceLabelEnabled},
+				}},
+			})
+
+		cur.ClientConfig.CABundle = caCert
+		if cur.ClientConfig.Service == nil {
+			return fmt.Errorf("missing service reference for webhook: %s", wh.Name)
+		}
+		cur.ClientConfig.Service.Path = ptr.String(ac.Path())
+	}
+
+	if ok, err := kmp.SafeEqual(configuredWebhook, current); err != nil {
+		return fmt.Errorf("error diffing webhooks: %w", err)
+	} else if !ok {
+		logger.Info("Updating webhook")
+		vwhclient := ac.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+		if _, err := vwhclient.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update webhook: %w", err)
+		}
+	} else {
+		logger.Info("Webhook is valid")
+	}
+	return nil
+}
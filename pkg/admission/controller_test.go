@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var testCallbacks = map[schema.GroupVersionResource]Callback{
+	v1alpha1.SchemeGroupVersion.WithResource("runs"): {
+		Validate:   ValidateRun,
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+	},
+	v1beta1.SchemeGroupVersion.WithResource("pipelines"): {
+		Validate:   ValidatePipeline,
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+	},
+}
+
+// runRequest returns the request of the operation on the Run, with the field of a newer Tekton release added
+// to its spec.
+func runRequest(t *testing.T, operation admissionv1.Operation, subResource, expression string) *admissionv1.AdmissionRequest {
+	t.Helper()
+	run := toUnstructured(t, newRun("store", "VariableStore", nil, []v1beta1.Param{param("c", expression)}))
+	run.Object["spec"].(map[string]interface{})["fieldOfANewerRelease"] = "value"
+	raw, err := json.Marshal(run.Object)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	return &admissionv1.AdmissionRequest{
+		Resource:    metav1.GroupVersionResource{Group: "tekton.dev", Version: "v1alpha1", Resource: "runs"},
+		SubResource: subResource,
+		Operation:   operation,
+		Object:      runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	ctx := newContext(t)
+	ac := &reconciler{callbacks: testCallbacks}
+	for _, tc := range []struct {
+		name        string
+		operation   admissionv1.Operation
+		subResource string
+		expression  string
+		wantAllowed bool
+	}{{
+		name:        "valid with an unknown field",
+		operation:   admissionv1.Create,
+		expression:  "a + b",
+		wantAllowed: true,
+	}, {
+		name:       "invalid",
+		operation:  admissionv1.Create,
+		expression: "a ==",
+	}, {
+		name:        "status update",
+		operation:   admissionv1.Update,
+		subResource: "status",
+		expression:  "a ==",
+		wantAllowed: true,
+	}, {
+		name:        "operation not validated",
+		operation:   admissionv1.Update,
+		expression:  "a ==",
+		wantAllowed: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := ac.Admit(ctx, runRequest(t, tc.operation, tc.subResource, tc.expression))
+			if resp.Allowed != tc.wantAllowed {
+				t.Errorf("Admit() allowed = %t, want %t: %v", resp.Allowed, tc.wantAllowed, resp.Result)
+			}
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	ac := &reconciler{callbacks: testCallbacks}
+	want := []admissionregistrationv1.RuleWithOperations{{
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{"tekton.dev"},
+			APIVersions: []string{"v1alpha1"},
+			Resources:   []string{"runs"},
+		},
+	}, {
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{"tekton.dev"},
+			APIVersions: []string{"v1beta1"},
+			Resources:   []string{"pipelines"},
+		},
+	}}
+	if d := cmp.Diff(want, ac.rules()); d != "" {
+		t.Errorf("rules() diff (-want, +got): %s", d)
+	}
+}
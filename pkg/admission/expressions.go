@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	celtask "github.com/vincentpli/cel-tekton/pkg/apis/cel"
+	celv1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/cel/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	variablestorev1beta1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+	"github.com/vincentpli/cel-tekton/pkg/celext"
+	variablestoreclient "github.com/vincentpli/cel-tekton/pkg/client/injection/client"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+// expressions describes the CEL expressions of a Run referencing a VariableStore, or of the Run a pipeline
+// task would create.
+type expressions struct {
+	namespace string
+	// store is the name of the referenced VariableStore
+	store string
+	// stateless is whether the expressions are the ones of the CEL custom task, which never reads a
+	// VariableStore
+	stateless bool
+	params    []v1beta1.Param
+	// written holds the names of the params written to the VariableStore by the pipeline tasks running before,
+	// which are its variables by the time the Run is created
+	written []string
+	// annotations are the annotations of the Run, nil when they are not known yet
+	annotations map[string]string
+	// substitutions is whether Tekton substitutes the variables of the params when creating the Run, the
+	// params holding some can only be validated then
+	substitutions bool
+}
+
+// validate compiles the CEL expressions and returns the errors found, with paths relative to the params.
+// The expressions are type-checked against the variables of the VariableStore when it exists, or when they
+// are the ones of the CEL custom task, otherwise they are only parsed. Their functions and their estimated cost are validated against the limits of the
+// namespace.
+func (ex expressions) validate(ctx context.Context) (*apis.FieldError, error) {
+	var store *variablestorev1alpha1.VariableStore
+	if !ex.stateless {
+		var err error
+		if store, err = getVariableStore(ctx, ex.namespace, ex.store); err != nil {
+			return nil, err
+		}
+	}
+
+	// The variables of the VariableStore and the params hide the metadata variables of the same names
+	taken := map[string]struct{}{}
+	if store != nil {
+		for _, variable := range store.Spec.Vars {
+			taken[variablestorev1alpha1.Alias(variable.Name)] = struct{}{}
+		}
+	}
+	for _, name := range append(append([]string{}, ex.written...), paramNames(ex.params)...) {
+		taken[variablestorev1alpha1.Alias(name)] = struct{}{}
+	}
+	env, err := celenv.NewEnv(taken)
+	if err != nil {
+		return nil, err
+	}
+	_, hidden := taken[celenv.TasksVar]
+	if (ex.annotations == nil || ex.annotations[variablestores.TasksAnnotationKey] == "true") && !hidden {
+		if env, err = env.Extend(cel.Declarations(celenv.TasksDecl)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Undeclared references are only errors when every variable is known: the VariableStore exists, or
+	// there is none to read, and the Run doesn't read variables unknown when it is created
+	strict := (store != nil || ex.stateless) && ex.annotations != nil && !ex.lenient()
+
+	// `vars` holds the variables of the VariableStore and the results of the Run
+	vars := len(ex.params) + len(ex.written)
+	declared := map[string]struct{}{}
+	if store != nil {
+		vars += len(store.Spec.Vars)
+		for _, variable := range store.Spec.Vars {
+			if env, err = declare(env, declared, variablestorev1alpha1.Alias(variable.Name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range ex.written {
+		if env, err = declare(env, declared, variablestorev1alpha1.Alias(name)); err != nil {
+			return nil, err
+		}
+	}
+	sizes := map[string]int64{celenv.VarsVar: int64(vars)}
+
+	limits := config.FromContextOrDefaults(ctx).Limits.ForNamespace(ex.namespace)
+	var errs *apis.FieldError
+	var runCost int64
+	for i, param := range ex.params {
+		for _, expression := range ex.expressions(param) {
+			path := expression.path
+			if ex.substitutions && hasSubstitutions(expression.expression) {
+				continue
+			}
+
+			var ast *cel.Ast
+			var iss *cel.Issues
+			if strict {
+				ast, iss = env.Compile(expression.expression)
+			} else {
+				env, ast, iss = celenv.CompilePartial(env, expression.expression, declared)
+			}
+			if iss.Err() != nil {
+				errs = errs.Also((&apis.FieldError{
+					Message: "invalid CEL expression",
+					Paths:   []string{path},
+					Details: iss.Err().Error(),
+				}).ViaIndex(i))
+			} else {
+				errs = errs.Also(validateExpression(ast, path, sizes, limits, ex.namespace, &runCost).ViaIndex(i))
+			}
+		}
+		if env, err = declare(env, declared, variablestorev1alpha1.Alias(param.Name)); err != nil {
+			return nil, err
+		}
+	}
+	if limits.RunCostLimit > 0 && runCost > limits.RunCostLimit {
+		errs = errs.Also(&apis.FieldError{
+			Message: fmt.Sprintf("the estimated cost %d of the CEL expressions exceeds the run cost limit %d of namespace %s",
+				runCost, limits.RunCostLimit, ex.namespace),
+			Paths: []string{apis.CurrentField},
+		})
+	}
+	return errs.ViaField("params"), nil
+}
+
+// paramNames returns the names of the params.
+func paramNames(params []v1beta1.Param) []string {
+	names := make([]string, 0, len(params))
+	for _, param := range params {
+		names = append(names, param.Name)
+	}
+	return names
+}
+
+// paramExpression is a CEL expression of a param, at path relative to the param.
+type paramExpression struct {
+	path       string
+	expression string
+}
+
+// expressions returns the CEL expressions of the param: its value, or the elements of its value when it is
+// an array param whose elements are evaluated, i.e. the Run doesn't take them as literals. The elements are
+// not validated when the annotations of the Run are not known yet.
+func (ex expressions) expressions(param v1beta1.Param) []paramExpression {
+	if param.Value.Type != v1beta1.ParamTypeArray {
+		return []paramExpression{{path: "value", expression: param.Value.StringVal}}
+	}
+	if ex.annotations == nil {
+		return nil
+	}
+	switch variablestorev1alpha1.ArrayParamsPolicy(ex.annotations[variablestores.ArrayParamsAnnotationKey]) {
+	case "", variablestorev1alpha1.ArrayParamsExpressions:
+	default:
+		return nil
+	}
+	expressions := make([]paramExpression, 0, len(param.Value.ArrayVal))
+	for i, expression := range param.Value.ArrayVal {
+		expressions = append(expressions, paramExpression{path: fmt.Sprintf("value[%d]", i), expression: expression})
+	}
+	return expressions
+}
+
+// validateExpression validates the functions called by the checked CEL expression at path and its estimated
+// cost, which is added to runCost.
+func validateExpression(ast *cel.Ast, path string, sizes map[string]int64, limits config.CostLimits, namespace string, runCost *int64) *apis.FieldError {
+	var errs *apis.FieldError
+	functions, err := celext.Functions(ast)
+	if err != nil {
+		return apis.ErrGeneric(err.Error(), path)
+	}
+	for _, function := range functions {
+		if limits.Denies(function) {
+			errs = errs.Also(&apis.FieldError{
+				Message: fmt.Sprintf("the CEL expression calls the function %s, which is denied in namespace %s", function, namespace),
+				Paths:   []string{path},
+			})
+		}
+	}
+
+	cost, err := celext.EstimateCost(ast, sizes, limits.MaxListSize)
+	if err != nil {
+		return errs.Also(apis.ErrGeneric(err.Error(), path))
+	}
+	if limits.ExpressionCostLimit > 0 && cost > limits.ExpressionCostLimit {
+		errs = errs.Also(&apis.FieldError{
+			Message: fmt.Sprintf("the estimated cost %d of the CEL expression exceeds the expression cost limit %d of namespace %s",
+				cost, limits.ExpressionCostLimit, namespace),
+			Paths: []string{path},
+		})
+	}
+	*runCost += cost
+	return errs
+}
+
+// lenient returns whether the Run reads variables which may not be known when it is created: it opts in to
+// partial evaluation, it waits for variables set externally, or it reads the variables written by the other
+// Runs of its PipelineRun to their overlay or to their ephemeral VariableStore, which only the referenced
+// VariableStore seeds. The Runs of the CEL custom task ignore the annotations applying to VariableStores.
+func (ex expressions) lenient() bool {
+	if _, partial := ex.annotations[variablestores.UnknownsAnnotationKey]; partial {
+		return true
+	}
+	if ex.stateless {
+		return false
+	}
+	_, waits := ex.annotations[variablestores.WaitForAnnotationKey]
+	return waits || ex.annotations[variablestores.TransactionAnnotationKey] == "true" ||
+		ex.annotations[variablestores.EphemeralAnnotationKey] == "true"
+}
+
+// declare returns the env extended with the variable, unless it is already declared.
+func declare(env *cel.Env, declared map[string]struct{}, name string) (*cel.Env, error) {
+	if _, ok := declared[name]; ok {
+		return env, nil
+	}
+	declared[name] = struct{}{}
+	return env.Extend(cel.Declarations(decls.NewVar(name, decls.Dyn)))
+}
+
+// referencesVariableStore returns whether the reference of a Run references a VariableStore, through any of
+// its served versions.
+func referencesVariableStore(apiVersion string, kind v1beta1.TaskKind) bool {
+	switch apiVersion {
+	case variablestorev1alpha1.SchemeGroupVersion.String(), variablestorev1beta1.SchemeGroupVersion.String():
+		return kind == "VariableStore"
+	}
+	return false
+}
+
+// referencesCELTask returns whether the reference of a Run references the CEL custom task.
+func referencesCELTask(apiVersion string, kind v1beta1.TaskKind) bool {
+	return apiVersion == celv1alpha1.SchemeGroupVersion.String() && kind == celtask.Kind
+}
+
+// hasSubstitutions returns whether the expression holds variables substituted by Tekton when creating the Run.
+func hasSubstitutions(expression string) bool {
+	return strings.Contains(expression, "$(")
+}
+
+// getVariableStore returns the VariableStore, or nil if it doesn't exist (yet).
+func getVariableStore(ctx context.Context, namespace, name string) (*variablestorev1alpha1.VariableStore, error) {
+	if name == "" {
+		return nil, nil
+	}
+	store, err := variablestoreclient.Get(ctx).CustomV1alpha1().VariableStores(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the VariableStore %s/%s: %w", namespace, name, err)
+	}
+	return store, nil
+}
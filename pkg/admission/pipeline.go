@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+)
+
+// ValidatePipeline is a validation callback for Pipelines. It validates the CEL expressions of the pipeline
+// tasks referencing a VariableStore like ValidateRun, before any PipelineRun creates their Runs.
+func ValidatePipeline(ctx context.Context, uns *unstructured.Unstructured) error {
+	pipeline := &v1beta1.Pipeline{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(uns.UnstructuredContent(), pipeline); err != nil {
+		return fmt.Errorf("couldn't convert the Pipeline: %w", err)
+	}
+
+	// The annotations of the Runs are those of the PipelineRuns, unknown yet
+	errs, err := validatePipelineSpec(ctx, pipeline.Namespace, &pipeline.Spec, nil)
+	if err != nil {
+		return err
+	}
+	if errs != nil {
+		return errs.ViaField("spec")
+	}
+	return nil
+}
+
+// ValidatePipelineRun is a validation callback for PipelineRuns. It validates the CEL expressions of the
+// pipeline tasks of the embedded pipeline spec referencing a VariableStore like ValidateRun.
+func ValidatePipelineRun(ctx context.Context, uns *unstructured.Unstructured) error {
+	pipelineRun := &v1beta1.PipelineRun{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(uns.UnstructuredContent(), pipelineRun); err != nil {
+		return fmt.Errorf("couldn't convert the PipelineRun: %w", err)
+	}
+	if pipelineRun.Spec.PipelineSpec == nil {
+		return nil
+	}
+
+	// The Runs are annotated with the annotations of the PipelineRun
+	annotations := pipelineRun.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	errs, err := validatePipelineSpec(ctx, pipelineRun.Namespace, pipelineRun.Spec.PipelineSpec, annotations)
+	if err != nil {
+		return err
+	}
+	if errs != nil {
+		return errs.ViaField("spec", "pipelineSpec")
+	}
+	return nil
+}
+
+// validatePipelineSpec validates the CEL expressions of the pipeline tasks referencing a VariableStore, or the
+// CEL custom task.
+// The params holding variables substituted by Tekton can only be validated once the Run is created.
+func validatePipelineSpec(ctx context.Context, namespace string, spec *v1beta1.PipelineSpec, annotations map[string]string) (*apis.FieldError, error) {
+	upstream := upstreamTasks(spec)
+	var errs *apis.FieldError
+	for _, field := range []struct {
+		name  string
+		tasks []v1beta1.PipelineTask
+	}{{"tasks", spec.Tasks}, {"finally", spec.Finally}} {
+		for i, task := range field.tasks {
+			if task.TaskRef == nil {
+				continue
+			}
+			stateless := referencesCELTask(task.TaskRef.APIVersion, task.TaskRef.Kind)
+			if !stateless && !referencesVariableStore(task.TaskRef.APIVersion, task.TaskRef.Kind) {
+				continue
+			}
+
+			taskErrs, err := expressions{
+				namespace:     namespace,
+				store:         task.TaskRef.Name,
+				stateless:     stateless,
+				params:        task.Params,
+				written:       writtenBefore(spec, upstream[task.Name], task.TaskRef),
+				annotations:   annotations,
+				substitutions: true,
+			}.validate(ctx)
+			if err != nil {
+				return nil, err
+			}
+			errs = errs.Also(taskErrs.ViaFieldIndex(field.name, i))
+		}
+	}
+	return errs, nil
+}
+
+// upstreamTasks returns the names of the tasks each pipeline task runs after, directly or not: the tasks of
+// its runAfter, the tasks whose results or resources it uses, and the tasks running before these. The finally
+// tasks run after every task.
+func upstreamTasks(spec *v1beta1.PipelineSpec) map[string]map[string]struct{} {
+	deps := v1beta1.PipelineTaskList(spec.Tasks).Deps()
+	upstream := map[string]map[string]struct{}{}
+	var visit func(name string) map[string]struct{}
+	visit = func(name string) map[string]struct{} {
+		if before, ok := upstream[name]; ok {
+			return before
+		}
+		before := map[string]struct{}{}
+		// A cycle, rejected by Tekton, doesn't recurse forever
+		upstream[name] = before
+		for _, dep := range deps[name] {
+			before[dep] = struct{}{}
+			for transitive := range visit(dep) {
+				before[transitive] = struct{}{}
+			}
+		}
+		return before
+	}
+	all := map[string]struct{}{}
+	for _, task := range spec.Tasks {
+		visit(task.Name)
+		all[task.Name] = struct{}{}
+	}
+	for _, task := range spec.Finally {
+		upstream[task.Name] = all
+	}
+	return upstream
+}
+
+// writtenBefore returns the names of the params the pipeline tasks in before write to the VariableStore
+// referenced by ref.
+func writtenBefore(spec *v1beta1.PipelineSpec, before map[string]struct{}, ref *v1beta1.TaskRef) []string {
+	if ref.Name == "" || !referencesVariableStore(ref.APIVersion, ref.Kind) {
+		return nil
+	}
+	var written []string
+	for _, task := range spec.Tasks {
+		if _, ok := before[task.Name]; !ok || task.TaskRef == nil || task.TaskRef.Name != ref.Name ||
+			!referencesVariableStore(task.TaskRef.APIVersion, task.TaskRef.Kind) {
+			continue
+		}
+		written = append(written, paramNames(task.Params)...)
+	}
+	return written
+}
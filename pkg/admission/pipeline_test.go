@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPipelineSpec(params ...v1beta1.Param) *v1beta1.PipelineSpec {
+	return &v1beta1.PipelineSpec{
+		Tasks: []v1beta1.PipelineTask{{
+			Name:    "build",
+			TaskRef: &v1beta1.TaskRef{Name: "build"},
+		}, {
+			Name: "vars",
+			TaskRef: &v1beta1.TaskRef{
+				APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(),
+				Kind:       "VariableStore",
+				Name:       "store",
+			},
+			Params: params,
+		}},
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	ctx := newContext(t)
+	for _, tc := range []struct {
+		name    string
+		params  []v1beta1.Param
+		wantErr string
+	}{{
+		name:   "valid",
+		params: []v1beta1.Param{param("c", "a == '1' && tasks['build'].status == 'Succeeded'")},
+	}, {
+		name:   "substitutions",
+		params: []v1beta1.Param{param("c", "$(params.threshold) > 3")},
+	}, {
+		// The annotations of the PipelineRuns are unknown, they might opt in to partial evaluation
+		name:   "undeclared reference",
+		params: []v1beta1.Param{param("c", "missing == 'x'")},
+	}, {
+		name:    "syntax error",
+		params:  []v1beta1.Param{param("c", "$(params.threshold) > 3"), param("d", "a ==")},
+		wantErr: "invalid CEL expression: spec.tasks[1].params[1].value",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := &v1beta1.Pipeline{
+				ObjectMeta: metav1.ObjectMeta{Name: "pipeline", Namespace: "team-a"},
+				Spec:       *newPipelineSpec(tc.params...),
+			}
+			checkErr(t, ValidatePipeline(ctx, toUnstructured(t, pipeline)), tc.wantErr)
+		})
+	}
+}
+
+func TestValidatePipelineRun(t *testing.T) {
+	ctx := newContext(t)
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		spec        *v1beta1.PipelineSpec
+		wantErr     string
+	}{{
+		name: "pipeline reference",
+	}, {
+		name: "valid",
+		spec: newPipelineSpec(param("c", "a == '1'")),
+	}, {
+		name:    "undeclared reference",
+		spec:    newPipelineSpec(param("c", "missing == 'x'")),
+		wantErr: "invalid CEL expression: spec.pipelineSpec.tasks[1].params[0].value",
+	}, {
+		name:        "undeclared reference with partial evaluation",
+		annotations: map[string]string{"custom.tekton.dev/unknowns": "Fail"},
+		spec:        newPipelineSpec(param("c", "missing == 'x'")),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelineRun := &v1beta1.PipelineRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun", Namespace: "team-a", Annotations: tc.annotations},
+				Spec: v1beta1.PipelineRunSpec{
+					PipelineRef:  &v1beta1.PipelineRef{Name: "pipeline"},
+					PipelineSpec: tc.spec,
+				},
+			}
+			if tc.spec != nil {
+				pipelineRun.Spec.PipelineRef = nil
+			}
+			checkErr(t, ValidatePipelineRun(ctx, toUnstructured(t, pipelineRun)), tc.wantErr)
+		})
+	}
+}
+
+func storeTask(name, store string, runAfter []string, params ...v1beta1.Param) v1beta1.PipelineTask {
+	return v1beta1.PipelineTask{
+		Name: name,
+		TaskRef: &v1beta1.TaskRef{
+			APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(),
+			Kind:       "VariableStore",
+			Name:       store,
+		},
+		RunAfter: runAfter,
+		Params:   params,
+	}
+}
+
+func TestValidatePipelineRunChained(t *testing.T) {
+	ctx := newContext(t)
+	first := storeTask("first", "store", nil, param("is_red", "a == '1'"))
+	for _, tc := range []struct {
+		name    string
+		spec    v1beta1.PipelineSpec
+		wantErr string
+	}{{
+		name: "run after",
+		spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{
+			first,
+			storeTask("second", "store", []string{"first"}, param("color", "is_red == 'true' ? 'red' : 'blue'")),
+		}},
+	}, {
+		name: "run after transitively",
+		spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{
+			first,
+			{Name: "build", TaskRef: &v1beta1.TaskRef{Name: "build"}, RunAfter: []string{"first"}},
+			storeTask("second", "store", []string{"build"}, param("color", "is_red == 'true' ? 'red' : 'blue'")),
+		}},
+	}, {
+		name: "result reference",
+		spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{
+			first,
+			storeTask("second", "store", nil, param("seen", "'$(tasks.first.results.is_red)'"), param("color", "is_red")),
+		}},
+	}, {
+		name: "finally",
+		spec: v1beta1.PipelineSpec{
+			Tasks:   []v1beta1.PipelineTask{first},
+			Finally: []v1beta1.PipelineTask{storeTask("report", "store", nil, param("color", "is_red"))},
+		},
+	}, {
+		name: "parallel",
+		spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{
+			first,
+			storeTask("second", "store", nil, param("color", "is_red")),
+		}},
+		wantErr: "undeclared reference to 'is_red'",
+	}, {
+		name: "other existing store",
+		spec: v1beta1.PipelineSpec{Tasks: []v1beta1.PipelineTask{
+			storeTask("first", "missing", nil, param("is_red", "'true'")),
+			storeTask("second", "store", []string{"first"}, param("color", "is_red")),
+		}},
+		wantErr: "undeclared reference to 'is_red'",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelineRun := &v1beta1.PipelineRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun", Namespace: "team-a"},
+				Spec:       v1beta1.PipelineRunSpec{PipelineSpec: &tc.spec},
+			}
+			checkErr(t, ValidatePipelineRun(ctx, toUnstructured(t, pipelineRun)), tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admission holds the validation callbacks of the webhook for the resources cel-tekton does not own,
+// like the Runs referencing a VariableStore, and the admission controller serving them.
+package admission
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ValidateRun is a validation callback for Runs. It rejects the Runs referencing a VariableStore, or of the
+// CEL custom task, whose CEL expressions are invalid, call a function denied in their namespace, or whose
+// estimated cost exceeds the cost limits of their namespace. The cost is estimated from the number of
+// variables of the VariableStore and the Run.
+//
+// The VariableStore client is looked up in the context.
+func ValidateRun(ctx context.Context, uns *unstructured.Unstructured) error {
+	run := &v1alpha1.Run{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(uns.UnstructuredContent(), run); err != nil {
+		return fmt.Errorf("couldn't convert the Run: %w", err)
+	}
+	if run.Spec.Ref == nil {
+		return nil
+	}
+	stateless := referencesCELTask(run.Spec.Ref.APIVersion, run.Spec.Ref.Kind)
+	if !stateless && !referencesVariableStore(run.Spec.Ref.APIVersion, run.Spec.Ref.Kind) {
+		return nil
+	}
+
+	annotations := run.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	errs, err := expressions{
+		namespace:   run.Namespace,
+		store:       run.Spec.Ref.Name,
+		stateless:   stateless,
+		params:      run.Spec.Params,
+		annotations: annotations,
+	}.validate(ctx)
+	if err != nil {
+		return err
+	}
+	if errs != nil {
+		return errs.ViaField("spec")
+	}
+	return nil
+}
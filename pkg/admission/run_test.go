@@ -0,0 +1,232 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariablestoreclient "github.com/vincentpli/cel-tekton/pkg/client/injection/client/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func toUnstructured(t *testing.T, obj interface{}) *unstructured.Unstructured {
+	t.Helper()
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatalf("ToUnstructured() = %v", err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func newRun(store, kind string, annotations map[string]string, params []v1beta1.Param) *v1alpha1.Run {
+	return &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "team-a", Annotations: annotations},
+		Spec: v1alpha1.RunSpec{
+			Ref: &v1alpha1.TaskRef{
+				APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(),
+				Kind:       v1beta1.TaskKind(kind),
+				Name:       store,
+			},
+			Params: params,
+		},
+	}
+}
+
+func param(name, value string) v1beta1.Param {
+	return v1beta1.Param{Name: name, Value: *v1beta1.NewArrayOrString(value)}
+}
+
+func arrayParam(name string, values ...string) v1beta1.Param {
+	return v1beta1.Param{Name: name, Value: *v1beta1.NewArrayOrString(values[0], values[1:]...)}
+}
+
+func newContext(t *testing.T) context.Context {
+	t.Helper()
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "team-a"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{
+			Vars: []variablestorev1alpha1.Var{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+		},
+	}
+	limits, err := config.NewLimitsFromMap(map[string]string{
+		"expression-cost-limit": "50",
+		"run-cost-limit":        "60",
+		"max-list-size":         "1000",
+		"denied-functions":      "matches",
+	})
+	if err != nil {
+		t.Fatalf("NewLimitsFromMap() = %v", err)
+	}
+	ctx, _ := fakevariablestoreclient.With(context.Background(), store)
+	return config.ToContext(ctx, &config.Config{Limits: limits})
+}
+
+func checkErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("got error %v", err)
+	case wantErr != "" && err == nil:
+		t.Errorf("succeeded, want error %q", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Errorf("got error %v, want error %q", err, wantErr)
+	}
+}
+
+func TestValidateRun(t *testing.T) {
+	ctx := newContext(t)
+	for _, tc := range []struct {
+		name        string
+		store       string
+		apiVersion  string
+		kind        string
+		annotations map[string]string
+		params      []v1beta1.Param
+		wantErr     string
+	}{{
+		name:   "cheap",
+		kind:   "VariableStore",
+		params: []v1beta1.Param{param("c", "a + b"), param("d", "vars.all(k, k != 'c') && c == '12'")},
+	}, {
+		name:       "v1beta1 reference",
+		apiVersion: "custom.tekton.dev/v1beta1",
+		kind:       "VariableStore",
+		params:     []v1beta1.Param{param("c", "missing == 'x'")},
+		wantErr:    "ERROR: <input>:1:1: undeclared reference to 'missing'",
+	}, {
+		name:       "CEL custom task",
+		apiVersion: "cel.tekton.dev/v1alpha1",
+		kind:       "CEL",
+		params:     []v1beta1.Param{param("c", "run.name == 'run'")},
+	}, {
+		name:       "CEL custom task without variables",
+		apiVersion: "cel.tekton.dev/v1alpha1",
+		kind:       "CEL",
+		params:     []v1beta1.Param{param("c", "a == '1'")},
+		wantErr:    "ERROR: <input>:1:1: undeclared reference to 'a'",
+	}, {
+		name:   "not a VariableStore",
+		kind:   "Other",
+		params: []v1beta1.Param{param("c", "a.matches('^1')")},
+	}, {
+		name:    "syntax error",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "a == 'x'"), param("d", "a ==")},
+		wantErr: "invalid CEL expression: spec.params[1].value\nERROR: <input>:1:5: Syntax error",
+	}, {
+		name:    "undeclared reference",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "missing == 'x'")},
+		wantErr: "ERROR: <input>:1:1: undeclared reference to 'missing'",
+	}, {
+		name:    "type error",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "run.name + 1 == 'x'")},
+		wantErr: "invalid CEL expression: spec.params[0].value",
+	}, {
+		name:    "array param",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{arrayParam("c", "a + b", "a =="), param("d", "c[0] == '12'")},
+		wantErr: "invalid CEL expression: spec.params[0].value[1]",
+	}, {
+		name:        "literal array param",
+		kind:        "VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/array-params": "Literals"},
+		params:      []v1beta1.Param{arrayParam("c", "a + b", "a =="), param("d", "c[0] == 'a + b'")},
+	}, {
+		name:        "undeclared reference with partial evaluation",
+		kind:        "VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/unknowns": "Succeed"},
+		params:      []v1beta1.Param{param("c", "missing == 'x'")},
+	}, {
+		name:        "undeclared reference waiting for it",
+		kind:        "VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "has(vars.approved)"},
+		params:      []v1beta1.Param{param("c", "approved == 'yes'")},
+	}, {
+		name:        "undeclared reference in a transaction",
+		kind:        "VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/transaction": "true"},
+		params:      []v1beta1.Param{param("c", "staged == 'x'")},
+	}, {
+		name:        "undeclared reference in an ephemeral VariableStore",
+		kind:        "VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/ephemeral": "true"},
+		params:      []v1beta1.Param{param("c", "attempts == '2'")},
+	}, {
+		name:        "CEL custom task ignoring wait-for",
+		apiVersion:  "cel.tekton.dev/v1alpha1",
+		kind:        "CEL",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "has(vars.approved)"},
+		params:      []v1beta1.Param{param("c", "approved == 'yes'")},
+		wantErr:     "ERROR: <input>:1:1: undeclared reference to 'approved'",
+	}, {
+		name:   "undeclared reference to a missing store",
+		store:  "missing",
+		kind:   "VariableStore",
+		params: []v1beta1.Param{param("c", "a == 'x'")},
+	}, {
+		name:        "tasks",
+		kind:        "VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/tasks": "true"},
+		params:      []v1beta1.Param{param("c", "tasks['build'].status == 'Succeeded'")},
+	}, {
+		name:   "param hiding run",
+		kind:   "VariableStore",
+		params: []v1beta1.Param{param("run", "'fast'"), param("c", "run == 'fast'")},
+	}, {
+		name:    "denied function",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "a.matches('^1')")},
+		wantErr: "calls the function matches, which is denied in namespace team-a: spec.params[0].value",
+	}, {
+		name:    "expression cost limit",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "[1, 2, 3].map(x, [4, 5, 6, 7, 8].map(y, x * y))")},
+		wantErr: "exceeds the expression cost limit 50 of namespace team-a: spec.params[0].value",
+	}, {
+		name:    "unknown sizes",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "run.labels.exists(k, k == 'x')")},
+		wantErr: "exceeds the expression cost limit 50",
+	}, {
+		name:    "run cost limit",
+		kind:    "VariableStore",
+		params:  []v1beta1.Param{param("c", "vars.all(k, k != 'c')"), param("d", "vars.all(k, k != 'd')"), param("e", "vars.all(k, k != 'e')")},
+		wantErr: "exceeds the run cost limit 60 of namespace team-a: spec.params",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.store
+			if store == "" {
+				store = "store"
+			}
+			run := newRun(store, tc.kind, tc.annotations, tc.params)
+			if tc.apiVersion != "" {
+				run.Spec.Ref.APIVersion = tc.apiVersion
+			}
+			checkErr(t, ValidateRun(ctx, toUnstructured(t, run)), tc.wantErr)
+		})
+	}
+}
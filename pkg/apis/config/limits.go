@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	expressionCostLimitKey = "expression-cost-limit"
+	runCostLimitKey        = "run-cost-limit"
+	maxListSizeKey         = "max-list-size"
+	deniedFunctionsKey     = "denied-functions"
+	maxValueSizeKey        = "max-value-size"
+	maxStoreSizeKey        = "max-store-size"
+	namespacesKey          = "namespaces"
+
+	// DefaultExpressionCostLimit is the default limit on the cost of the evaluation of a CEL expression.
+	DefaultExpressionCostLimit int64 = 100000
+
+	// DefaultRunCostLimit is the default limit on the cost of the evaluation of all the CEL expressions of a Run.
+	DefaultRunCostLimit int64 = 1000000
+
+	// DefaultMaxListSize is the default number of elements assumed for the lists and maps of unknown size
+	// when estimating the cost of CEL expressions at admission.
+	DefaultMaxListSize int64 = 100
+
+	// DefaultMaxValueSize is the default limit on the size in bytes of the value of a variable of a VariableStore.
+	DefaultMaxValueSize int64 = 64 * 1024
+
+	// DefaultMaxStoreSize is the default limit on the size in bytes of the variables of a VariableStore, well
+	// below the 1.5 MiB limit of etcd on the size of an object.
+	DefaultMaxStoreSize int64 = 1024 * 1024
+)
+
+// CostLimits holds the limits on the cost of the evaluation of CEL expressions, and on the functions they
+// may call. The cost is the number of sub-expressions evaluated, each iteration of a comprehension like
+// `map` or `filter` counting again. A limit of 0 disables it.
+type CostLimits struct {
+	// ExpressionCostLimit is the limit on the cost of the evaluation of a CEL expression.
+	ExpressionCostLimit int64 `json:"expression-cost-limit"`
+
+	// RunCostLimit is the limit on the cost of the evaluation of all the CEL expressions of a Run.
+	RunCostLimit int64 `json:"run-cost-limit"`
+
+	// MaxListSize is the number of elements assumed for the lists and maps whose size is unknown when
+	// estimating the cost of CEL expressions at admission.
+	MaxListSize int64 `json:"max-list-size"`
+
+	// DeniedFunctions holds the names of the functions CEL expressions must not call, like `matches`.
+	DeniedFunctions []string `json:"denied-functions"`
+}
+
+// Limits holds the limits configuration: the default limits, and the limits of the namespaces which
+// override them.
+type Limits struct {
+	CostLimits
+
+	// MaxValueSize is the limit on the size in bytes of the value of a variable of a VariableStore.
+	MaxValueSize int64
+
+	// MaxStoreSize is the limit on the size in bytes of the variables of a VariableStore, as serialized, and of
+	// the variables along with its locks, applied Runs and overlays.
+	MaxStoreSize int64
+
+	// Namespaces holds the limits of the namespaces overriding the default ones.
+	Namespaces map[string]CostLimits
+}
+
+// namespaceLimits holds the limits of a namespace as configured, the unset ones default to the
+// default limits.
+type namespaceLimits struct {
+	ExpressionCostLimit *int64    `json:"expression-cost-limit,omitempty"`
+	RunCostLimit        *int64    `json:"run-cost-limit,omitempty"`
+	MaxListSize         *int64    `json:"max-list-size,omitempty"`
+	DeniedFunctions     *[]string `json:"denied-functions,omitempty"`
+}
+
+// GetLimitsConfigName returns the name of the configmap containing the limits.
+func GetLimitsConfigName() string {
+	if e := os.Getenv("CONFIG_LIMITS_NAME"); e != "" {
+		return e
+	}
+	return "config-limits"
+}
+
+// NewLimitsFromMap returns a Limits given a map corresponding to a ConfigMap
+func NewLimitsFromMap(cfgMap map[string]string) (*Limits, error) {
+	limits := &Limits{
+		CostLimits: CostLimits{
+			ExpressionCostLimit: DefaultExpressionCostLimit,
+			RunCostLimit:        DefaultRunCostLimit,
+			MaxListSize:         DefaultMaxListSize,
+		},
+		MaxValueSize: DefaultMaxValueSize,
+		MaxStoreSize: DefaultMaxStoreSize,
+		Namespaces:   map[string]CostLimits{},
+	}
+
+	setLimit := func(key string, limit *int64) error {
+		if cfg, ok := cfgMap[key]; ok {
+			value, err := strconv.ParseInt(cfg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed parsing limits config %q: %v", cfg, err)
+			}
+			if value < 0 {
+				return fmt.Errorf("limits config %s must not be negative, got %d", key, value)
+			}
+			*limit = value
+		}
+		return nil
+	}
+	if err := setLimit(expressionCostLimitKey, &limits.ExpressionCostLimit); err != nil {
+		return nil, err
+	}
+	if err := setLimit(runCostLimitKey, &limits.RunCostLimit); err != nil {
+		return nil, err
+	}
+	if err := setLimit(maxListSizeKey, &limits.MaxListSize); err != nil {
+		return nil, err
+	}
+	if err := setLimit(maxValueSizeKey, &limits.MaxValueSize); err != nil {
+		return nil, err
+	}
+	if err := setLimit(maxStoreSizeKey, &limits.MaxStoreSize); err != nil {
+		return nil, err
+	}
+	if cfg, ok := cfgMap[deniedFunctionsKey]; ok {
+		for _, name := range strings.Split(cfg, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				limits.DeniedFunctions = append(limits.DeniedFunctions, name)
+			}
+		}
+	}
+
+	if cfg, ok := cfgMap[namespacesKey]; ok {
+		namespaces := map[string]namespaceLimits{}
+		if err := yaml.UnmarshalStrict([]byte(cfg), &namespaces); err != nil {
+			return nil, fmt.Errorf("failed parsing limits config %s: %v", namespacesKey, err)
+		}
+		for namespace, nsLimits := range namespaces {
+			costLimits := limits.CostLimits
+			if nsLimits.ExpressionCostLimit != nil {
+				costLimits.ExpressionCostLimit = *nsLimits.ExpressionCostLimit
+			}
+			if nsLimits.RunCostLimit != nil {
+				costLimits.RunCostLimit = *nsLimits.RunCostLimit
+			}
+			if nsLimits.MaxListSize != nil {
+				costLimits.MaxListSize = *nsLimits.MaxListSize
+			}
+			if nsLimits.DeniedFunctions != nil {
+				costLimits.DeniedFunctions = *nsLimits.DeniedFunctions
+			}
+			if costLimits.ExpressionCostLimit < 0 || costLimits.RunCostLimit < 0 || costLimits.MaxListSize < 0 {
+				return nil, fmt.Errorf("limits config of namespace %s must not be negative", namespace)
+			}
+			limits.Namespaces[namespace] = costLimits
+		}
+	}
+
+	return limits, nil
+}
+
+// NewLimitsFromConfigMap returns a Limits for the given configmap
+func NewLimitsFromConfigMap(config *corev1.ConfigMap) (*Limits, error) {
+	return NewLimitsFromMap(config.Data)
+}
+
+// ForNamespace returns the limits applying to the Runs of the namespace.
+func (l *Limits) ForNamespace(namespace string) CostLimits {
+	if costLimits, ok := l.Namespaces[namespace]; ok {
+		return costLimits
+	}
+	return l.CostLimits
+}
+
+// Denies returns whether CEL expressions must not call the function.
+func (cl CostLimits) Denies(function string) bool {
+	for _, name := range cl.DeniedFunctions {
+		if name == function {
+			return true
+		}
+	}
+	return false
+}
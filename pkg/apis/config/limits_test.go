@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewLimitsFromMap(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data map[string]string
+		want *Limits
+	}{{
+		name: "defaults",
+		data: map[string]string{},
+		want: &Limits{
+			CostLimits:   CostLimits{ExpressionCostLimit: DefaultExpressionCostLimit, RunCostLimit: DefaultRunCostLimit, MaxListSize: DefaultMaxListSize},
+			MaxValueSize: DefaultMaxValueSize,
+			MaxStoreSize: DefaultMaxStoreSize,
+			Namespaces:   map[string]CostLimits{},
+		},
+	}, {
+		name: "namespaces",
+		data: map[string]string{
+			expressionCostLimitKey: "1000",
+			runCostLimitKey:        "0",
+			maxListSizeKey:         "10",
+			deniedFunctionsKey:     "matches, base64.decode",
+			maxValueSizeKey:        "100",
+			maxStoreSizeKey:        "0",
+			namespacesKey:          "team-a:\n  expression-cost-limit: 10\n  denied-functions: []\nteam-b:\n  run-cost-limit: 20\n  max-list-size: 5\n",
+		},
+		want: &Limits{
+			CostLimits:   CostLimits{ExpressionCostLimit: 1000, RunCostLimit: 0, MaxListSize: 10, DeniedFunctions: []string{"matches", "base64.decode"}},
+			MaxValueSize: 100,
+			MaxStoreSize: 0,
+			Namespaces: map[string]CostLimits{
+				"team-a": {ExpressionCostLimit: 10, RunCostLimit: 0, MaxListSize: 10, DeniedFunctions: []string{}},
+				"team-b": {ExpressionCostLimit: 1000, RunCostLimit: 20, MaxListSize: 5, DeniedFunctions: []string{"matches", "base64.decode"}},
+			},
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewLimitsFromMap(tc.data)
+			if err != nil {
+				t.Fatalf("NewLimitsFromMap() = %v", err)
+			}
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("NewLimitsFromMap() diff (-want, +got): %s", d)
+			}
+		})
+	}
+}
+
+func TestNewLimitsFromMapInvalid(t *testing.T) {
+	for _, data := range []map[string]string{
+		{expressionCostLimitKey: "many"},
+		{runCostLimitKey: "-1"},
+		{namespacesKey: "team-a:\n  expression-cost-limit: -1\n"},
+		{namespacesKey: "team-a:\n  cost-limit: 10\n"},
+		{maxListSizeKey: "-1"},
+		{maxStoreSizeKey: "1MiB"},
+	} {
+		if _, err := NewLimitsFromMap(data); err == nil {
+			t.Errorf("NewLimitsFromMap(%v) succeeded, want error", data)
+		}
+	}
+}
+
+func TestLimitsForNamespace(t *testing.T) {
+	limits := &Limits{
+		CostLimits: CostLimits{ExpressionCostLimit: 1000, RunCostLimit: 2000},
+		Namespaces: map[string]CostLimits{"team-a": {ExpressionCostLimit: 10, RunCostLimit: 20}},
+	}
+	if d := cmp.Diff(CostLimits{ExpressionCostLimit: 10, RunCostLimit: 20}, limits.ForNamespace("team-a")); d != "" {
+		t.Errorf("ForNamespace(team-a) diff (-want, +got): %s", d)
+	}
+	if d := cmp.Diff(limits.CostLimits, limits.ForNamespace("team-b")); d != "" {
+		t.Errorf("ForNamespace(team-b) diff (-want, +got): %s", d)
+	}
+}
+
+func TestCostLimitsDenies(t *testing.T) {
+	limits := CostLimits{DeniedFunctions: []string{"matches"}}
+	if !limits.Denies("matches") {
+		t.Error("Denies(matches) = false, want true")
+	}
+	if limits.Denies("contains") {
+		t.Error("Denies(contains) = true, want false")
+	}
+}
@@ -18,4 +18,74 @@ package variablestores
 
 const (
 	GroupName = "custom.tekton.dev"
+
+	// TasksAnnotationKey is the annotation on a Run which opts in to reading the results
+	// and status of the other tasks of its PipelineRun through the `tasks` variable.
+	TasksAnnotationKey = GroupName + "/tasks"
+
+	// UnknownsAnnotationKey is the annotation on a Run which opts in to partial evaluation: the
+	// variables its expressions reference but which are missing from the context are treated as
+	// unknowns instead of failing the Run. Its value, Fail or Succeed, sets whether the Run fails
+	// or succeeds when some expressions could only be partially evaluated.
+	UnknownsAnnotationKey = GroupName + "/unknowns"
+
+	// TraceAnnotationKey is the annotation on a Run which turns on the tracing of the evaluation of
+	// its CEL expressions, reported in the extraFields of its status.
+	TraceAnnotationKey = GroupName + "/trace"
+
+	// ArrayParamsAnnotationKey is the annotation on a Run which sets how its array params are evaluated:
+	// Expressions, the default, evaluates every element as a CEL expression, and Literals takes the
+	// elements as they are.
+	ArrayParamsAnnotationKey = GroupName + "/array-params"
+
+	// ExpandAnnotationKey is the annotation on a Run which lists the params, separated by commas, whose
+	// values are expanded into a result and a variable per entry when they are maps or lists, named after
+	// the param and the key or index of the entry, e.g. `decision.region` or `targets.0`.
+	ExpandAnnotationKey = GroupName + "/expand"
+
+	// WaitForAnnotationKey is the annotation on a Run which holds a CEL condition over the variables of its
+	// VariableStore, e.g. `approved == 'true'`. The Run stays Running until the condition is true, and only
+	// then evaluates its expressions. The variables the condition references but which the VariableStore
+	// doesn't hold yet are unknown, and an unknown condition isn't true.
+	WaitForAnnotationKey = GroupName + "/wait-for"
+
+	// WaitTimeoutAnnotationKey is the annotation on a Run which sets how long it waits for the condition of
+	// WaitForAnnotationKey to be true before failing, as a duration like `30m`. Defaults to 1h.
+	WaitTimeoutAnnotationKey = GroupName + "/wait-timeout"
+
+	// AcquireAnnotationKey is the annotation on a Run which names the lock of its VariableStore it acquires
+	// before evaluating its expressions. The Run stays Running until the lock has room for it, at most for
+	// the timeout of WaitTimeoutAnnotationKey. A Run owned by a PipelineRun acquires the lock on behalf of
+	// its PipelineRun, which holds it until it finishes or another of its Runs releases it. Any other Run
+	// holds it until it is deleted.
+	AcquireAnnotationKey = GroupName + "/acquire"
+
+	// CapacityAnnotationKey is the annotation on a Run acquiring a lock which sets how many holders can
+	// hold the lock at the same time. Defaults to 1, a mutex.
+	CapacityAnnotationKey = GroupName + "/capacity"
+
+	// ReleaseAnnotationKey is the annotation on a Run which names the lock of its VariableStore it releases,
+	// on behalf of its PipelineRun, before evaluating its expressions.
+	ReleaseAnnotationKey = GroupName + "/release"
+
+	// OperationsAnnotationKey is the annotation on a Run which lists the params, separated by commas, whose
+	// values are written to the VariableStore with an operation other than overwriting the variable, as
+	// `param=Operation`, e.g. `builds=Add,versions=Append`. The operations are applied to the latest version
+	// of the VariableStore, so that concurrent Runs don't lose each other's updates.
+	OperationsAnnotationKey = GroupName + "/operations"
+
+	// TransactionAnnotationKey is the annotation on a Run owned by a PipelineRun which opts in to staging its
+	// writes in the overlay of its PipelineRun in the VariableStore, which only the Runs of the PipelineRun
+	// see. The overlay is committed to the variables of the VariableStore when the PipelineRun succeeds, and
+	// discarded when it fails, is cancelled or is deleted.
+	TransactionAnnotationKey = GroupName + "/transaction"
+
+	// EphemeralAnnotationKey is the annotation on a Run owned by a PipelineRun which opts in to reading and
+	// writing the ephemeral VariableStore of its PipelineRun instead of the VariableStore it references. The
+	// ephemeral VariableStore is created on first use, seeded with the variables of the referenced
+	// VariableStore, if any, and owned by the PipelineRun so that it is garbage collected along with it.
+	EphemeralAnnotationKey = GroupName + "/ephemeral"
+
+	// PipelineRunLabelKey is the label on an ephemeral VariableStore which holds the name of its PipelineRun.
+	PipelineRunLabelKey = GroupName + "/pipelineRun"
 )
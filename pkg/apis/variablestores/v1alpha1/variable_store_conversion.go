@@ -0,0 +1,35 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+)
+
+// ConvertTo implements apis.Convertible. v1beta1 is the hub version of the conversion webhook, which
+// converts from and to v1alpha1 itself.
+func (vs *VariableStore) ConvertTo(ctx context.Context, to apis.Convertible) error {
+	return fmt.Errorf("v1alpha1 is not the hub version, got: %T", to)
+}
+
+// ConvertFrom implements apis.Convertible
+func (vs *VariableStore) ConvertFrom(ctx context.Context, from apis.Convertible) error {
+	return fmt.Errorf("v1alpha1 is not the hub version, got: %T", from)
+}
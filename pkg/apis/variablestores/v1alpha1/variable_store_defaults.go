@@ -18,9 +18,85 @@ package v1alpha1
 
 import (
 	"context"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // SetDefaults implements apis.Defaultable
-func (as *VariableStore) SetDefaults(ctx context.Context) {
-	// Nothing to default.
+func (vs *VariableStore) SetDefaults(ctx context.Context) {
+	vs.Spec.SetDefaults(ctx)
+}
+
+// SetDefaults infers the types of the variables which don't declare one, canonicalizes their values, e.g.
+// `0.50` as `0.5` for doubles, and sorts them by name, so that equivalent VariableStores read the same.
+// The variables of the overlays are defaulted the same way.
+func (vss *VariableStoreSpec) SetDefaults(ctx context.Context) {
+	setVarsDefaults(ctx, vss.Vars)
+	for i := range vss.Overlays {
+		setVarsDefaults(ctx, vss.Overlays[i].Vars)
+	}
+}
+
+func setVarsDefaults(ctx context.Context, vars []Var) {
+	for i := range vars {
+		vars[i].SetDefaults(ctx)
+	}
+	sort.SliceStable(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+}
+
+// SetDefaults infers the type of the variable from its value when it is not set, and canonicalizes its
+// value according to its type.
+func (v *Var) SetDefaults(ctx context.Context) {
+	if v.Type == "" {
+		v.Type = InferVarType(v.Value)
+	}
+	if value, ok := CanonicalValue(v.Type, v.Value); ok {
+		v.Value = value
+	}
+}
+
+// InferVarType returns the type of the value. Only the values already in the canonical form of a type are
+// inferred of that type: `true` and `3` are a bool and an int, but `yes`, `1` and `007` are a string, an int
+// and a string. The values are exposed to expressions as strings, so that inferring a type which rewrote
+// them, e.g. `yes` as `true`, would change what they compare equal to.
+func InferVarType(value string) VarType {
+	for _, t := range []VarType{VarTypeBool, VarTypeInt, VarTypeDouble} {
+		if canonical, ok := CanonicalValue(t, value); ok && canonical == value {
+			return t
+		}
+	}
+	return VarTypeString
+}
+
+// CanonicalValue returns the canonical form of the value of the type, and whether the value is valid for
+// the type. The booleans are `true` and `false`, in any case.
+func CanonicalValue(t VarType, value string) (string, bool) {
+	switch t {
+	case VarTypeString:
+		return value, true
+	case VarTypeBool:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true":
+			return "true", true
+		case "false":
+			return "false", true
+		}
+	case VarTypeInt:
+		if i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			return strconv.FormatInt(i, 10), true
+		}
+	case VarTypeDouble:
+		// Only plain numbers, not `Inf` or `NaN` which ParseFloat accepts too
+		value = strings.TrimSpace(value)
+		if strings.IndexFunc(value, func(r rune) bool { return strings.IndexRune("0123456789+-.eE", r) < 0 }) >= 0 {
+			return "", false
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64), true
+		}
+	}
+	return "", false
 }
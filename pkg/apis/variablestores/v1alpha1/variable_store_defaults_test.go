@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/yaml"
+)
+
+func TestVarUnmarshal(t *testing.T) {
+	spec := VariableStoreSpec{}
+	if err := yaml.Unmarshal([]byte(`
+vars:
+- name: alert_enable
+  value: yes
+- name: replicas
+  value: 3
+- name: ratio
+  value: 0.5
+- name: version
+  value: 1.0
+  type: string
+- name: job_priority
+  value: high
+`), &spec); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	want := []Var{
+		{Name: "alert_enable", Value: "true", Type: VarTypeBool},
+		{Name: "replicas", Value: "3", Type: VarTypeInt},
+		{Name: "ratio", Value: "0.5", Type: VarTypeDouble},
+		{Name: "version", Value: "1", Type: VarTypeString},
+		{Name: "job_priority", Value: "high"},
+	}
+	if d := cmp.Diff(want, spec.Vars); d != "" {
+		t.Errorf("Unmarshal() diff (-want, +got): %s", d)
+	}
+}
+
+func TestVariableStoreSetDefaults(t *testing.T) {
+	vs := &VariableStore{
+		Spec: VariableStoreSpec{Vars: []Var{
+			{Name: "replicas", Value: "3"},
+			{Name: "alert_enable", Value: "True", Type: VarTypeBool},
+			{Name: "zip", Value: "007"},
+			{Name: "ratio", Value: "0.50", Type: VarTypeDouble},
+			{Name: "enabled", Value: "false"},
+			{Name: "answer", Value: "yes"},
+			{Name: "debug", Value: "Off"},
+			{Name: "letter", Value: "y"},
+			{Name: "timeout", Value: "1h"},
+		}},
+	}
+	vs.SetDefaults(context.Background())
+	want := []Var{
+		{Name: "alert_enable", Value: "true", Type: VarTypeBool},
+		{Name: "answer", Value: "yes", Type: VarTypeString},
+		{Name: "debug", Value: "Off", Type: VarTypeString},
+		{Name: "enabled", Value: "false", Type: VarTypeBool},
+		{Name: "letter", Value: "y", Type: VarTypeString},
+		{Name: "ratio", Value: "0.5", Type: VarTypeDouble},
+		{Name: "replicas", Value: "3", Type: VarTypeInt},
+		{Name: "timeout", Value: "1h", Type: VarTypeString},
+		{Name: "zip", Value: "007", Type: VarTypeString},
+	}
+	if d := cmp.Diff(want, vs.Spec.Vars); d != "" {
+		t.Errorf("SetDefaults() diff (-want, +got): %s", d)
+	}
+}
+
+func TestVariableStoreSetDefaultsReadmeExample(t *testing.T) {
+	// The example of the README, with `yes` unquoted, a YAML boolean, or quoted, a string left as is
+	for value, want := range map[string]Var{
+		`yes`:   {Name: "alert_enable", Value: "true", Type: VarTypeBool},
+		`"yes"`: {Name: "alert_enable", Value: "yes", Type: VarTypeString},
+	} {
+		data := `
+spec:
+  vars:
+  - name: job_priority
+    value: high
+  - name: alert_enable
+    value: ` + value
+		vs := &VariableStore{}
+		if err := yaml.Unmarshal([]byte(data), vs); err != nil {
+			t.Fatalf("Unmarshal() = %v", err)
+		}
+		vs.SetDefaults(context.Background())
+		want := []Var{
+			want,
+			{Name: "job_priority", Value: "high", Type: VarTypeString},
+		}
+		if d := cmp.Diff(want, vs.Spec.Vars); d != "" {
+			t.Errorf("SetDefaults(%s) diff (-want, +got): %s", value, d)
+		}
+	}
+}
+
+func TestVariableStoreSpecValidateTypes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		vars    []Var
+		wantErr string
+	}{{
+		name: "valid",
+		vars: []Var{{Name: "a", Value: "TRUE", Type: VarTypeBool}, {Name: "b", Value: "-3", Type: VarTypeInt}},
+	}, {
+		name:    "not a canonical bool",
+		vars:    []Var{{Name: "a", Value: "on", Type: VarTypeBool}},
+		wantErr: "invalid value: on is not a valid bool: vars[0].value",
+	}, {
+		name:    "unknown type",
+		vars:    []Var{{Name: "a", Value: "1", Type: "uint"}},
+		wantErr: "invalid value: uint: vars[0].type",
+	}, {
+		name:    "invalid value",
+		vars:    []Var{{Name: "a", Value: "1"}, {Name: "b", Value: "maybe", Type: VarTypeBool}},
+		wantErr: "invalid value: maybe is not a valid bool: vars[1].value",
+	}, {
+		name:    "not a number",
+		vars:    []Var{{Name: "a", Value: "NaN", Type: VarTypeDouble}},
+		wantErr: "invalid value: NaN is not a valid double: vars[0].value",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := (&VariableStoreSpec{Vars: tc.vars}).Validate(context.Background())
+			if got := err.Error(); got != tc.wantErr {
+				t.Errorf("Validate() = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestVariableStoreValidateCreator(t *testing.T) {
+	original := &VariableStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "store",
+			Annotations: map[string]string{"custom.tekton.dev/creator": "alice", "custom.tekton.dev/lastModifier": "alice"},
+		},
+		Spec: VariableStoreSpec{Vars: []Var{{Name: "a", Value: "1"}}},
+	}
+	ctx := apis.WithinUpdate(context.Background(), original)
+
+	updated := original.DeepCopy()
+	updated.Spec.Vars[0].Value = "2"
+	updated.Annotations["custom.tekton.dev/lastModifier"] = "bob"
+	if err := updated.Validate(ctx); err != nil {
+		t.Errorf("Validate() = %v, want no error", err)
+	}
+
+	updated.Annotations["custom.tekton.dev/creator"] = "bob"
+	if err := updated.Validate(ctx); err == nil {
+		t.Error("Validate() succeeded, want the creator to be immutable")
+	}
+}
@@ -32,3 +32,9 @@ func (*VariableStore) GetGroupVersionKind() schema.GroupVersionKind {
 func (as *VariableStore) GetConditionSet() apis.ConditionSet {
 	return condSet
 }
+
+// GetUntypedSpec implements apis.HasSpec, so that the webhook annotates VariableStores with their creator
+// and last modifier.
+func (as *VariableStore) GetUntypedSpec() interface{} {
+	return as.Spec
+}
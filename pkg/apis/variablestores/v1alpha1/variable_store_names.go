@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	identifierRegexp = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+	invalidRegexp    = regexp.MustCompile(`[^_a-zA-Z0-9]`)
+)
+
+// reservedWords can't be used as CEL identifiers, see
+// https://github.com/google/cel-spec/blob/master/doc/langdef.md#syntax
+var reservedWords = map[string]struct{}{
+	"false": {}, "in": {}, "null": {}, "true": {},
+	"as": {}, "break": {}, "const": {}, "continue": {}, "else": {},
+	"for": {}, "function": {}, "if": {}, "import": {}, "let": {},
+	"loop": {}, "namespace": {}, "package": {}, "return": {},
+	"var": {}, "void": {}, "while": {},
+}
+
+// IsIdentifier returns whether the name could be referenced as an identifier in a CEL expression.
+func IsIdentifier(name string) bool {
+	return !IsReservedWord(name) && identifierRegexp.MatchString(name)
+}
+
+// IsReservedWord returns whether the name is a CEL reserved word, which can't be used as an identifier.
+func IsReservedWord(name string) bool {
+	_, reserved := reservedWords[name]
+	return reserved
+}
+
+// Alias returns the name under which a variable is exposed to CEL expressions: the name itself
+// when it is a valid CEL identifier, otherwise the name with every invalid character replaced
+// by `_`, e.g. `is-red` as `is_red`.
+func Alias(name string) string {
+	if IsIdentifier(name) {
+		return name
+	}
+
+	alias := invalidRegexp.ReplaceAllString(name, "_")
+	if alias == "" || strings.ContainsAny(alias[:1], "0123456789") {
+		alias = "_" + alias
+	}
+	if IsReservedWord(alias) {
+		alias += "_"
+	}
+	return alias
+}
+
+// GetNamePolicy returns the NamePolicy of the VariableStore, or the default one when it is not set.
+func (vss *VariableStoreSpec) GetNamePolicy() NamePolicy {
+	if vss.NamePolicy == "" {
+		return NamePolicyAlias
+	}
+	return vss.NamePolicy
+}
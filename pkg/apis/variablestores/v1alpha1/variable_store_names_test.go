@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		identifier bool
+		alias      string
+	}{
+		{name: "job_priority", identifier: true, alias: "job_priority"},
+		{name: "_private", identifier: true, alias: "_private"},
+		{name: "is-red", identifier: false, alias: "is_red"},
+		{name: "app.kubernetes.io/name", identifier: false, alias: "app_kubernetes_io_name"},
+		{name: "1st", identifier: false, alias: "_1st"},
+		{name: "in", identifier: false, alias: "in_"},
+		{name: "", identifier: false, alias: "_"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsIdentifier(tc.name); got != tc.identifier {
+				t.Errorf("IsIdentifier(%q) = %v, want %v", tc.name, got, tc.identifier)
+			}
+			if got := Alias(tc.name); got != tc.alias {
+				t.Errorf("Alias(%q) = %q, want %q", tc.name, got, tc.alias)
+			}
+		})
+	}
+}
+
+func TestVariableStoreSpecValidateNames(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		spec    VariableStoreSpec
+		wantErr string
+	}{{
+		name: "aliased",
+		spec: VariableStoreSpec{Vars: []Var{{Name: "is-red", Value: "true"}, {Name: "is-blue", Value: "false"}}},
+	}, {
+		name:    "alias collision",
+		spec:    VariableStoreSpec{Vars: []Var{{Name: "is-red", Value: "true"}, {Name: "is_red", Value: "false"}}},
+		wantErr: "invalid value: is_red is referenced in expressions as is_red, which is already used by is-red: vars[1].name",
+	}, {
+		name:    "rejected",
+		spec:    VariableStoreSpec{NamePolicy: NamePolicyReject, Vars: []Var{{Name: "ok", Value: "true"}, {Name: "is-red", Value: "true"}}},
+		wantErr: "invalid value: is-red is not a valid CEL identifier and couldn't be referenced in expressions: vars[1].name",
+	}, {
+		name:    "duplicate",
+		spec:    VariableStoreSpec{Vars: []Var{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "a", Value: "3"}}},
+		wantErr: "invalid value: a is already declared by vars[0]: vars[2].name",
+	}, {
+		name:    "reserved word",
+		spec:    VariableStoreSpec{Vars: []Var{{Name: "in", Value: "true"}}},
+		wantErr: "invalid value: in is a CEL reserved word: vars[0].name",
+	}, {
+		name:    "built-in function",
+		spec:    VariableStoreSpec{Vars: []Var{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "c", Value: "3"}, {Name: "size", Value: "10"}}},
+		wantErr: "invalid value: size is referenced in expressions as size, which is a CEL built-in: vars[3].name",
+	}, {
+		name:    "built-in variable",
+		spec:    VariableStoreSpec{Vars: []Var{{Name: "vars", Value: "1"}}},
+		wantErr: "invalid value: vars is referenced in expressions as vars, which is a CEL built-in: vars[0].name",
+	}, {
+		name:    "function namespace",
+		spec:    VariableStoreSpec{Vars: []Var{{Name: "base64", Value: "1"}}},
+		wantErr: "invalid value: base64 is referenced in expressions as base64, which is a CEL built-in: vars[0].name",
+	}, {
+		name:    "unknown policy",
+		spec:    VariableStoreSpec{NamePolicy: "Ignore", Vars: []Var{}},
+		wantErr: "invalid value: Ignore: namePolicy",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.spec.Validate(context.Background())
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("Validate() = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// UnknownsPolicy sets whether a Run whose CEL expressions could only be partially evaluated
+// fails or succeeds.
+type UnknownsPolicy string
+
+const (
+	// UnknownsPolicyFail fails the Run when some CEL expressions could only be partially evaluated.
+	UnknownsPolicyFail UnknownsPolicy = "Fail"
+
+	// UnknownsPolicySucceed lets the Run succeed when some CEL expressions could only be partially
+	// evaluated, with the results of the fully evaluated ones.
+	UnknownsPolicySucceed UnknownsPolicy = "Succeed"
+)
+
+// ArrayParamsPolicy sets how the array params of a Run are evaluated.
+type ArrayParamsPolicy string
+
+const (
+	// ArrayParamsExpressions evaluates every element of an array param as a CEL expression, the param
+	// being exposed to expressions as the list of their values.
+	ArrayParamsExpressions ArrayParamsPolicy = "Expressions"
+
+	// ArrayParamsLiterals exposes an array param to expressions as the list of its elements, as they are.
+	ArrayParamsLiterals ArrayParamsPolicy = "Literals"
+)
+
+// Operation sets how the value of a CEL expression is written to the variable of the VariableStore.
+type Operation string
+
+const (
+	// OperationSet overwrites the variable with the value.
+	OperationSet Operation = "Set"
+
+	// OperationAdd adds the value, an int or a double, to the numeric variable, a negative value
+	// decrementing it. A missing variable counts as 0.
+	OperationAdd Operation = "Add"
+
+	// OperationAppend appends the value, or the elements of a list value, to the list variable.
+	OperationAppend Operation = "Append"
+
+	// OperationInsert inserts the value, or the elements of a list value, to the list variable taken as a
+	// set, skipping the elements it already holds, or the entries of a map value to the map variable.
+	OperationInsert Operation = "Insert"
+
+	// OperationRemove removes the value, or the elements of a list value, from the list variable, or the
+	// entries of the keys from the map variable.
+	OperationRemove Operation = "Remove"
+)
+
+// RunExtraFields holds the fields reported in the extraFields of the status of the Runs
+// referencing a VariableStore.
+type RunExtraFields struct {
+	// Residuals holds the CEL expressions which could only be partially evaluated.
+	// +optional
+	Residuals []Residual `json:"residuals,omitempty"`
+
+	// Traces holds how the CEL expressions were evaluated, when the Run turns on tracing.
+	// +optional
+	Traces []Trace `json:"traces,omitempty"`
+}
+
+// IsEmpty returns whether there is no field to report.
+func (ref *RunExtraFields) IsEmpty() bool {
+	return len(ref.Residuals) == 0 && len(ref.Traces) == 0
+}
+
+// Residual describes a CEL expression which could only be partially evaluated.
+type Residual struct {
+	// Name is the name of the param holding the CEL expression.
+	Name string `json:"name"`
+
+	// Expression is what remains of the CEL expression once evaluated with the known variables.
+	Expression string `json:"expression"`
+
+	// Unknowns holds the names of the unknown variables the CEL expression references.
+	Unknowns []string `json:"unknowns"`
+}
+
+// Trace describes how a CEL expression was evaluated.
+type Trace struct {
+	// Name is the name of the param holding the CEL expression.
+	Name string `json:"name"`
+
+	// Steps holds the values of the sub-expressions of the CEL expression, in the order they appear.
+	// The sub-expressions which were not evaluated, e.g. because of short-circuiting, are omitted.
+	// +optional
+	Steps []TraceStep `json:"steps,omitempty"`
+
+	// Vars holds the names of the VariableStore variables the CEL expression references.
+	// +optional
+	Vars []string `json:"vars,omitempty"`
+
+	// Truncated is whether some steps were left out because the traces of the Run reached MaxTraceSize.
+	// +optional
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// MaxTraceSize is how many bytes of sub-expressions and values the traces of a Run hold at most, so that
+// tracing large expressions or values doesn't push the Run over the size limit of objects.
+const MaxTraceSize = 16 * 1024
+
+// TraceStep holds the value of a sub-expression of a CEL expression.
+type TraceStep struct {
+	// Expression is the sub-expression.
+	Expression string `json:"expression"`
+
+	// Value is the value the sub-expression evaluated to.
+	Value string `json:"value"`
+}
@@ -17,7 +17,13 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/apis"
 
 	// duckv1 "knative.dev/pkg/apis/duck/v1"
@@ -44,9 +50,11 @@ type VariableStore struct {
 }
 
 var (
-	// Check that VariableStore can be validated and defaulted.
+	// Check that VariableStore can be validated, defaulted and converted.
 	_ apis.Validatable   = (*VariableStore)(nil)
 	_ apis.Defaultable   = (*VariableStore)(nil)
+	_ apis.Convertible   = (*VariableStore)(nil)
+	_ apis.HasSpec       = (*VariableStore)(nil)
 	_ kmeta.OwnerRefable = (*VariableStore)(nil)
 	// // Check that the type conforms to the duck Knative Resource shape.
 	// _ duckv1.KRShaped = (*VariableStore)(nil)
@@ -59,6 +67,9 @@ const (
 	// VariableStoreReasonCouldntGet indicates that the associated Exception couldn't be retrieved
 	VariableStoreReasonCouldntGet VariableStoreRunReason = "CouldntGet"
 
+	// ReasonCouldntGetPipelineRun indicates that the PipelineRun owning the Run couldn't be retrieved
+	ReasonCouldntGetPipelineRun VariableStoreRunReason = "CouldntGetPipelineRun"
+
 	// ReasonFailedValidation indicates that the reason for failure status is that Run failed runtime validation
 	ReasonFailedValidation VariableStoreRunReason = "RunValidationFailed"
 
@@ -73,6 +84,27 @@ const (
 	// evaluated successfully and the results were produced
 	ReasonEvaluationSuccess VariableStoreRunReason = "EvaluationSuccess"
 
+	// ReasonPartialEvaluation indicates that some CEL expressions could only be partially evaluated
+	// because they reference unknown variables
+	ReasonPartialEvaluation VariableStoreRunReason = "PartialEvaluation"
+
+	// ReasonCostLimitExceeded indicates that the evaluation of a CEL expression was interrupted because it
+	// exceeded the cost limits
+	ReasonCostLimitExceeded VariableStoreRunReason = "CostLimitExceeded"
+
+	// ReasonWaiting indicates that the Run is waiting for the condition over its VariableStore to be true
+	ReasonWaiting VariableStoreRunReason = "Waiting"
+
+	// ReasonWaitTimeout indicates that the condition over the VariableStore of the Run wasn't true before
+	// the Run timed out
+	ReasonWaitTimeout VariableStoreRunReason = "WaitTimeout"
+
+	// ReasonWaitingForLock indicates that the Run is waiting for room in the lock it acquires
+	ReasonWaitingForLock VariableStoreRunReason = "WaitingForLock"
+
+	// ReasonCancelled indicates that the Run was cancelled while it was waiting
+	ReasonCancelled VariableStoreRunReason = "RunCancelled"
+
 	// VariableStoreReasonUpdateFaild
 	VariableStoreReasonUpdateFaild VariableStoreRunReason = "UpdateFaild"
 )
@@ -85,12 +117,203 @@ func (e VariableStoreRunReason) String() string {
 type VariableStoreSpec struct {
 	// Vars holds the predefined variables and these variabls will be the context for next caculation.
 	Vars []Var `json:"vars,omitempty"`
+
+	// NamePolicy defines how the variables whose names are not valid CEL identifiers are handled.
+	// Defaults to Alias.
+	// +optional
+	NamePolicy NamePolicy `json:"namePolicy,omitempty"`
+
+	// Locks holds the locks and counting semaphores acquired by Runs, which the Runs waiting to acquire
+	// them are serialized on.
+	// +optional
+	Locks []Lock `json:"locks,omitempty"`
+
+	// Applied records the latest Runs whose writes were applied to the VariableStore, the latest last, so
+	// that a Run reconciled again, e.g. because the controller restarted before its status was persisted,
+	// doesn't write twice. Only the latest MaxAppliedRuns are recorded.
+	// +optional
+	Applied []AppliedRun `json:"applied,omitempty"`
+
+	// Overlays holds the variables written by the transactional Runs of PipelineRuns, which only the Runs of
+	// the same PipelineRun see, until the PipelineRun succeeds and they are committed to the variables, or
+	// fails and they are discarded.
+	// +optional
+	Overlays []Overlay `json:"overlays,omitempty"`
+}
+
+// Overlay holds the variables written by the transactional Runs of a PipelineRun.
+type Overlay struct {
+	// PipelineRun is the name of the PipelineRun.
+	PipelineRun string `json:"pipelineRun"`
+
+	// UID is the UID of the PipelineRun, so that a PipelineRun recreated with the same name doesn't commit
+	// the writes of the deleted one.
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+
+	// Vars holds the variables as written by the Runs of the PipelineRun.
+	// +optional
+	Vars []Var `json:"vars,omitempty"`
+
+	// Writes holds the writes of the Runs of the PipelineRun, in the order they were applied to the overlay.
+	// They are applied again to the variables when the overlay is committed, so that the writes of other
+	// Runs to the same variables in the meantime, e.g. the increments of a counter, are not lost.
+	// +optional
+	Writes []StagedWrite `json:"writes,omitempty"`
+}
+
+// StagedWrite is a write of a transactional Run staged in an overlay.
+type StagedWrite struct {
+	// Name is the name of the variable written.
+	Name string `json:"name"`
+
+	// Operation is the operation the variable is written with.
+	Operation Operation `json:"operation"`
+
+	// Value is the value written with Set, or the operand of the other operations, in JSON for Append,
+	// Insert and Remove.
+	Value string `json:"value"`
+
+	// Type is the type of the value written with Set, or of the operand of Add.
+	// +optional
+	Type VarType `json:"type,omitempty"`
 }
 
+// MaxAppliedRuns is how many of the latest Runs whose writes were applied a VariableStore records.
+const MaxAppliedRuns = 100
+
+// AppliedRun is a Run whose writes were applied to the VariableStore.
+type AppliedRun struct {
+	// UID is the UID of the Run.
+	UID types.UID `json:"uid"`
+
+	// Name is the name of the Run.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Results are the results of the Run, the variables as written.
+	// +optional
+	Results []AppliedResult `json:"results,omitempty"`
+}
+
+// AppliedResult is a result of a Run whose writes were applied to the VariableStore.
+type AppliedResult struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Lock is a counting semaphore called name, a mutex when its capacity is 1.
+type Lock struct {
+	Name string `json:"name"`
+
+	// Capacity is how many holders can hold the lock at the same time.
+	Capacity int64 `json:"capacity"`
+
+	// Holders are the holders of the lock, in the order they acquired it.
+	// +optional
+	Holders []LockHolder `json:"holders,omitempty"`
+}
+
+// LockHolder is the Run which acquired a lock. A Run owned by a PipelineRun acquires the lock on behalf
+// of its PipelineRun, which holds it until it finishes or another of its Runs releases it. Any other Run
+// holds it until it is deleted.
+type LockHolder struct {
+	// Run is the name of the Run which acquired the lock.
+	Run string `json:"run"`
+
+	// PipelineRun is the name of the PipelineRun owning the Run, if any.
+	// +optional
+	PipelineRun string `json:"pipelineRun,omitempty"`
+
+	// UID is the UID of the PipelineRun holding the lock, or of the Run when it is not owned by one, so that
+	// a PipelineRun or a Run recreated with the same name doesn't hold the lock too.
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+}
+
+// NamePolicy defines how the variables whose names are not valid CEL identifiers, e.g. `is-red`, are handled.
+type NamePolicy string
+
+const (
+	// NamePolicyAlias exposes the variables whose names are not valid CEL identifiers to expressions
+	// under an alias, e.g. `is-red` as `is_red`.
+	NamePolicyAlias NamePolicy = "Alias"
+
+	// NamePolicyReject rejects the variables whose names are not valid CEL identifiers.
+	NamePolicyReject NamePolicy = "Reject"
+)
+
 // Var declares an string to use for the var called name.
 type Var struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
+
+	// Type is the type of the value, inferred from the value when not set.
+	// +optional
+	Type VarType `json:"type,omitempty"`
+}
+
+// VarType is the type of the value of a variable, which is always written as a string.
+type VarType string
+
+const (
+	// VarTypeString is the type of the variables whose values are strings.
+	VarTypeString VarType = "string"
+
+	// VarTypeBool is the type of the variables whose values are booleans, `true` or `false`.
+	VarTypeBool VarType = "bool"
+
+	// VarTypeInt is the type of the variables whose values are 64-bit integers.
+	VarTypeInt VarType = "int"
+
+	// VarTypeDouble is the type of the variables whose values are 64-bit floating point numbers.
+	VarTypeDouble VarType = "double"
+)
+
+// UnmarshalJSON implements json.Unmarshaler. Besides strings, it accepts booleans and numbers as values,
+// which YAML produces out of unquoted values like `yes` or `3`, and sets the type of the variable from them
+// when it is not set.
+func (v *Var) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+		Type  VarType         `json:"type,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*v = Var{Name: raw.Name, Type: raw.Type}
+	if len(raw.Value) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw.Value))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+	case string:
+		v.Value = value
+	case bool:
+		v.Value = strconv.FormatBool(value)
+		if v.Type == "" {
+			v.Type = VarTypeBool
+		}
+	case json.Number:
+		v.Value = value.String()
+		if v.Type == "" {
+			v.Type = VarTypeDouble
+			if _, err := value.Int64(); err == nil {
+				v.Type = VarTypeInt
+			}
+		}
+	default:
+		return fmt.Errorf("the value of var %s must be a string, a boolean or a number", raw.Name)
+	}
+	return nil
 }
 
 const (
@@ -18,8 +18,13 @@ package v1alpha1
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 
 	"github.com/tektoncd/pipeline/pkg/apis/validate"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
 	"knative.dev/pkg/apis"
 )
 
@@ -28,13 +33,224 @@ func (vs *VariableStore) Validate(ctx context.Context) *apis.FieldError {
 	if err := validate.ObjectMetadata(vs.GetObjectMeta()); err != nil {
 		return err.ViaField("metadata")
 	}
-	return vs.Spec.Validate(ctx).ViaField("spec")
+
+	var errs *apis.FieldError
+	if apis.IsInUpdate(ctx) {
+		original := apis.GetBaseline(ctx).(*VariableStore)
+		errs = apis.ValidateCreatorAndModifier(original.Spec, vs.Spec, original.GetAnnotations(),
+			vs.GetAnnotations(), variablestores.GroupName).ViaField("metadata.annotations")
+	}
+	return errs.Also(vs.Spec.Validate(ctx).ViaField("spec"))
 }
 
 // Validate implements apis.Validatable
-func (vss *VariableStoreSpec) Validate(ctx context.Context) *apis.FieldError {
+func (vss *VariableStoreSpec) Validate(ctx context.Context) (errs *apis.FieldError) {
 	if vss.Vars == nil {
-		return apis.ErrMissingField("spec.vars")
+		return apis.ErrMissingField("vars")
+	}
+
+	switch vss.GetNamePolicy() {
+	case NamePolicyAlias, NamePolicyReject:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(vss.NamePolicy, "namePolicy"))
+	}
+
+	errs = errs.Also(vss.validateNames())
+	errs = errs.Also(vss.validateTypes())
+	errs = errs.Also(vss.validateLocks())
+	errs = errs.Also(vss.validateApplied())
+	errs = errs.Also(vss.validateOverlays(config.FromContextOrDefaults(ctx).Limits))
+	errs = errs.Also(vss.validateSizes(config.FromContextOrDefaults(ctx).Limits))
+	return errs.Also(vss.validateSpecSize(config.FromContextOrDefaults(ctx).Limits))
+}
+
+// validateTypes checks that the type of every variable is known, and that its value is valid for it.
+func (vss *VariableStoreSpec) validateTypes() (errs *apis.FieldError) {
+	for i, variable := range vss.Vars {
+		switch variable.Type {
+		case "", VarTypeString, VarTypeBool, VarTypeInt, VarTypeDouble:
+		default:
+			errs = errs.Also(apis.ErrInvalidValue(variable.Type, "type").ViaFieldIndex("vars", i))
+			continue
+		}
+		if variable.Type == "" {
+			continue
+		}
+		if _, ok := CanonicalValue(variable.Type, variable.Value); !ok {
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is not a valid %s", variable.Value, variable.Type),
+				"value").ViaFieldIndex("vars", i))
+		}
+	}
+	return errs
+}
+
+// validateLocks checks that every lock is declared once, can be held, and records the Runs holding it.
+func (vss *VariableStoreSpec) validateLocks() (errs *apis.FieldError) {
+	indexes := make(map[string]int, len(vss.Locks))
+	for i, lock := range vss.Locks {
+		switch j, ok := indexes[lock.Name]; {
+		case lock.Name == "":
+			errs = errs.Also(apis.ErrMissingField("name").ViaFieldIndex("locks", i))
+		case ok:
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is already declared by locks[%d]", lock.Name, j),
+				"name").ViaFieldIndex("locks", i))
+		default:
+			indexes[lock.Name] = i
+		}
+		if lock.Capacity < 1 {
+			errs = errs.Also(apis.ErrInvalidValue(lock.Capacity, "capacity").ViaFieldIndex("locks", i))
+		}
+		for j, holder := range lock.Holders {
+			if holder.Run == "" {
+				errs = errs.Also(apis.ErrMissingField("run").ViaFieldIndex("holders", j).ViaFieldIndex("locks", i))
+			}
+		}
+	}
+	return errs
+}
+
+// validateApplied checks that every Run whose writes were applied is recorded by its UID.
+func (vss *VariableStoreSpec) validateApplied() (errs *apis.FieldError) {
+	for i, run := range vss.Applied {
+		if run.UID == "" {
+			errs = errs.Also(apis.ErrMissingField("uid").ViaFieldIndex("applied", i))
+		}
+	}
+	return errs
+}
+
+// validateOverlays checks that every PipelineRun has a single overlay, whose variables are valid like the
+// variables of the VariableStore they are committed to.
+func (vss *VariableStoreSpec) validateOverlays(limits *config.Limits) (errs *apis.FieldError) {
+	indexes := make(map[string]int, len(vss.Overlays))
+	for i, o := range vss.Overlays {
+		switch j, ok := indexes[o.PipelineRun]; {
+		case o.PipelineRun == "":
+			errs = errs.Also(apis.ErrMissingField("pipelineRun").ViaFieldIndex("overlays", i))
+		case ok:
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is already declared by overlays[%d]", o.PipelineRun, j),
+				"pipelineRun").ViaFieldIndex("overlays", i))
+		default:
+			indexes[o.PipelineRun] = i
+		}
+		for j, w := range o.Writes {
+			errs = errs.Also(w.validate().ViaFieldIndex("writes", j).ViaFieldIndex("overlays", i))
+		}
+		overlay := &VariableStoreSpec{Vars: o.Vars, NamePolicy: vss.NamePolicy}
+		errs = errs.Also(overlay.validateNames().ViaFieldIndex("overlays", i))
+		errs = errs.Also(overlay.validateTypes().ViaFieldIndex("overlays", i))
+		errs = errs.Also(overlay.validateSizes(limits).ViaFieldIndex("overlays", i))
+	}
+	return errs
+}
+
+// validate checks that the staged write names its variable and a known operation.
+func (w StagedWrite) validate() (errs *apis.FieldError) {
+	if w.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("name"))
+	}
+	switch w.Operation {
+	case OperationSet, OperationAdd, OperationAppend, OperationInsert, OperationRemove:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(w.Operation, "operation"))
+	}
+	return errs
+}
+
+// validateNames checks that every variable is declared once, and could be referenced in CEL expressions
+// according to the NamePolicy without colliding with the names bound by CEL.
+func (vss *VariableStoreSpec) validateNames() (errs *apis.FieldError) {
+	indexes := make(map[string]int, len(vss.Vars))
+	aliases := make(map[string]string, len(vss.Vars))
+	for i, variable := range vss.Vars {
+		if j, ok := indexes[variable.Name]; ok {
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is already declared by vars[%d]", variable.Name, j),
+				"name").ViaFieldIndex("vars", i))
+			continue
+		}
+		indexes[variable.Name] = i
+
+		if IsReservedWord(variable.Name) {
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is a CEL reserved word", variable.Name),
+				"name").ViaFieldIndex("vars", i))
+			continue
+		}
+
+		if !IsIdentifier(variable.Name) && vss.GetNamePolicy() == NamePolicyReject {
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is not a valid CEL identifier and couldn't be referenced in expressions", variable.Name),
+				"name").ViaFieldIndex("vars", i))
+			continue
+		}
+
+		alias := Alias(variable.Name)
+		if celenv.IsBuiltin(alias) {
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is referenced in expressions as %s, which is a CEL built-in", variable.Name, alias),
+				"name").ViaFieldIndex("vars", i))
+			continue
+		}
+		if name, ok := aliases[alias]; ok {
+			errs = errs.Also(apis.ErrInvalidValue(
+				fmt.Sprintf("%s is referenced in expressions as %s, which is already used by %s", variable.Name, alias, name),
+				"name").ViaFieldIndex("vars", i))
+			continue
+		}
+		aliases[alias] = variable.Name
+	}
+	return errs
+}
+
+// validateSizes checks that the values of the variables, and the variables altogether, are within the
+// size limits, the latter keeping the VariableStore away from the etcd limit on the size of an object.
+func (vss *VariableStoreSpec) validateSizes(limits *config.Limits) (errs *apis.FieldError) {
+	if limits.MaxValueSize > 0 {
+		for i, variable := range vss.Vars {
+			if size := int64(len(variable.Value)); size > limits.MaxValueSize {
+				errs = errs.Also(&apis.FieldError{
+					Message: fmt.Sprintf("the value of %s takes %d bytes, over the limit of %d bytes", variable.Name, size, limits.MaxValueSize),
+					Paths:   []string{"value"},
+				}).ViaFieldIndex("vars", i)
+			}
+		}
+	}
+
+	if limits.MaxStoreSize > 0 {
+		vars, err := json.Marshal(vss.Vars)
+		if err != nil {
+			return errs.Also(apis.ErrGeneric(err.Error(), "vars"))
+		}
+		if size := int64(len(vars)); size > limits.MaxStoreSize {
+			errs = errs.Also(&apis.FieldError{
+				Message: fmt.Sprintf("the variables take %d bytes, over the limit of %d bytes", size, limits.MaxStoreSize),
+				Paths:   []string{"vars"},
+			})
+		}
+	}
+	return errs
+}
+
+// validateSpecSize checks that the variables along with the locks, the records of the applied Runs and the
+// overlays are within the size limit on the VariableStore: they grow with the Runs writing it, and are
+// stored in the same object as the variables.
+func (vss *VariableStoreSpec) validateSpecSize(limits *config.Limits) *apis.FieldError {
+	if limits.MaxStoreSize <= 0 || len(vss.Locks)+len(vss.Applied)+len(vss.Overlays) == 0 {
+		return nil
+	}
+	spec, err := json.Marshal(vss)
+	if err != nil {
+		return apis.ErrGeneric(err.Error(), apis.CurrentField)
+	}
+	if size := int64(len(spec)); size > limits.MaxStoreSize {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("the variables, locks, applied Runs and overlays take %d bytes, over the limit of %d bytes", size, limits.MaxStoreSize),
+			Paths:   []string{apis.CurrentField},
+		}
 	}
 	return nil
 }
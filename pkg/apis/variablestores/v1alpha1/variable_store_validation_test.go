@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVariableStoreValidate(t *testing.T) {
+	limits, err := config.NewLimitsFromMap(map[string]string{
+		"max-value-size": "10",
+		"max-store-size": "150",
+	})
+	if err != nil {
+		t.Fatalf("NewLimitsFromMap() = %v", err)
+	}
+	ctx := config.ToContext(context.Background(), &config.Config{Limits: limits})
+
+	for _, tc := range []struct {
+		name     string
+		vars     []Var
+		locks    []Lock
+		applied  []AppliedRun
+		overlays []Overlay
+		wantErr  string
+	}{{
+		name: "valid",
+		vars: []Var{{Name: "a", Value: "0123456789"}},
+	}, {
+		name:    "missing vars",
+		wantErr: "missing field(s): spec.vars",
+	}, {
+		name:    "value size",
+		vars:    []Var{{Name: "a", Value: "1"}, {Name: "b", Value: "0123456789a"}},
+		wantErr: "the value of b takes 11 bytes, over the limit of 10 bytes: spec.vars[1].value",
+	}, {
+		name:    "store size",
+		vars:    []Var{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "c", Value: "3"}, {Name: "d", Value: "4"}, {Name: "e", Value: "5"}, {Name: "f", Value: "6"}},
+		wantErr: "the variables take 151 bytes, over the limit of 150 bytes: spec.vars",
+	}, {
+		name:    "field paths",
+		vars:    []Var{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "c", Value: "3"}, {Name: "a", Value: "4"}},
+		wantErr: "invalid value: a is already declared by vars[0]: spec.vars[3].name",
+	}, {
+		name:  "locks",
+		vars:  []Var{},
+		locks: []Lock{{Name: "deploy", Capacity: 1, Holders: []LockHolder{{Run: "run", PipelineRun: "pr"}}}, {Name: "build", Capacity: 3}},
+	}, {
+		name:    "lock declared twice",
+		vars:    []Var{},
+		locks:   []Lock{{Name: "deploy", Capacity: 1}, {Name: "deploy", Capacity: 2}},
+		wantErr: "invalid value: deploy is already declared by locks[0]: spec.locks[1].name",
+	}, {
+		name:    "lock capacity",
+		vars:    []Var{},
+		locks:   []Lock{{Name: "deploy"}},
+		wantErr: "invalid value: 0: spec.locks[0].capacity",
+	}, {
+		name:    "lock holder",
+		vars:    []Var{},
+		locks:   []Lock{{Name: "deploy", Capacity: 1, Holders: []LockHolder{{PipelineRun: "pr"}}}},
+		wantErr: "missing field(s): spec.locks[0].holders[0].run",
+	}, {
+		name:    "applied runs",
+		vars:    []Var{},
+		applied: []AppliedRun{{UID: "uid", Name: "run", Results: []AppliedResult{{Name: "a", Value: "1"}}}},
+	}, {
+		name:    "applied run uid",
+		vars:    []Var{},
+		applied: []AppliedRun{{UID: "uid"}, {Name: "run"}},
+		wantErr: "missing field(s): spec.applied[1].uid",
+	}, {
+		name: "applied runs size",
+		vars: []Var{{Name: "a", Value: "1"}},
+		applied: []AppliedRun{
+			{UID: "uid-1", Name: "run-1", Results: []AppliedResult{{Name: "a", Value: "0123456789"}}},
+			{UID: "uid-2", Name: "run-2", Results: []AppliedResult{{Name: "a", Value: "0123456789"}}},
+		},
+		wantErr: "the variables, locks, applied Runs and overlays take 201 bytes, over the limit of 150 bytes: spec",
+	}, {
+		name: "overlays size",
+		vars: []Var{},
+		overlays: []Overlay{
+			{PipelineRun: "pr-1", Vars: []Var{{Name: "a", Value: "0123456789"}}},
+			{PipelineRun: "pr-2", Vars: []Var{{Name: "a", Value: "0123456789"}}},
+			{PipelineRun: "pr-3", Vars: []Var{{Name: "a", Value: "0123456789"}}},
+		},
+		wantErr: "the variables, locks, applied Runs and overlays take 212 bytes, over the limit of 150 bytes: spec",
+	}, {
+		name:     "overlays",
+		vars:     []Var{},
+		overlays: []Overlay{{PipelineRun: "pr-1", Vars: []Var{{Name: "a", Value: "1", Type: VarTypeInt}}}, {PipelineRun: "pr-2"}},
+	}, {
+		name:     "overlay declared twice",
+		vars:     []Var{},
+		overlays: []Overlay{{PipelineRun: "pr"}, {PipelineRun: "pr"}},
+		wantErr:  "invalid value: pr is already declared by overlays[0]: spec.overlays[1].pipelineRun",
+	}, {
+		name:     "overlay var",
+		vars:     []Var{},
+		overlays: []Overlay{{PipelineRun: "pr", Vars: []Var{{Name: "a", Value: "x", Type: VarTypeInt}}}},
+		wantErr:  "invalid value: x is not a valid int: spec.overlays[0].vars[0].value",
+	}, {
+		name:     "overlay staged write",
+		vars:     []Var{},
+		overlays: []Overlay{{PipelineRun: "pr", Writes: []StagedWrite{{Name: "a", Operation: OperationAdd, Value: "1", Type: VarTypeInt}, {Operation: "Multiply"}}}},
+		wantErr:  "invalid value: Multiply: spec.overlays[0].writes[1].operation",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			vs := &VariableStore{
+				ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+				Spec:       VariableStoreSpec{Vars: tc.vars, Locks: tc.locks, Applied: tc.applied, Overlays: tc.overlays},
+			}
+			err := vs.Validate(ctx)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("Validate() = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -24,6 +24,215 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppliedResult) DeepCopyInto(out *AppliedResult) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedResult.
+func (in *AppliedResult) DeepCopy() *AppliedResult {
+	if in == nil {
+		return nil
+	}
+	out := new(AppliedResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppliedRun) DeepCopyInto(out *AppliedRun) {
+	*out = *in
+	if in.Results != nil {
+		in, out := &in.Results, &out.Results
+		*out = make([]AppliedResult, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedRun.
+func (in *AppliedRun) DeepCopy() *AppliedRun {
+	if in == nil {
+		return nil
+	}
+	out := new(AppliedRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Lock) DeepCopyInto(out *Lock) {
+	*out = *in
+	if in.Holders != nil {
+		in, out := &in.Holders, &out.Holders
+		*out = make([]LockHolder, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Lock.
+func (in *Lock) DeepCopy() *Lock {
+	if in == nil {
+		return nil
+	}
+	out := new(Lock)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LockHolder) DeepCopyInto(out *LockHolder) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LockHolder.
+func (in *LockHolder) DeepCopy() *LockHolder {
+	if in == nil {
+		return nil
+	}
+	out := new(LockHolder)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Overlay) DeepCopyInto(out *Overlay) {
+	*out = *in
+	if in.Vars != nil {
+		in, out := &in.Vars, &out.Vars
+		*out = make([]Var, len(*in))
+		copy(*out, *in)
+	}
+	if in.Writes != nil {
+		in, out := &in.Writes, &out.Writes
+		*out = make([]StagedWrite, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Overlay.
+func (in *Overlay) DeepCopy() *Overlay {
+	if in == nil {
+		return nil
+	}
+	out := new(Overlay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Residual) DeepCopyInto(out *Residual) {
+	*out = *in
+	if in.Unknowns != nil {
+		in, out := &in.Unknowns, &out.Unknowns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Residual.
+func (in *Residual) DeepCopy() *Residual {
+	if in == nil {
+		return nil
+	}
+	out := new(Residual)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RunExtraFields) DeepCopyInto(out *RunExtraFields) {
+	*out = *in
+	if in.Residuals != nil {
+		in, out := &in.Residuals, &out.Residuals
+		*out = make([]Residual, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Traces != nil {
+		in, out := &in.Traces, &out.Traces
+		*out = make([]Trace, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RunExtraFields.
+func (in *RunExtraFields) DeepCopy() *RunExtraFields {
+	if in == nil {
+		return nil
+	}
+	out := new(RunExtraFields)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StagedWrite) DeepCopyInto(out *StagedWrite) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StagedWrite.
+func (in *StagedWrite) DeepCopy() *StagedWrite {
+	if in == nil {
+		return nil
+	}
+	out := new(StagedWrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Trace) DeepCopyInto(out *Trace) {
+	*out = *in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]TraceStep, len(*in))
+		copy(*out, *in)
+	}
+	if in.Vars != nil {
+		in, out := &in.Vars, &out.Vars
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Trace.
+func (in *Trace) DeepCopy() *Trace {
+	if in == nil {
+		return nil
+	}
+	out := new(Trace)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TraceStep) DeepCopyInto(out *TraceStep) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TraceStep.
+func (in *TraceStep) DeepCopy() *TraceStep {
+	if in == nil {
+		return nil
+	}
+	out := new(TraceStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Var) DeepCopyInto(out *Var) {
 	*out = *in
@@ -108,6 +317,27 @@ func (in *VariableStoreSpec) DeepCopyInto(out *VariableStoreSpec) {
 		*out = make([]Var, len(*in))
 		copy(*out, *in)
 	}
+	if in.Locks != nil {
+		in, out := &in.Locks, &out.Locks
+		*out = make([]Lock, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Applied != nil {
+		in, out := &in.Applied, &out.Applied
+		*out = make([]AppliedRun, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Overlays != nil {
+		in, out := &in.Overlays, &out.Overlays
+		*out = make([]Overlay, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -0,0 +1,208 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"knative.dev/pkg/apis"
+)
+
+// ConvertTo implements apis.Convertible. v1beta1 is the hub version of the conversion webhook, which
+// converts between every other version and v1beta1.
+func (vs *VariableStore) ConvertTo(ctx context.Context, to apis.Convertible) error {
+	switch sink := to.(type) {
+	case *v1alpha1.VariableStore:
+		sink.ObjectMeta = vs.ObjectMeta
+		return vs.Spec.ConvertTo(ctx, &sink.Spec)
+	default:
+		return fmt.Errorf("unknown version, got: %T", sink)
+	}
+}
+
+// ConvertTo converts the variables to v1alpha1, sorted by name and with their values in canonical form,
+// the locks, sorted by name, the applied Runs, and the overlays, sorted by PipelineRun.
+func (vss *VariableStoreSpec) ConvertTo(ctx context.Context, sink *v1alpha1.VariableStoreSpec) error {
+	sink.NamePolicy = v1alpha1.NamePolicy(vss.NamePolicy)
+	sink.Applied = nil
+	for _, run := range vss.Applied {
+		converted := v1alpha1.AppliedRun{UID: run.UID, Name: run.Name}
+		for _, result := range run.Results {
+			converted.Results = append(converted.Results, v1alpha1.AppliedResult{Name: result.Name, Value: result.Value})
+		}
+		sink.Applied = append(sink.Applied, converted)
+	}
+	sink.Locks = nil
+	for _, name := range vss.lockNames() {
+		lock := vss.Locks[name]
+		converted := v1alpha1.Lock{Name: name, Capacity: lock.Capacity}
+		for _, holder := range lock.Holders {
+			converted.Holders = append(converted.Holders, v1alpha1.LockHolder{Run: holder.Run, PipelineRun: holder.PipelineRun, UID: holder.UID})
+		}
+		sink.Locks = append(sink.Locks, converted)
+	}
+	sink.Overlays = nil
+	for _, name := range vss.overlayNames() {
+		vars, err := convertVarsTo(vss.Overlays[name].Vars)
+		if err != nil {
+			return fmt.Errorf("overlay %s: %v", name, err)
+		}
+		converted := v1alpha1.Overlay{PipelineRun: name, UID: vss.Overlays[name].UID, Vars: vars}
+		for _, w := range vss.Overlays[name].Writes {
+			converted.Writes = append(converted.Writes, v1alpha1.StagedWrite{
+				Name: w.Name, Operation: v1alpha1.Operation(w.Operation), Value: w.Value, Type: v1alpha1.VarType(w.Type),
+			})
+		}
+		sink.Overlays = append(sink.Overlays, converted)
+	}
+	vars, err := convertVarsTo(vss.Vars)
+	if err != nil {
+		return err
+	}
+	sink.Vars = vars
+	return nil
+}
+
+// convertVarsTo converts the variables to v1alpha1, sorted by name and with their values in canonical form.
+func convertVarsTo(vars map[string]Var) ([]v1alpha1.Var, error) {
+	if vars == nil {
+		return nil, nil
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	converted := make([]v1alpha1.Var, 0, len(vars))
+	for _, name := range names {
+		variable := vars[name]
+		switch {
+		case variable.String != nil:
+			converted = append(converted, v1alpha1.Var{Name: name, Value: *variable.String, Type: v1alpha1.VarTypeString})
+		case variable.Bool != nil:
+			converted = append(converted, v1alpha1.Var{Name: name, Value: strconv.FormatBool(*variable.Bool), Type: v1alpha1.VarTypeBool})
+		case variable.Int != nil:
+			converted = append(converted, v1alpha1.Var{Name: name, Value: strconv.FormatInt(*variable.Int, 10), Type: v1alpha1.VarTypeInt})
+		case variable.Double != nil:
+			converted = append(converted, v1alpha1.Var{Name: name, Value: strconv.FormatFloat(*variable.Double, 'g', -1, 64), Type: v1alpha1.VarTypeDouble})
+		default:
+			return nil, fmt.Errorf("var %s has no value", name)
+		}
+	}
+	return converted, nil
+}
+
+// ConvertFrom implements apis.Convertible
+func (vs *VariableStore) ConvertFrom(ctx context.Context, from apis.Convertible) error {
+	switch source := from.(type) {
+	case *v1alpha1.VariableStore:
+		vs.ObjectMeta = source.ObjectMeta
+		return vs.Spec.ConvertFrom(ctx, &source.Spec)
+	default:
+		return fmt.Errorf("unknown version, got: %T", source)
+	}
+}
+
+// ConvertFrom converts the variables from v1alpha1, typing their values according to their declared
+// types, or to the types inferred from their values when they don't declare one, the locks, the applied
+// Runs and the overlays. The variables, locks and overlays declared twice, which v1alpha1 rejects but older
+// VariableStores may hold, are converted from their last declaration, so that listing them doesn't fail.
+func (vss *VariableStoreSpec) ConvertFrom(ctx context.Context, source *v1alpha1.VariableStoreSpec) error {
+	vss.NamePolicy = NamePolicy(source.NamePolicy)
+	vss.Applied = nil
+	for _, run := range source.Applied {
+		converted := AppliedRun{UID: run.UID, Name: run.Name}
+		for _, result := range run.Results {
+			converted.Results = append(converted.Results, AppliedResult{Name: result.Name, Value: result.Value})
+		}
+		vss.Applied = append(vss.Applied, converted)
+	}
+	vss.Locks = nil
+	for _, lock := range source.Locks {
+		if vss.Locks == nil {
+			vss.Locks = make(map[string]Lock, len(source.Locks))
+		}
+		converted := Lock{Capacity: lock.Capacity}
+		for _, holder := range lock.Holders {
+			converted.Holders = append(converted.Holders, LockHolder{Run: holder.Run, PipelineRun: holder.PipelineRun, UID: holder.UID})
+		}
+		vss.Locks[lock.Name] = converted
+	}
+	vss.Overlays = nil
+	for i, overlay := range source.Overlays {
+		if vss.Overlays == nil {
+			vss.Overlays = make(map[string]Overlay, len(source.Overlays))
+		}
+		vars, err := convertVarsFrom(fmt.Sprintf("overlays[%d].vars", i), overlay.Vars)
+		if err != nil {
+			return err
+		}
+		converted := Overlay{UID: overlay.UID, Vars: vars}
+		for _, w := range overlay.Writes {
+			converted.Writes = append(converted.Writes, StagedWrite{
+				Name: w.Name, Operation: string(w.Operation), Value: w.Value, Type: string(w.Type),
+			})
+		}
+		vss.Overlays[overlay.PipelineRun] = converted
+	}
+	vars, err := convertVarsFrom("vars", source.Vars)
+	if err != nil {
+		return err
+	}
+	vss.Vars = vars
+	return nil
+}
+
+// convertVarsFrom converts the variables of the field from v1alpha1, typing their values according to their
+// declared types, or to the types inferred from their values when they don't declare one. The last of the
+// variables declared twice wins.
+func convertVarsFrom(field string, vars []v1alpha1.Var) (map[string]Var, error) {
+	if vars == nil {
+		return nil, nil
+	}
+	converted := make(map[string]Var, len(vars))
+	for i, variable := range vars {
+		t := variable.Type
+		if t == "" {
+			t = v1alpha1.InferVarType(variable.Value)
+		}
+		value, ok := v1alpha1.CanonicalValue(t, variable.Value)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: %s is not a valid %s", field, i, variable.Value, t)
+		}
+		var v Var
+		switch t {
+		case v1alpha1.VarTypeString:
+			v.String = &value
+		case v1alpha1.VarTypeBool:
+			b, _ := strconv.ParseBool(value)
+			v.Bool = &b
+		case v1alpha1.VarTypeInt:
+			n, _ := strconv.ParseInt(value, 10, 64)
+			v.Int = &n
+		case v1alpha1.VarTypeDouble:
+			f, _ := strconv.ParseFloat(value, 64)
+			v.Double = &f
+		}
+		converted[variable.Name] = v
+	}
+	return converted, nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+)
+
+func TestVariableStoreConversion(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "store", Namespace: "default", Generation: 2}
+	for _, tc := range []struct {
+		name    string
+		v1beta1 *VariableStore
+		v1alpha *v1alpha1.VariableStore
+	}{{
+		name: "typed vars",
+		v1beta1: &VariableStore{ObjectMeta: meta, Spec: VariableStoreSpec{
+			Vars: map[string]Var{
+				"name":   {String: ptr.String("true")},
+				"is-red": {Bool: ptr.Bool(true)},
+				"count":  {Int: ptr.Int64(-3)},
+				"ratio":  {Double: ptr.Float64(1.5)},
+				"whole":  {Double: ptr.Float64(2)},
+			},
+			NamePolicy: NamePolicyAlias,
+		}},
+		v1alpha: &v1alpha1.VariableStore{ObjectMeta: meta, Spec: v1alpha1.VariableStoreSpec{
+			Vars: []v1alpha1.Var{
+				{Name: "count", Value: "-3", Type: v1alpha1.VarTypeInt},
+				{Name: "is-red", Value: "true", Type: v1alpha1.VarTypeBool},
+				{Name: "name", Value: "true", Type: v1alpha1.VarTypeString},
+				{Name: "ratio", Value: "1.5", Type: v1alpha1.VarTypeDouble},
+				{Name: "whole", Value: "2", Type: v1alpha1.VarTypeDouble},
+			},
+			NamePolicy: v1alpha1.NamePolicyAlias,
+		}},
+	}, {
+		name: "locks",
+		v1beta1: &VariableStore{ObjectMeta: meta, Spec: VariableStoreSpec{
+			Vars: map[string]Var{},
+			Locks: map[string]Lock{
+				"deploy": {Capacity: 1, Holders: []LockHolder{{Run: "acquire", PipelineRun: "pr", UID: "pr-uid"}}},
+				"build":  {Capacity: 2},
+			},
+		}},
+		v1alpha: &v1alpha1.VariableStore{ObjectMeta: meta, Spec: v1alpha1.VariableStoreSpec{
+			Vars: []v1alpha1.Var{},
+			Locks: []v1alpha1.Lock{
+				{Name: "build", Capacity: 2},
+				{Name: "deploy", Capacity: 1, Holders: []v1alpha1.LockHolder{{Run: "acquire", PipelineRun: "pr", UID: "pr-uid"}}},
+			},
+		}},
+	}, {
+		name: "applied runs",
+		v1beta1: &VariableStore{ObjectMeta: meta, Spec: VariableStoreSpec{
+			Vars: map[string]Var{},
+			Applied: []AppliedRun{
+				{UID: "uid-2", Name: "run-2", Results: []AppliedResult{{Name: "builds", Value: "2"}}},
+				{UID: "uid-1", Name: "run-1"},
+			},
+		}},
+		v1alpha: &v1alpha1.VariableStore{ObjectMeta: meta, Spec: v1alpha1.VariableStoreSpec{
+			Vars: []v1alpha1.Var{},
+			Applied: []v1alpha1.AppliedRun{
+				{UID: "uid-2", Name: "run-2", Results: []v1alpha1.AppliedResult{{Name: "builds", Value: "2"}}},
+				{UID: "uid-1", Name: "run-1"},
+			},
+		}},
+	}, {
+		name: "overlays",
+		v1beta1: &VariableStore{ObjectMeta: meta, Spec: VariableStoreSpec{
+			Vars: map[string]Var{"count": {Int: ptr.Int64(1)}},
+			Overlays: map[string]Overlay{
+				"pr-2": {
+					UID:    "pr-2-uid",
+					Vars:   map[string]Var{"count": {Int: ptr.Int64(3)}, "name": {String: ptr.String("x")}},
+					Writes: []StagedWrite{{Name: "count", Operation: "Add", Value: "2", Type: "int"}, {Name: "name", Operation: "Set", Value: "x", Type: "string"}},
+				},
+				"pr-1": {},
+			},
+		}},
+		v1alpha: &v1alpha1.VariableStore{ObjectMeta: meta, Spec: v1alpha1.VariableStoreSpec{
+			Vars: []v1alpha1.Var{{Name: "count", Value: "1", Type: v1alpha1.VarTypeInt}},
+			Overlays: []v1alpha1.Overlay{
+				{PipelineRun: "pr-1"},
+				{PipelineRun: "pr-2", UID: "pr-2-uid", Vars: []v1alpha1.Var{
+					{Name: "count", Value: "3", Type: v1alpha1.VarTypeInt},
+					{Name: "name", Value: "x", Type: v1alpha1.VarTypeString},
+				}, Writes: []v1alpha1.StagedWrite{
+					{Name: "count", Operation: v1alpha1.OperationAdd, Value: "2", Type: v1alpha1.VarTypeInt},
+					{Name: "name", Operation: v1alpha1.OperationSet, Value: "x", Type: v1alpha1.VarTypeString},
+				}},
+			},
+		}},
+	}, {
+		name:    "empty vars",
+		v1beta1: &VariableStore{ObjectMeta: meta, Spec: VariableStoreSpec{Vars: map[string]Var{}}},
+		v1alpha: &v1alpha1.VariableStore{ObjectMeta: meta, Spec: v1alpha1.VariableStoreSpec{Vars: []v1alpha1.Var{}}},
+	}, {
+		name:    "no vars",
+		v1beta1: &VariableStore{ObjectMeta: meta},
+		v1alpha: &v1alpha1.VariableStore{ObjectMeta: meta},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotAlpha := &v1alpha1.VariableStore{}
+			if err := tc.v1beta1.ConvertTo(context.Background(), gotAlpha); err != nil {
+				t.Fatalf("ConvertTo() = %v", err)
+			}
+			if d := cmp.Diff(tc.v1alpha, gotAlpha); d != "" {
+				t.Errorf("ConvertTo() (-want, +got): %s", d)
+			}
+			gotBeta := &VariableStore{}
+			if err := gotBeta.ConvertFrom(context.Background(), gotAlpha); err != nil {
+				t.Fatalf("ConvertFrom() = %v", err)
+			}
+			if d := cmp.Diff(tc.v1beta1, gotBeta); d != "" {
+				t.Errorf("round trip (-want, +got): %s", d)
+			}
+		})
+	}
+}
+
+func TestVariableStoreConvertFrom(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		vars    []v1alpha1.Var
+		want    map[string]Var
+		wantErr string
+	}{{
+		name: "inferred types",
+		vars: []v1alpha1.Var{{Name: "a", Value: "true"}, {Name: "b", Value: "007"}, {Name: "c", Value: "1e3"}, {Name: "d", Value: "1.5"}},
+		want: map[string]Var{"a": {Bool: ptr.Bool(true)}, "b": {String: ptr.String("007")}, "c": {String: ptr.String("1e3")}, "d": {Double: ptr.Float64(1.5)}},
+	}, {
+		name: "canonical values",
+		vars: []v1alpha1.Var{{Name: "a", Value: " True", Type: v1alpha1.VarTypeBool}, {Name: "b", Value: " 007", Type: v1alpha1.VarTypeInt}},
+		want: map[string]Var{"a": {Bool: ptr.Bool(true)}, "b": {Int: ptr.Int64(7)}},
+	}, {
+		name:    "invalid value",
+		vars:    []v1alpha1.Var{{Name: "a", Value: "maybe", Type: v1alpha1.VarTypeBool}},
+		wantErr: "vars[0]: maybe is not a valid bool",
+	}, {
+		name: "duplicate name",
+		vars: []v1alpha1.Var{{Name: "a", Value: "1"}, {Name: "b", Value: "x"}, {Name: "a", Value: "2"}},
+		want: map[string]Var{"a": {Int: ptr.Int64(2)}, "b": {String: ptr.String("x")}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := &VariableStore{}
+			err := got.ConvertFrom(context.Background(), &v1alpha1.VariableStore{Spec: v1alpha1.VariableStoreSpec{Vars: tc.vars}})
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("ConvertFrom() = %v, want %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConvertFrom() = %v", err)
+			}
+			if d := cmp.Diff(tc.want, got.Spec.Vars); d != "" {
+				t.Errorf("ConvertFrom() (-want, +got): %s", d)
+			}
+		})
+	}
+}
+
+func TestVariableStoreConvertFromDuplicates(t *testing.T) {
+	// Older VariableStores may declare a lock or an overlay twice, the last declaration wins
+	source := &v1alpha1.VariableStore{Spec: v1alpha1.VariableStoreSpec{
+		Vars: []v1alpha1.Var{},
+		Locks: []v1alpha1.Lock{
+			{Name: "deploy", Capacity: 1},
+			{Name: "deploy", Capacity: 2, Holders: []v1alpha1.LockHolder{{Run: "run"}}},
+		},
+		Overlays: []v1alpha1.Overlay{
+			{PipelineRun: "pr", Vars: []v1alpha1.Var{{Name: "a", Value: "1"}}},
+			{PipelineRun: "pr", Vars: []v1alpha1.Var{{Name: "a", Value: "2"}}},
+		},
+	}}
+	got := &VariableStore{}
+	if err := got.ConvertFrom(context.Background(), source); err != nil {
+		t.Fatalf("ConvertFrom() = %v", err)
+	}
+	want := VariableStoreSpec{
+		Vars:     map[string]Var{},
+		Locks:    map[string]Lock{"deploy": {Capacity: 2, Holders: []LockHolder{{Run: "run"}}}},
+		Overlays: map[string]Overlay{"pr": {Vars: map[string]Var{"a": {Int: ptr.Int64(2)}}}},
+	}
+	if d := cmp.Diff(want, got.Spec); d != "" {
+		t.Errorf("ConvertFrom() (-want, +got): %s", d)
+	}
+}
+
+func TestVariableStoreConvertUnknownVersion(t *testing.T) {
+	vs := &VariableStore{}
+	if err := vs.ConvertTo(context.Background(), &VariableStore{}); err == nil {
+		t.Error("ConvertTo(v1beta1) = nil, want error")
+	}
+	if err := vs.ConvertFrom(context.Background(), &VariableStore{}); err == nil {
+		t.Error("ConvertFrom(v1beta1) = nil, want error")
+	}
+}
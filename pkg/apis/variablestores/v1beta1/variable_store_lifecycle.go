@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GetGroupVersionKind implements kmeta.OwnerRefable
+func (*VariableStore) GetGroupVersionKind() schema.GroupVersionKind {
+	return SchemeGroupVersion.WithKind("VariableStore")
+}
+
+// GetUntypedSpec implements apis.HasSpec, so that the webhook annotates VariableStores with their creator
+// and last modifier.
+func (vs *VariableStore) GetUntypedSpec() interface{} {
+	return vs.Spec
+}
+
+// SetDefaults implements apis.Defaultable
+func (vs *VariableStore) SetDefaults(ctx context.Context) {
+	// Nothing to default, the variables are typed and keyed by name.
+}
+
+// GetNamePolicy returns the NamePolicy of the VariableStore, or the default one when it is not set.
+func (vss *VariableStoreSpec) GetNamePolicy() NamePolicy {
+	if vss.NamePolicy == "" {
+		return NamePolicyAlias
+	}
+	return vss.NamePolicy
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/kmeta"
+)
+
+// VariableStore is a store of typed variables, the context of the CEL expressions of the Runs referencing it.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type VariableStore struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec holds the desired state of the VariableStore (from the client).
+	// +optional
+	Spec VariableStoreSpec `json:"spec,omitempty"`
+}
+
+var (
+	// Check that VariableStore can be validated, defaulted and converted.
+	_ apis.Validatable   = (*VariableStore)(nil)
+	_ apis.Defaultable   = (*VariableStore)(nil)
+	_ apis.Convertible   = (*VariableStore)(nil)
+	_ apis.HasSpec       = (*VariableStore)(nil)
+	_ kmeta.OwnerRefable = (*VariableStore)(nil)
+)
+
+// VariableStoreSpec holds the desired state of the VariableStore (from the client).
+type VariableStoreSpec struct {
+	// Vars holds the variables, keyed by name.
+	Vars map[string]Var `json:"vars"`
+
+	// NamePolicy defines how the variables whose names are not valid CEL identifiers are handled.
+	// Defaults to Alias.
+	// +optional
+	NamePolicy NamePolicy `json:"namePolicy,omitempty"`
+
+	// Locks holds the locks and counting semaphores acquired by Runs, keyed by name.
+	// +optional
+	Locks map[string]Lock `json:"locks,omitempty"`
+
+	// Applied records the latest Runs whose writes were applied to the VariableStore, the latest last.
+	// +optional
+	Applied []AppliedRun `json:"applied,omitempty"`
+
+	// Overlays holds the variables written by the transactional Runs of PipelineRuns, keyed by PipelineRun.
+	// +optional
+	Overlays map[string]Overlay `json:"overlays,omitempty"`
+}
+
+// Overlay holds the variables written by the transactional Runs of a PipelineRun, which are committed to
+// the variables of the VariableStore when the PipelineRun succeeds, and discarded otherwise.
+type Overlay struct {
+	// UID is the UID of the PipelineRun, so that a PipelineRun recreated with the same name doesn't commit
+	// the writes of the deleted one.
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+
+	// Vars holds the variables as written by the Runs of the PipelineRun, keyed by name.
+	// +optional
+	Vars map[string]Var `json:"vars,omitempty"`
+
+	// Writes holds the writes of the Runs of the PipelineRun, in the order they were applied to the overlay,
+	// which are applied again to the variables when the overlay is committed.
+	// +optional
+	Writes []StagedWrite `json:"writes,omitempty"`
+}
+
+// StagedWrite is a write of a transactional Run staged in an overlay.
+type StagedWrite struct {
+	// Name is the name of the variable written.
+	Name string `json:"name"`
+
+	// Operation is the operation the variable is written with: Set, Add, Append, Insert or Remove.
+	Operation string `json:"operation"`
+
+	// Value is the value written with Set, or the operand of the other operations, in JSON for Append,
+	// Insert and Remove.
+	Value string `json:"value"`
+
+	// Type is the type of the value written with Set, or of the operand of Add: string, bool, int or double.
+	// +optional
+	Type string `json:"type,omitempty"`
+}
+
+// AppliedRun is a Run whose writes were applied to the VariableStore.
+type AppliedRun struct {
+	// UID is the UID of the Run.
+	UID types.UID `json:"uid"`
+
+	// Name is the name of the Run.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Results are the results of the Run, the variables as written.
+	// +optional
+	Results []AppliedResult `json:"results,omitempty"`
+}
+
+// AppliedResult is a result of a Run whose writes were applied to the VariableStore.
+type AppliedResult struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Lock is a counting semaphore, a mutex when its capacity is 1.
+type Lock struct {
+	// Capacity is how many holders can hold the lock at the same time.
+	Capacity int64 `json:"capacity"`
+
+	// Holders are the holders of the lock, in the order they acquired it.
+	// +optional
+	Holders []LockHolder `json:"holders,omitempty"`
+}
+
+// LockHolder is the Run which acquired a lock, on behalf of the PipelineRun owning it if any.
+type LockHolder struct {
+	// Run is the name of the Run which acquired the lock.
+	Run string `json:"run"`
+
+	// PipelineRun is the name of the PipelineRun owning the Run, if any.
+	// +optional
+	PipelineRun string `json:"pipelineRun,omitempty"`
+
+	// UID is the UID of the PipelineRun holding the lock, or of the Run when it is not owned by one, so that
+	// a PipelineRun or a Run recreated with the same name doesn't hold the lock too.
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+}
+
+// NamePolicy defines how the variables whose names are not valid CEL identifiers, e.g. `is-red`, are handled.
+type NamePolicy string
+
+const (
+	// NamePolicyAlias exposes the variables whose names are not valid CEL identifiers to expressions
+	// under an alias, e.g. `is-red` as `is_red`.
+	NamePolicyAlias NamePolicy = "Alias"
+
+	// NamePolicyReject rejects the variables whose names are not valid CEL identifiers.
+	NamePolicyReject NamePolicy = "Reject"
+)
+
+// Var holds the value of a variable, exactly one of its fields is set according to the type of the value.
+type Var struct {
+	// +optional
+	String *string `json:"string,omitempty"`
+
+	// +optional
+	Bool *bool `json:"bool,omitempty"`
+
+	// +optional
+	Int *int64 `json:"int,omitempty"`
+
+	// +optional
+	Double *float64 `json:"double,omitempty"`
+}
+
+// VariableStoreList is a list of VariableStore resources
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type VariableStoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []VariableStore `json:"items"`
+}
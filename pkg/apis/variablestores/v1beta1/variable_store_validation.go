@@ -0,0 +1,251 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tektoncd/pipeline/pkg/apis/validate"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+	"knative.dev/pkg/apis"
+)
+
+// Validate implements apis.Validatable
+func (vs *VariableStore) Validate(ctx context.Context) *apis.FieldError {
+	if err := validate.ObjectMetadata(vs.GetObjectMeta()); err != nil {
+		return err.ViaField("metadata")
+	}
+	var errs *apis.FieldError
+	if apis.IsInUpdate(ctx) {
+		original := apis.GetBaseline(ctx).(*VariableStore)
+		errs = apis.ValidateCreatorAndModifier(original.Spec, vs.Spec, original.GetAnnotations(),
+			vs.GetAnnotations(), variablestores.GroupName).ViaField("metadata.annotations")
+	}
+	return errs.Also(vs.Spec.Validate(ctx).ViaField("spec"))
+}
+
+// Validate implements apis.Validatable
+func (vss *VariableStoreSpec) Validate(ctx context.Context) (errs *apis.FieldError) {
+	if vss.Vars == nil {
+		return apis.ErrMissingField("vars")
+	}
+	switch vss.GetNamePolicy() {
+	case NamePolicyAlias, NamePolicyReject:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(vss.NamePolicy, "namePolicy"))
+	}
+	for _, name := range vss.names() {
+		errs = errs.Also(vss.Vars[name].Validate(ctx).ViaFieldKey("vars", name))
+	}
+	for _, name := range vss.lockNames() {
+		errs = errs.Also(vss.Locks[name].Validate(ctx).ViaFieldKey("locks", name))
+	}
+	for i, run := range vss.Applied {
+		if run.UID == "" {
+			errs = errs.Also(apis.ErrMissingField("uid").ViaFieldIndex("applied", i))
+		}
+	}
+	for _, name := range vss.overlayNames() {
+		overlay := &VariableStoreSpec{Vars: vss.Overlays[name].Vars, NamePolicy: vss.NamePolicy}
+		for _, v := range overlay.names() {
+			errs = errs.Also(overlay.Vars[v].Validate(ctx).ViaFieldKey("vars", v).ViaFieldKey("overlays", name))
+		}
+		for i, w := range vss.Overlays[name].Writes {
+			errs = errs.Also(w.Validate(ctx).ViaFieldIndex("writes", i).ViaFieldKey("overlays", name))
+		}
+		errs = errs.Also(overlay.validateNames().ViaFieldKey("overlays", name))
+		errs = errs.Also(overlay.validateSizes(config.FromContextOrDefaults(ctx).Limits).ViaFieldKey("overlays", name))
+	}
+	errs = errs.Also(vss.validateNames())
+	errs = errs.Also(vss.validateSizes(config.FromContextOrDefaults(ctx).Limits))
+	return errs.Also(vss.validateSpecSize(config.FromContextOrDefaults(ctx).Limits))
+}
+
+// Validate implements apis.Validatable
+func (v Var) Validate(ctx context.Context) *apis.FieldError {
+	fields := v.fields()
+	switch len(fields) {
+	case 0:
+		return apis.ErrMissingOneOf("string", "bool", "int", "double")
+	case 1:
+		return nil
+	default:
+		return apis.ErrMultipleOneOf(fields...)
+	}
+}
+
+// Validate implements apis.Validatable
+func (l Lock) Validate(ctx context.Context) (errs *apis.FieldError) {
+	if l.Capacity < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(l.Capacity, "capacity"))
+	}
+	for i, holder := range l.Holders {
+		if holder.Run == "" {
+			errs = errs.Also(apis.ErrMissingField("run").ViaFieldIndex("holders", i))
+		}
+	}
+	return errs
+}
+
+// Validate implements apis.Validatable
+func (w StagedWrite) Validate(ctx context.Context) (errs *apis.FieldError) {
+	if w.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("name"))
+	}
+	switch v1alpha1.Operation(w.Operation) {
+	case v1alpha1.OperationSet, v1alpha1.OperationAdd, v1alpha1.OperationAppend, v1alpha1.OperationInsert, v1alpha1.OperationRemove:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(w.Operation, "operation"))
+	}
+	return errs
+}
+
+// fields returns the names of the fields of the variable which are set.
+func (v Var) fields() (fields []string) {
+	if v.String != nil {
+		fields = append(fields, "string")
+	}
+	if v.Bool != nil {
+		fields = append(fields, "bool")
+	}
+	if v.Int != nil {
+		fields = append(fields, "int")
+	}
+	if v.Double != nil {
+		fields = append(fields, "double")
+	}
+	return fields
+}
+
+// names returns the names of the variables in order, so that they are validated and converted in the
+// same order every time.
+func (vss *VariableStoreSpec) names() []string {
+	names := make([]string, 0, len(vss.Vars))
+	for name := range vss.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// overlayNames returns the names of the PipelineRuns of the overlays in order.
+func (vss *VariableStoreSpec) overlayNames() []string {
+	names := make([]string, 0, len(vss.Overlays))
+	for name := range vss.Overlays {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lockNames returns the names of the locks in order.
+func (vss *VariableStoreSpec) lockNames() []string {
+	names := make([]string, 0, len(vss.Locks))
+	for name := range vss.Locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateNames checks that every variable could be referenced in CEL expressions according to the
+// NamePolicy without colliding with the names bound by CEL.
+func (vss *VariableStoreSpec) validateNames() (errs *apis.FieldError) {
+	aliases := make(map[string]string, len(vss.Vars))
+	for _, name := range vss.names() {
+		if v1alpha1.IsReservedWord(name) {
+			errs = errs.Also(apis.ErrInvalidKeyName(name, "vars", "it is a CEL reserved word"))
+			continue
+		}
+		if !v1alpha1.IsIdentifier(name) && vss.GetNamePolicy() == NamePolicyReject {
+			errs = errs.Also(apis.ErrInvalidKeyName(name, "vars",
+				"it is not a valid CEL identifier and couldn't be referenced in expressions"))
+			continue
+		}
+		alias := v1alpha1.Alias(name)
+		if celenv.IsBuiltin(alias) {
+			errs = errs.Also(apis.ErrInvalidKeyName(name, "vars",
+				fmt.Sprintf("it is referenced in expressions as %s, which is a CEL built-in", alias)))
+			continue
+		}
+		if other, ok := aliases[alias]; ok {
+			errs = errs.Also(apis.ErrInvalidKeyName(name, "vars",
+				fmt.Sprintf("it is referenced in expressions as %s, which is already used by %s", alias, other)))
+			continue
+		}
+		aliases[alias] = name
+	}
+	return errs
+}
+
+// validateSizes checks that the string values of the variables, and the variables altogether, are within
+// the size limits, the latter keeping the VariableStore away from the etcd limit on the size of an object.
+func (vss *VariableStoreSpec) validateSizes(limits *config.Limits) (errs *apis.FieldError) {
+	if limits.MaxValueSize > 0 {
+		for _, name := range vss.names() {
+			value := vss.Vars[name].String
+			if value == nil {
+				continue
+			}
+			if size := int64(len(*value)); size > limits.MaxValueSize {
+				errs = errs.Also(&apis.FieldError{
+					Message: fmt.Sprintf("the value of %s takes %d bytes, over the limit of %d bytes", name, size, limits.MaxValueSize),
+					Paths:   []string{"string"},
+				}).ViaFieldKey("vars", name)
+			}
+		}
+	}
+	if limits.MaxStoreSize > 0 {
+		vars, err := json.Marshal(vss.Vars)
+		if err != nil {
+			return errs.Also(apis.ErrGeneric(err.Error(), "vars"))
+		}
+		if size := int64(len(vars)); size > limits.MaxStoreSize {
+			errs = errs.Also(&apis.FieldError{
+				Message: fmt.Sprintf("the variables take %d bytes, over the limit of %d bytes", size, limits.MaxStoreSize),
+				Paths:   []string{"vars"},
+			})
+		}
+	}
+	return errs
+}
+
+// validateSpecSize checks that the variables along with the locks, the records of the applied Runs and the
+// overlays are within the size limit on the VariableStore: they grow with the Runs writing it, and are
+// stored in the same object as the variables.
+func (vss *VariableStoreSpec) validateSpecSize(limits *config.Limits) *apis.FieldError {
+	if limits.MaxStoreSize <= 0 || len(vss.Locks)+len(vss.Applied)+len(vss.Overlays) == 0 {
+		return nil
+	}
+	spec, err := json.Marshal(vss)
+	if err != nil {
+		return apis.ErrGeneric(err.Error(), apis.CurrentField)
+	}
+	if size := int64(len(spec)); size > limits.MaxStoreSize {
+		return &apis.FieldError{
+			Message: fmt.Sprintf("the variables, locks, applied Runs and overlays take %d bytes, over the limit of %d bytes", size, limits.MaxStoreSize),
+			Paths:   []string{apis.CurrentField},
+		}
+	}
+	return nil
+}
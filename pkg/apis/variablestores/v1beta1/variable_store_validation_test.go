@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+)
+
+func TestVariableStoreValidate(t *testing.T) {
+	limits, err := config.NewLimitsFromMap(map[string]string{
+		"max-value-size": "10",
+		"max-store-size": "100",
+	})
+	if err != nil {
+		t.Fatalf("NewLimitsFromMap() = %v", err)
+	}
+	ctx := config.ToContext(context.Background(), &config.Config{Limits: limits})
+
+	for _, tc := range []struct {
+		name       string
+		vars       map[string]Var
+		namePolicy NamePolicy
+		locks      map[string]Lock
+		applied    []AppliedRun
+		overlays   map[string]Overlay
+		wantErr    string
+	}{{
+		name: "valid",
+		vars: map[string]Var{
+			"a":      {String: ptr.String("0123456789")},
+			"b":      {Bool: ptr.Bool(true)},
+			"c":      {Int: ptr.Int64(3)},
+			"is-red": {Double: ptr.Float64(0.5)},
+		},
+	}, {
+		name: "empty",
+		vars: map[string]Var{},
+	}, {
+		name:    "missing vars",
+		wantErr: "missing field(s): spec.vars",
+	}, {
+		name:    "missing value",
+		vars:    map[string]Var{"a": {}},
+		wantErr: "expected exactly one, got neither: spec.vars[a].bool, spec.vars[a].double, spec.vars[a].int, spec.vars[a].string",
+	}, {
+		name:    "multiple values",
+		vars:    map[string]Var{"a": {String: ptr.String("1"), Int: ptr.Int64(1)}},
+		wantErr: "expected exactly one, got both: spec.vars[a].int, spec.vars[a].string",
+	}, {
+		name:    "reserved word",
+		vars:    map[string]Var{"in": {Int: ptr.Int64(1)}},
+		wantErr: `invalid key name "in": spec.vars` + "\nit is a CEL reserved word",
+	}, {
+		name:       "rejected name",
+		vars:       map[string]Var{"is-red": {Bool: ptr.Bool(true)}},
+		namePolicy: NamePolicyReject,
+		wantErr:    `invalid key name "is-red": spec.vars`,
+	}, {
+		name:    "alias collision",
+		vars:    map[string]Var{"is-red": {Bool: ptr.Bool(true)}, "is_red": {Bool: ptr.Bool(false)}},
+		wantErr: "it is referenced in expressions as is_red, which is already used by is-red",
+	}, {
+		name:    "value size",
+		vars:    map[string]Var{"a": {String: ptr.String("0123456789a")}},
+		wantErr: "the value of a takes 11 bytes, over the limit of 10 bytes: spec.vars[a].string",
+	}, {
+		name:       "name policy",
+		vars:       map[string]Var{},
+		namePolicy: "Ignore",
+		wantErr:    "invalid value: Ignore: spec.namePolicy",
+	}, {
+		name:  "locks",
+		vars:  map[string]Var{},
+		locks: map[string]Lock{"deploy": {Capacity: 1, Holders: []LockHolder{{Run: "run"}}}},
+	}, {
+		name:    "lock capacity",
+		vars:    map[string]Var{},
+		locks:   map[string]Lock{"deploy": {Capacity: -1}},
+		wantErr: "invalid value: -1: spec.locks[deploy].capacity",
+	}, {
+		name:    "applied run uid",
+		vars:    map[string]Var{},
+		applied: []AppliedRun{{Name: "run"}},
+		wantErr: "missing field(s): spec.applied[0].uid",
+	}, {
+		name: "applied runs size",
+		vars: map[string]Var{"a": {String: ptr.String("1")}},
+		applied: []AppliedRun{
+			{UID: "uid-1", Name: "run-1", Results: []AppliedResult{{Name: "a", Value: "0123456789"}}},
+			{UID: "uid-2", Name: "run-2", Results: []AppliedResult{{Name: "a", Value: "0123456789"}}},
+		},
+		wantErr: "the variables, locks, applied Runs and overlays take 195 bytes, over the limit of 100 bytes: spec",
+	}, {
+		name:     "overlays",
+		vars:     map[string]Var{},
+		overlays: map[string]Overlay{"pr": {Vars: map[string]Var{"a": {Int: ptr.Int64(1)}}}},
+	}, {
+		name:     "overlay var",
+		vars:     map[string]Var{},
+		overlays: map[string]Overlay{"pr": {Vars: map[string]Var{"a": {}}}},
+		wantErr:  "expected exactly one, got neither: spec.overlays[pr].vars[a].bool",
+	}, {
+		name:     "overlay staged write",
+		vars:     map[string]Var{},
+		overlays: map[string]Overlay{"pr": {Writes: []StagedWrite{{Name: "a", Operation: "Multiply", Value: "2"}}}},
+		wantErr:  "invalid value: Multiply: spec.overlays[pr].writes[0].operation",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			vs := &VariableStore{
+				ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+				Spec:       VariableStoreSpec{Vars: tc.vars, NamePolicy: tc.namePolicy, Locks: tc.locks, Applied: tc.applied, Overlays: tc.overlays},
+			}
+			err := vs.Validate(ctx)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("Validate() = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+}
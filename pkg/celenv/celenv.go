@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package celenv declares the environment the CEL expressions of the Runs
+// referencing a VariableStore are compiled in, so that the reconciler and the
+// webhook agree on the variables and functions available to expressions.
+package celenv
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/parser"
+	"github.com/vincentpli/cel-tekton/pkg/celext"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+const (
+	// RunVar is the name of the variable exposing the metadata of the Run to expressions.
+	RunVar = "run"
+
+	// PipelineRunVar is the name of the variable exposing the metadata of the owning PipelineRun to expressions.
+	PipelineRunVar = "pipelineRun"
+
+	// VarsVar is the name of the variable exposing every variable of the context as a map to expressions,
+	// so that variables could be looked up dynamically, checked for presence and iterated.
+	VarsVar = "vars"
+
+	// TasksVar is the name of the variable exposing the other tasks of the owning PipelineRun to expressions.
+	TasksVar = "tasks"
+)
+
+// undeclaredRegexp matches the issues reported by the CEL checker for references to undeclared variables.
+var undeclaredRegexp = regexp.MustCompile(`undeclared reference to '([_a-zA-Z][_a-zA-Z0-9]*)'`)
+
+// MetadataDecls declares the read-only metadata variables available to every expression.
+var MetadataDecls = []*exprpb.Decl{
+	decls.NewVar(RunVar, decls.NewMapType(decls.String, decls.Dyn)),
+	decls.NewVar(PipelineRunVar, decls.NewMapType(decls.String, decls.Dyn)),
+}
+
+// VarsDecl declares the `vars` variable, keyed by variable name.
+var VarsDecl = decls.NewVar(VarsVar, decls.NewMapType(decls.String, decls.Dyn))
+
+// TasksDecl declares the `tasks` variable, keyed by pipeline task name.
+var TasksDecl = decls.NewVar(TasksVar, decls.NewMapType(decls.String, decls.Dyn))
+
+// builtins holds the names bound by the environment to functions, macros, types and variables, and the
+// namespaces of functions like `base64`.
+var builtins = func() map[string]struct{} {
+	names := map[string]struct{}{}
+	var ds []*exprpb.Decl
+	ds = append(ds, checker.StandardDeclarations()...)
+	ds = append(ds, celext.Declarations()...)
+	ds = append(ds, MetadataDecls...)
+	for _, d := range append(ds, VarsDecl, TasksDecl) {
+		names[strings.SplitN(d.GetName(), ".", 2)[0]] = struct{}{}
+	}
+	for _, m := range parser.AllMacros {
+		names[m.Function()] = struct{}{}
+	}
+	return names
+}()
+
+// IsBuiltin returns whether the name is bound by the environment, to a function like `size`, a macro like
+// `has`, a type like `int`, a variable like `vars`, or is the namespace of functions like `base64`. Variables
+// of those names would be confusing, or couldn't be declared at all.
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// NewEnv returns a program environment configured with the standard library of CEL functions and macros,
+// plus the functions provided by cel-tekton, the metadata of the Run and the `vars` map. The variables whose
+// names are taken, by the variables of a VariableStore or the params of a Run which predate them, are not
+// declared, so that these keep hiding them rather than failing to be declared.
+func NewEnv(taken map[string]struct{}) (*cel.Env, error) {
+	var ds []*exprpb.Decl
+	for _, d := range append(append([]*exprpb.Decl{}, MetadataDecls...), VarsDecl) {
+		if _, ok := taken[d.GetName()]; !ok {
+			ds = append(ds, d)
+		}
+	}
+	return cel.NewEnv(celext.Lib(), cel.Declarations(ds...))
+}
+
+// CompilePartial compiles the CEL expression like Env.Compile, except that the variables it references
+// but which are not declared are declared and added to unknowns. It returns the extended env. References
+// to undeclared functions are reported as is, rather than declaring variables of their names.
+func CompilePartial(env *cel.Env, expression string, unknowns map[string]struct{}) (*cel.Env, *cel.Ast, *cel.Issues) {
+	parsed, iss := env.Parse(expression)
+	if iss.Err() != nil {
+		return env, nil, iss
+	}
+	idents := map[string]struct{}{}
+	celext.Walk(parsed.Expr(), func(e *exprpb.Expr) {
+		if ident := e.GetIdentExpr(); ident != nil {
+			idents[ident.GetName()] = struct{}{}
+		}
+	})
+
+	for {
+		ast, iss := env.Compile(expression)
+		if iss.Err() == nil {
+			return env, ast, iss
+		}
+
+		var undeclared []string
+		for _, e := range iss.Errors() {
+			match := undeclaredRegexp.FindStringSubmatch(e.Message)
+			if match == nil {
+				// Not only undeclared references, report the issues as is
+				return env, ast, iss
+			}
+			if _, ok := idents[match[1]]; !ok {
+				// An undeclared function rather than variable, report the issues as is
+				return env, ast, iss
+			}
+			undeclared = append(undeclared, match[1])
+		}
+
+		for _, name := range undeclared {
+			unknowns[name] = struct{}{}
+			var err error
+			env, err = env.Extend(cel.Declarations(decls.NewVar(name, decls.Dyn)))
+			if err != nil {
+				return env, ast, iss
+			}
+		}
+	}
+}
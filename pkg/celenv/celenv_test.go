@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celenv
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+)
+
+func TestNewEnvVars(t *testing.T) {
+	env, err := NewEnv(nil)
+	if err != nil {
+		t.Fatalf("NewEnv() = %v", err)
+	}
+	ast, iss := env.Compile("vars['is-red'] == 'true' && !has(vars.missing)")
+	if iss.Err() != nil {
+		t.Fatalf("Compile() = %v", iss.Err())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		t.Fatalf("Program() = %v", err)
+	}
+	out, _, err := prg.Eval(map[string]interface{}{
+		RunVar:         map[string]interface{}{},
+		PipelineRunVar: map[string]interface{}{},
+		VarsVar:        map[string]interface{}{"is-red": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Eval() = %v", err)
+	}
+	if out != types.True {
+		t.Errorf("Eval() = %v, want true", out)
+	}
+}
+
+func TestNewEnvTaken(t *testing.T) {
+	// The variables of the environment whose names are taken are left to be declared by their owners
+	env, err := NewEnv(map[string]struct{}{VarsVar: {}, RunVar: {}})
+	if err != nil {
+		t.Fatalf("NewEnv() = %v", err)
+	}
+	if _, iss := env.Compile("pipelineRun.name"); iss.Err() != nil {
+		t.Errorf("Compile(pipelineRun.name) = %v", iss.Err())
+	}
+	for _, name := range []string{VarsVar, RunVar} {
+		if _, iss := env.Compile(name); iss.Err() == nil {
+			t.Errorf("Compile(%s) succeeded, want an undeclared reference", name)
+		}
+	}
+}
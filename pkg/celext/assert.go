@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// DefaultAssertionReason is the reason of the assertions which don't supply one.
+const DefaultAssertionReason = "AssertionFailed"
+
+// assertionPrefix prefixes the message of the CEL errors reporting a failed assertion, followed by the
+// reason and the message of the assertion.
+const assertionPrefix = "assertion failed: "
+
+// reasonRegexp matches the reasons an assertion can supply, CamelCase like the reasons of conditions.
+var reasonRegexp = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// assertLib provides a function failing the evaluation with a message when a condition is false:
+//
+//	assert(size(vars) > 0, 'no variables')                     // true, or fails with the reason AssertionFailed
+//	assert(job_priority != 'high', 'PolicyViolation', 'nope')  // true, or fails with the reason PolicyViolation
+//
+// The failure is a CEL error, so that `||` and `&&` absorb it like any other error when their other operand
+// decides the result on its own. AssertionFailure recovers the reason and the message from the error.
+type assertLib struct{}
+
+// CompileOptions implements cel.Library.
+func (l assertLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Declarations(l.declarations()...),
+	}
+}
+
+// declarations implements funcLib.
+func (assertLib) declarations() []*exprpb.Decl {
+	return []*exprpb.Decl{
+		decls.NewFunction("assert",
+			decls.NewOverload("assert_bool_string",
+				[]*exprpb.Type{decls.Bool, decls.String}, decls.Bool),
+			decls.NewOverload("assert_bool_string_string",
+				[]*exprpb.Type{decls.Bool, decls.String, decls.String}, decls.Bool)),
+	}
+}
+
+// ProgramOptions implements cel.Library.
+func (assertLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Functions(
+			&functions.Overload{Operator: "assert_bool_string", Binary: func(cond, message ref.Val) ref.Val {
+				return assert(cond, types.String(DefaultAssertionReason), message)
+			}},
+			&functions.Overload{Operator: "assert_bool_string_string", Function: func(args ...ref.Val) ref.Val {
+				if len(args) != 3 {
+					return types.NoSuchOverloadErr()
+				}
+				return assert(args[0], args[1], args[2])
+			}},
+		),
+	}
+}
+
+func assert(cond, reason, message ref.Val) ref.Val {
+	b, ok := cond.(types.Bool)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(cond)
+	}
+	r, ok := reason.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(reason)
+	}
+	m, ok := message.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(message)
+	}
+	if !reasonRegexp.MatchString(string(r)) {
+		return types.NewErr("invalid assertion reason %q, must be CamelCase", string(r))
+	}
+	if b {
+		return types.True
+	}
+	return types.NewErr("%s%s: %s", assertionPrefix, string(r), string(m))
+}
+
+// AssertionFailure returns the reason and the message of the failed assertion the error returned by the
+// evaluation of an expression reports, if it reports one.
+func AssertionFailure(err error) (reason, message string, ok bool) {
+	if err == nil || !strings.HasPrefix(err.Error(), assertionPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(err.Error(), assertionPrefix), ": ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+)
+
+func TestAssert(t *testing.T) {
+	for _, tc := range []struct {
+		expr        string
+		wantReason  string
+		wantMessage string
+	}{
+		{expr: "assert(1 < 2, 'never')"},
+		{expr: "assert(1 > 2, 'one is not greater than two')", wantReason: "AssertionFailed", wantMessage: "one is not greater than two"},
+		{expr: "assert(1 > 2, 'PolicyViolation', 'a: b')", wantReason: "PolicyViolation", wantMessage: "a: b"},
+		{expr: "assert(1 > 2, 'never') || true"},
+		{expr: "1 < 2 && assert(1 > 2, 'then')", wantReason: "AssertionFailed", wantMessage: "then"},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			got, err := eval(t, tc.expr)
+			reason, message, ok := AssertionFailure(err)
+			if tc.wantReason == "" {
+				if err != nil {
+					t.Fatalf("Eval() = %v", err)
+				}
+				if got != types.True {
+					t.Errorf("Eval() = %v, want true", got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("AssertionFailure(%v) = false, want true", err)
+			}
+			if reason != tc.wantReason || message != tc.wantMessage {
+				t.Errorf("AssertionFailure() = %q, %q, want %q, %q", reason, message, tc.wantReason, tc.wantMessage)
+			}
+		})
+	}
+}
+
+func TestAssertInvalidReason(t *testing.T) {
+	_, err := eval(t, "assert(1 > 2, 'not a reason', 'message')")
+	if err == nil {
+		t.Fatal("Eval() succeeded, want error")
+	}
+	if _, _, ok := AssertionFailure(err); ok {
+		t.Errorf("AssertionFailure(%v) = true, want false", err)
+	}
+}
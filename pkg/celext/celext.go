@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package celext holds the extra CEL functions made available to the
+// expressions evaluated by cel-tekton, on top of the CEL standard library.
+package celext
+
+import (
+	"github.com/google/cel-go/cel"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Lib returns an EnvOption which registers every function library in this
+// package with a CEL environment.
+func Lib() cel.EnvOption {
+	return cel.Lib(library{})
+}
+
+// Declarations returns the declarations of every function in this package.
+func Declarations() []*exprpb.Decl {
+	var ds []*exprpb.Decl
+	for _, l := range libraries {
+		ds = append(ds, l.declarations()...)
+	}
+	return ds
+}
+
+// funcLib is a function library which declares its functions.
+type funcLib interface {
+	cel.Library
+	declarations() []*exprpb.Decl
+}
+
+// library aggregates the individual function libraries of this package.
+type library struct{}
+
+var libraries = []funcLib{
+	networkLib{},
+	encodingLib{},
+	assertLib{},
+}
+
+// CompileOptions implements cel.Library.
+func (library) CompileOptions() []cel.EnvOption {
+	var opts []cel.EnvOption
+	for _, l := range libraries {
+		opts = append(opts, l.CompileOptions()...)
+	}
+	return opts
+}
+
+// ProgramOptions implements cel.Library.
+func (library) ProgramOptions() []cel.ProgramOption {
+	var opts []cel.ProgramOption
+	for _, l := range libraries {
+		opts = append(opts, l.ProgramOptions()...)
+	}
+	return opts
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"math"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// encodingLib provides functions to encode, decode and hash strings and bytes:
+//
+//	base64.encode('hello')         // 'aGVsbG8='
+//	base64.decode('aGVsbG8=')      // 'hello'
+//	hex.encode('hello')            // '68656c6c6f'
+//	hex.decode('68656c6c6f')       // 'hello'
+//	sha256(commit) % 100           // a stable bucket in [0, 100)
+//	fnv(user) % 10                 // a stable bucket in [0, 10)
+//
+// base64.decode and hex.decode fail when the decoded bytes aren't valid UTF-8, since
+// their results are strings. sha256 and fnv return a non-negative int derived from
+// the digest so that they could be used for deterministic bucketing.
+type encodingLib struct{}
+
+// CompileOptions implements cel.Library.
+func (l encodingLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Declarations(l.declarations()...),
+	}
+}
+
+// declarations implements funcLib.
+func (encodingLib) declarations() []*exprpb.Decl {
+	return []*exprpb.Decl{
+		decls.NewFunction("base64.encode",
+			decls.NewOverload("base64_encode_string",
+				[]*exprpb.Type{decls.String}, decls.String),
+			decls.NewOverload("base64_encode_bytes",
+				[]*exprpb.Type{decls.Bytes}, decls.String)),
+		decls.NewFunction("base64.decode",
+			decls.NewOverload("base64_decode_string",
+				[]*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("hex.encode",
+			decls.NewOverload("hex_encode_string",
+				[]*exprpb.Type{decls.String}, decls.String),
+			decls.NewOverload("hex_encode_bytes",
+				[]*exprpb.Type{decls.Bytes}, decls.String)),
+		decls.NewFunction("hex.decode",
+			decls.NewOverload("hex_decode_string",
+				[]*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("sha256",
+			decls.NewOverload("sha256_string",
+				[]*exprpb.Type{decls.String}, decls.Int),
+			decls.NewOverload("sha256_bytes",
+				[]*exprpb.Type{decls.Bytes}, decls.Int)),
+		decls.NewFunction("fnv",
+			decls.NewOverload("fnv_string",
+				[]*exprpb.Type{decls.String}, decls.Int),
+			decls.NewOverload("fnv_bytes",
+				[]*exprpb.Type{decls.Bytes}, decls.Int)),
+	}
+}
+
+// ProgramOptions implements cel.Library.
+func (encodingLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Functions(
+			&functions.Overload{Operator: "base64_encode_string", Unary: base64Encode},
+			&functions.Overload{Operator: "base64_encode_bytes", Unary: base64Encode},
+			&functions.Overload{Operator: "base64_decode_string", Unary: base64Decode},
+			&functions.Overload{Operator: "hex_encode_string", Unary: hexEncode},
+			&functions.Overload{Operator: "hex_encode_bytes", Unary: hexEncode},
+			&functions.Overload{Operator: "hex_decode_string", Unary: hexDecode},
+			&functions.Overload{Operator: "sha256_string", Unary: sha256Sum},
+			&functions.Overload{Operator: "sha256_bytes", Unary: sha256Sum},
+			&functions.Overload{Operator: "fnv_string", Unary: fnvSum},
+			&functions.Overload{Operator: "fnv_bytes", Unary: fnvSum},
+		),
+	}
+}
+
+func base64Encode(val ref.Val) ref.Val {
+	b, err := toBytes(val)
+	if err != nil {
+		return err
+	}
+	return types.String(base64.StdEncoding.EncodeToString(b))
+}
+
+func base64Decode(val ref.Val) ref.Val {
+	str, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	b, err := base64.StdEncoding.DecodeString(string(str))
+	if err != nil {
+		return types.NewErr("invalid base64 string: %v", err)
+	}
+	return decodedString(b)
+}
+
+func hexEncode(val ref.Val) ref.Val {
+	b, err := toBytes(val)
+	if err != nil {
+		return err
+	}
+	return types.String(hex.EncodeToString(b))
+}
+
+func hexDecode(val ref.Val) ref.Val {
+	str, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	b, err := hex.DecodeString(string(str))
+	if err != nil {
+		return types.NewErr("invalid hex string: %v", err)
+	}
+	return decodedString(b)
+}
+
+// decodedString returns the decoded bytes as a string, or an error when they aren't valid UTF-8.
+func decodedString(b []byte) ref.Val {
+	if !utf8.Valid(b) {
+		return types.NewErr("decoded bytes are not valid UTF-8")
+	}
+	return types.String(b)
+}
+
+func sha256Sum(val ref.Val) ref.Val {
+	b, err := toBytes(val)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	return types.Int(binary.BigEndian.Uint64(sum[:8]) & math.MaxInt64)
+}
+
+func fnvSum(val ref.Val) ref.Val {
+	b, err := toBytes(val)
+	if err != nil {
+		return err
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return types.Int(h.Sum64() & math.MaxInt64)
+}
+
+// toBytes converts a CEL string or bytes value into a byte slice, or returns a
+// CEL error value when it is neither.
+func toBytes(val ref.Val) ([]byte, ref.Val) {
+	switch v := val.(type) {
+	case types.String:
+		return []byte(v), nil
+	case types.Bytes:
+		return []byte(v), nil
+	default:
+		return nil, types.MaybeNoSuchOverloadErr(val)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func TestEncodingFunctions(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want ref.Val
+	}{
+		{expr: "base64.encode('hello')", want: types.String("aGVsbG8=")},
+		{expr: "base64.encode(b'hello')", want: types.String("aGVsbG8=")},
+		{expr: "base64.decode('aGVsbG8=')", want: types.String("hello")},
+		{expr: "hex.encode('hello')", want: types.String("68656c6c6f")},
+		{expr: "hex.encode(b'\\xff')", want: types.String("ff")},
+		{expr: "hex.decode('68656c6c6f')", want: types.String("hello")},
+		{expr: "sha256('hello') == sha256(b'hello')", want: types.True},
+		{expr: "sha256('hello') >= 0", want: types.True},
+		{expr: "sha256('hello') == 3238736544897475342", want: types.True},
+		{expr: "fnv('hello') == fnv(b'hello')", want: types.True},
+		{expr: "fnv('hello') % 100 < 100", want: types.True},
+		{expr: "fnv('hello') == 2607821981565500683", want: types.True},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			got, err := eval(t, tc.expr)
+			if err != nil {
+				t.Fatalf("Eval() = %v", err)
+			}
+			if got.Equal(tc.want) != types.True {
+				t.Errorf("Eval() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncodingFunctionsInvalidInput(t *testing.T) {
+	for _, expr := range []string{
+		"base64.decode('not base64!')",
+		"hex.decode('xyz')",
+		"base64.decode('/w==')",
+		"hex.decode('ff')",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := eval(t, expr); err == nil {
+				t.Errorf("Eval(%q) succeeded, want error", expr)
+			}
+		})
+	}
+}
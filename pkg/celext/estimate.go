@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"math"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// EstimateCost returns an upper bound of the cost of the evaluation of the checked Ast, counted like the
+// evaluation does: every sub-expression but constants counts once, and the loop of a comprehension like
+// `map` or `filter` counts once per element iterated. The number of elements of a list or a map is known
+// for literals and for the variables in sizes, any other is assumed to hold defaultSize elements.
+func EstimateCost(ast *cel.Ast, sizes map[string]int64, defaultSize int64) (int64, error) {
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return 0, err
+	}
+	e := estimator{sizes: sizes, defaultSize: defaultSize}
+	return e.cost(checked.GetExpr()), nil
+}
+
+// Functions returns the sorted names of the functions and operators called by the checked Ast, with
+// namespaced functions like `base64.encode` under their qualified name.
+func Functions(ast *cel.Ast) ([]string, error) {
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	names := []string{}
+	Walk(checked.GetExpr(), func(e *exprpb.Expr) {
+		name := e.GetCallExpr().GetFunction()
+		if name == "" {
+			return
+		}
+		if _, ok := seen[name]; ok {
+			return
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	return names, nil
+}
+
+type estimator struct {
+	sizes       map[string]int64
+	defaultSize int64
+}
+
+func (es estimator) cost(e *exprpb.Expr) int64 {
+	switch k := e.GetExprKind().(type) {
+	case *exprpb.Expr_ConstExpr:
+		return 0
+	case *exprpb.Expr_IdentExpr:
+		return 1
+	case *exprpb.Expr_SelectExpr:
+		return add(1, es.cost(k.SelectExpr.GetOperand()))
+	case *exprpb.Expr_CallExpr:
+		c := int64(1)
+		if k.CallExpr.GetTarget() != nil {
+			c = add(c, es.cost(k.CallExpr.GetTarget()))
+		}
+		for _, arg := range k.CallExpr.GetArgs() {
+			c = add(c, es.cost(arg))
+		}
+		return c
+	case *exprpb.Expr_ListExpr:
+		c := int64(1)
+		for _, elem := range k.ListExpr.GetElements() {
+			c = add(c, es.cost(elem))
+		}
+		return c
+	case *exprpb.Expr_StructExpr:
+		c := int64(1)
+		for _, entry := range k.StructExpr.GetEntries() {
+			c = add(c, add(es.cost(entry.GetMapKey()), es.cost(entry.GetValue())))
+		}
+		return c
+	case *exprpb.Expr_ComprehensionExpr:
+		comp := k.ComprehensionExpr
+		loop := add(es.cost(comp.GetLoopCondition()), es.cost(comp.GetLoopStep()))
+		c := add(1, es.cost(comp.GetIterRange()))
+		c = add(c, es.cost(comp.GetAccuInit()))
+		c = add(c, multiply(es.size(comp.GetIterRange()), loop))
+		return add(c, es.cost(comp.GetResult()))
+	}
+	return 1
+}
+
+// size returns an upper bound of the number of elements of the list or map the expression evaluates to.
+func (es estimator) size(e *exprpb.Expr) int64 {
+	switch k := e.GetExprKind().(type) {
+	case *exprpb.Expr_IdentExpr:
+		if size, ok := es.sizes[k.IdentExpr.GetName()]; ok {
+			return size
+		}
+	case *exprpb.Expr_ListExpr:
+		return int64(len(k.ListExpr.GetElements()))
+	case *exprpb.Expr_StructExpr:
+		return int64(len(k.StructExpr.GetEntries()))
+	case *exprpb.Expr_ComprehensionExpr:
+		// Macros like `map` and `filter` produce at most as many elements as they iterate
+		return es.size(k.ComprehensionExpr.GetIterRange())
+	case *exprpb.Expr_CallExpr:
+		if k.CallExpr.GetFunction() == "_+_" && len(k.CallExpr.GetArgs()) == 2 {
+			return add(es.size(k.CallExpr.GetArgs()[0]), es.size(k.CallExpr.GetArgs()[1]))
+		}
+	}
+	return es.defaultSize
+}
+
+// Walk calls visit for the expression and every one of its sub-expressions.
+func Walk(e *exprpb.Expr, visit func(*exprpb.Expr)) {
+	if e == nil {
+		return
+	}
+	visit(e)
+	switch k := e.GetExprKind().(type) {
+	case *exprpb.Expr_SelectExpr:
+		Walk(k.SelectExpr.GetOperand(), visit)
+	case *exprpb.Expr_CallExpr:
+		Walk(k.CallExpr.GetTarget(), visit)
+		for _, arg := range k.CallExpr.GetArgs() {
+			Walk(arg, visit)
+		}
+	case *exprpb.Expr_ListExpr:
+		for _, elem := range k.ListExpr.GetElements() {
+			Walk(elem, visit)
+		}
+	case *exprpb.Expr_StructExpr:
+		for _, entry := range k.StructExpr.GetEntries() {
+			Walk(entry.GetMapKey(), visit)
+			Walk(entry.GetValue(), visit)
+		}
+	case *exprpb.Expr_ComprehensionExpr:
+		comp := k.ComprehensionExpr
+		Walk(comp.GetIterRange(), visit)
+		Walk(comp.GetAccuInit(), visit)
+		Walk(comp.GetLoopCondition(), visit)
+		Walk(comp.GetLoopStep(), visit)
+		Walk(comp.GetResult(), visit)
+	}
+}
+
+// add returns a+b, saturating instead of overflowing.
+func add(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// multiply returns a*b for non-negative a and b, saturating instead of overflowing.
+func multiply(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/go-cmp/cmp"
+)
+
+func compile(t *testing.T, expr string) *cel.Ast {
+	t.Helper()
+	env, err := cel.NewEnv(Lib(), cel.Declarations(
+		decls.NewVar("names", decls.NewListType(decls.String)),
+		decls.NewVar("name", decls.String),
+	))
+	if err != nil {
+		t.Fatalf("cel.NewEnv() = %v", err)
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		t.Fatalf("Compile(%q) = %v", expr, iss.Err())
+	}
+	return ast
+}
+
+func TestEstimateCost(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		sizes map[string]int64
+		want  int64
+	}{
+		{expr: "'a'", want: 0},
+		{expr: "name == 'a'", want: 2},
+		// The loop of `map` costs 5 per element, the rest of the comprehension 4
+		{expr: "[1, 2, 3].map(x, x * 2)", want: 4 + 3*5},
+		{expr: "names.map(x, x + 'a')", want: 4 + 10*5},
+		{expr: "names.map(x, x + 'a')", sizes: map[string]int64{"names": 2}, want: 4 + 2*5},
+		{expr: "names.all(x, names.exists(y, x == y))", sizes: map[string]int64{"names": 100}, want: 80703},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			got, err := EstimateCost(compile(t, tc.expr), tc.sizes, 10)
+			if err != nil {
+				t.Fatalf("EstimateCost() = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("EstimateCost(%q) = %d, want %d", tc.expr, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	got, err := Functions(compile(t, "name.matches('^a') && base64.encode(name) != ''"))
+	if err != nil {
+		t.Fatalf("Functions() = %v", err)
+	}
+	want := []string{"_!=_", "_&&_", "base64.encode", "matches"}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("Functions() diff (-want, +got): %s", d)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// networkLib provides functions to parse IP addresses, CIDR ranges and URLs:
+//
+//	ip('10.0.0.1')                     // canonical form of the address, '10.0.0.1'
+//	isIP('10.0.0.1')                   // true
+//	isIPv4('10.0.0.1')                 // true
+//	isIPv6('::1')                      // true
+//	inCIDR('10.0.0.1', '10.0.0.0/8')   // true
+//	parseURL('https://example.com:8443/a?b=c').port // '8443'
+//
+// parseURL returns a map with the keys scheme, host, port, path and query.
+type networkLib struct{}
+
+// CompileOptions implements cel.Library.
+func (l networkLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Declarations(l.declarations()...),
+	}
+}
+
+// declarations implements funcLib.
+func (networkLib) declarations() []*exprpb.Decl {
+	return []*exprpb.Decl{
+		decls.NewFunction("ip",
+			decls.NewOverload("ip_string",
+				[]*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("isIP",
+			decls.NewOverload("isIP_string",
+				[]*exprpb.Type{decls.String}, decls.Bool)),
+		decls.NewFunction("isIPv4",
+			decls.NewOverload("isIPv4_string",
+				[]*exprpb.Type{decls.String}, decls.Bool)),
+		decls.NewFunction("isIPv6",
+			decls.NewOverload("isIPv6_string",
+				[]*exprpb.Type{decls.String}, decls.Bool)),
+		decls.NewFunction("inCIDR",
+			decls.NewOverload("inCIDR_string_string",
+				[]*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+		decls.NewFunction("parseURL",
+			decls.NewOverload("parseURL_string",
+				[]*exprpb.Type{decls.String}, decls.NewMapType(decls.String, decls.String))),
+	}
+}
+
+// ProgramOptions implements cel.Library.
+func (networkLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Functions(
+			&functions.Overload{Operator: "ip", Unary: canonicalIP},
+			&functions.Overload{Operator: "isIP", Unary: isIP},
+			&functions.Overload{Operator: "isIPv4", Unary: isIPv4},
+			&functions.Overload{Operator: "isIPv6", Unary: isIPv6},
+			&functions.Overload{Operator: "inCIDR", Binary: inCIDR},
+			&functions.Overload{Operator: "parseURL", Unary: parseURL},
+		),
+	}
+}
+
+func canonicalIP(val ref.Val) ref.Val {
+	ip, err := toIP(val)
+	if err != nil {
+		return err
+	}
+	return types.String(ip.String())
+}
+
+func isIP(val ref.Val) ref.Val {
+	str, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	return types.Bool(net.ParseIP(string(str)) != nil)
+}
+
+func isIPv4(val ref.Val) ref.Val {
+	str, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	ip := net.ParseIP(string(str))
+	return types.Bool(ip != nil && ip.To4() != nil)
+}
+
+func isIPv6(val ref.Val) ref.Val {
+	str, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	ip := net.ParseIP(string(str))
+	return types.Bool(ip != nil && ip.To4() == nil)
+}
+
+func inCIDR(lhs, rhs ref.Val) ref.Val {
+	ip, err := toIP(lhs)
+	if err != nil {
+		return err
+	}
+	str, ok := rhs.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(rhs)
+	}
+	_, ipNet, perr := net.ParseCIDR(string(str))
+	if perr != nil {
+		return types.NewErr("invalid CIDR %q: %v", string(str), perr)
+	}
+	return types.Bool(ipNet.Contains(ip))
+}
+
+func parseURL(val ref.Val) ref.Val {
+	str, ok := val.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(val)
+	}
+	u, err := url.Parse(string(str))
+	if err != nil {
+		return types.NewErr("invalid URL %q: %v", string(str), err)
+	}
+	return types.NewStringStringMap(types.DefaultTypeAdapter, map[string]string{
+		"scheme": u.Scheme,
+		"host":   u.Hostname(),
+		"port":   u.Port(),
+		"path":   u.Path,
+		"query":  u.RawQuery,
+	})
+}
+
+// toIP converts a CEL string into an IP address, or returns a CEL error
+// value when it is not one.
+func toIP(val ref.Val) (net.IP, ref.Val) {
+	str, ok := val.(types.String)
+	if !ok {
+		return nil, types.MaybeNoSuchOverloadErr(val)
+	}
+	ip := net.ParseIP(string(str))
+	if ip == nil {
+		return nil, types.NewErr("invalid IP address %q", string(str))
+	}
+	return ip, nil
+}
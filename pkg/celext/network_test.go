@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package celext
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func eval(t *testing.T, expr string) (ref.Val, error) {
+	t.Helper()
+	env, err := cel.NewEnv(Lib())
+	if err != nil {
+		t.Fatalf("cel.NewEnv() = %v", err)
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		t.Fatalf("Compile(%q) = %v", expr, iss.Err())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		t.Fatalf("Program(%q) = %v", expr, err)
+	}
+	out, _, err := prg.Eval(map[string]interface{}{})
+	return out, err
+}
+
+func TestNetworkFunctions(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want ref.Val
+	}{
+		{expr: "ip('10.0.0.1')", want: types.String("10.0.0.1")},
+		{expr: "ip('2001:db8:0:0::1')", want: types.String("2001:db8::1")},
+		{expr: "isIP('10.0.0.1')", want: types.True},
+		{expr: "isIP('10.0.0')", want: types.False},
+		{expr: "isIPv4('10.0.0.1')", want: types.True},
+		{expr: "isIPv4('::1')", want: types.False},
+		{expr: "isIPv6('::1')", want: types.True},
+		{expr: "isIPv6('10.0.0.1')", want: types.False},
+		{expr: "inCIDR('10.1.2.3', '10.0.0.0/8')", want: types.True},
+		{expr: "inCIDR('192.168.0.1', '10.0.0.0/8')", want: types.False},
+		{expr: "inCIDR('2001:db8::1', '2001:db8::/32')", want: types.True},
+		{expr: "parseURL('https://example.com:8443/a/b?c=d').scheme", want: types.String("https")},
+		{expr: "parseURL('https://example.com:8443/a/b?c=d').host", want: types.String("example.com")},
+		{expr: "parseURL('https://example.com:8443/a/b?c=d').port", want: types.String("8443")},
+		{expr: "parseURL('https://example.com:8443/a/b?c=d').path", want: types.String("/a/b")},
+		{expr: "parseURL('https://example.com:8443/a/b?c=d').query", want: types.String("c=d")},
+		{expr: "parseURL('https://example.com').port", want: types.String("")},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			got, err := eval(t, tc.expr)
+			if err != nil {
+				t.Fatalf("Eval() = %v", err)
+			}
+			if got.Equal(tc.want) != types.True {
+				t.Errorf("Eval() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNetworkFunctionsInvalidInput(t *testing.T) {
+	for _, expr := range []string{
+		"ip('not-an-ip')",
+		"inCIDR('10.0.0.256', '10.0.0.0/8')",
+		"inCIDR('10.0.0.1', '10.0.0.0/33')",
+		"parseURL('http://[::1')",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := eval(t, expr); err == nil {
+				t.Errorf("Eval(%q) succeeded, want error", expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transaction holds the controller committing and discarding the overlays of the PipelineRuns whose
+// transactional Runs staged writes in VariableStores. It is a package of its own so that only the binaries
+// running it inject the VariableStore informer, which the CEL controller has no access to.
+package transaction
+
+import (
+	"context"
+
+	pipelineruninformer "github.com/tektoncd/pipeline/pkg/client/injection/informers/pipeline/v1beta1/pipelinerun"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	variablestoreclient "github.com/vincentpli/cel-tekton/pkg/client/injection/client"
+	variablestoreinformer "github.com/vincentpli/cel-tekton/pkg/client/injection/informers/variablestores/v1alpha1/variablestore"
+	"github.com/vincentpli/cel-tekton/pkg/reconciler/variablestore"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+)
+
+// NewController creates a TransactionReconciler of the PipelineRuns whose transactional Runs staged writes in
+// VariableStores, and returns the result of NewImpl. The VariableStore informer is injected, so that sharedmain
+// starts it and waits for it to sync before the overlays are looked up.
+func NewController(
+	ctx context.Context,
+	cmw configmap.Watcher,
+) *controller.Impl {
+	logger := logging.FromContext(ctx)
+
+	pipelineRunInformer := pipelineruninformer.Get(ctx)
+	variablestoreInformer := variablestoreinformer.Get(ctx)
+
+	r := variablestore.NewTransactionReconciler(
+		variablestoreclient.Get(ctx),
+		pipelineRunInformer.Lister(),
+		variablestoreInformer.Lister(),
+	)
+	impl := controller.NewImpl(r, logger, "transactions")
+
+	logger.Info("Setting up event handlers.")
+
+	pipelineRunInformer.Informer().AddEventHandler(controller.HandleAll(impl.Enqueue))
+
+	// The overlays of the PipelineRuns which finished before their Runs staged writes, or while the controller
+	// was down, are closed as soon as they are seen
+	variablestoreInformer.Informer().AddEventHandler(controller.HandleAll(func(obj interface{}) {
+		store, ok := obj.(*variablestorev1alpha1.VariableStore)
+		if !ok {
+			return
+		}
+		for _, overlay := range store.Spec.Overlays {
+			impl.EnqueueKey(types.NamespacedName{Namespace: store.Namespace, Name: overlay.PipelineRun})
+		}
+	}))
+
+	return impl
+}
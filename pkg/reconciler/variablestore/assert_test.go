@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"knative.dev/pkg/apis"
+)
+
+func TestReconcileAssert(t *testing.T) {
+	vars := []variablestorev1alpha1.Var{{Name: "alert_enable", Value: "false"}, {Name: "job_priority", Value: "high"}}
+	for _, tc := range []struct {
+		name        string
+		params      []v1beta1.Param
+		wantReason  string
+		wantMessage string
+	}{{
+		name: "holds",
+		params: []v1beta1.Param{
+			stringParam("severity", "'Sev-2'"),
+			stringParam("gate", "assert(alert_enable == 'true' || severity != 'Sev-1', 'alerts must be on for Sev-1')"),
+		},
+	}, {
+		name: "fails",
+		params: []v1beta1.Param{
+			stringParam("severity", "'Sev-1'"),
+			stringParam("gate", "assert(alert_enable == 'true' || job_priority != 'high', 'alerts must be on for high priority')"),
+		},
+		wantReason:  "AssertionFailed",
+		wantMessage: "alerts must be on for high priority",
+	}, {
+		name: "fails with a reason",
+		params: []v1beta1.Param{
+			stringParam("severity", "'Sev-1'"),
+			stringParam("gate", "assert(alert_enable == 'true', 'AlertsDisabled', 'alerts are disabled')"),
+		},
+		wantReason:  "AlertsDisabled",
+		wantMessage: "alerts are disabled",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			run, store := reconcileRun(t, vars, nil, tc.params...)
+			condition := run.Status.GetCondition(apis.ConditionSucceeded)
+			if tc.wantReason == "" {
+				if !condition.IsTrue() {
+					t.Fatalf("reconcile() set the condition %v", condition)
+				}
+				if got := run.Status.Results[1].Value; got != "true" {
+					t.Errorf("result gate = %q, want true", got)
+				}
+				return
+			}
+			if !condition.IsFalse() || condition.Reason != tc.wantReason || condition.Message != tc.wantMessage {
+				t.Errorf("reconcile() set the condition %v, want the reason %s and the message %q", condition, tc.wantReason, tc.wantMessage)
+			}
+			// A failed assertion writes nothing back, not even the results evaluated before it
+			if len(store.Spec.Vars) != len(vars) {
+				t.Errorf("VariableStore vars = %v, want %v", store.Spec.Vars, vars)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	celv1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/cel/v1alpha1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	variablestorev1beta1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+func TestReferences(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		stateless bool
+		ref       *v1beta1.TaskRef
+		want      bool
+	}{{
+		name: "VariableStore",
+		ref:  &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore"},
+		want: true,
+	}, {
+		name: "v1beta1 VariableStore",
+		ref:  &v1beta1.TaskRef{APIVersion: variablestorev1beta1.SchemeGroupVersion.String(), Kind: "VariableStore"},
+		want: true,
+	}, {
+		name: "CEL",
+		ref:  &v1beta1.TaskRef{APIVersion: celv1alpha1.SchemeGroupVersion.String(), Kind: "CEL"},
+	}, {
+		name:      "stateless CEL",
+		stateless: true,
+		ref:       &v1beta1.TaskRef{APIVersion: celv1alpha1.SchemeGroupVersion.String(), Kind: "CEL"},
+		want:      true,
+	}, {
+		name:      "stateless VariableStore",
+		stateless: true,
+		ref:       &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore"},
+	}, {
+		name:      "stateless CEL of another group",
+		stateless: true,
+		ref:       &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "CEL"},
+	}, {
+		name: "no reference",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Reconciler{stateless: tc.stateless}
+			if got := r.references(tc.ref); got != tc.want {
+				t.Errorf("references() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReconcileStateless(t *testing.T) {
+	// The Reconciler has no VariableStore client: reading or writing a VariableStore would panic
+	r := &Reconciler{stateless: true}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default", UID: "run-uid", Annotations: map[string]string{
+			// Propagated from the PipelineRun, they only apply to the Runs referencing a VariableStore
+			"custom.tekton.dev/acquire":      "deploy",
+			"custom.tekton.dev/transaction":  "true",
+			"custom.tekton.dev/wait-for":     "approved == 'yes'",
+			"custom.tekton.dev/wait-timeout": "soon",
+		}},
+		Spec: v1alpha1.RunSpec{
+			Ref: &v1beta1.TaskRef{APIVersion: celv1alpha1.SchemeGroupVersion.String(), Kind: "CEL", Name: "store"},
+			Params: []v1beta1.Param{
+				stringParam("a", "'x' + 'y'"),
+				stringParam("b", "a + 'z'"),
+			},
+		},
+	}
+	run.Status.InitializeConditions()
+	if err := r.reconcile(context.Background(), v1alpha1Run{run}); err != nil {
+		t.Fatalf("reconcile() = %v", err)
+	}
+
+	if !run.Status.GetCondition(apis.ConditionSucceeded).IsTrue() {
+		t.Fatalf("Run is not successful: %v", run.Status.GetCondition(apis.ConditionSucceeded))
+	}
+	want := []v1alpha1.RunResult{{Name: "a", Value: "xy"}, {Name: "b", Value: "xyz"}}
+	if diff := cmp.Diff(want, run.Status.Results); diff != "" {
+		t.Errorf("results (-want, +got): %s", diff)
+	}
+}
@@ -25,39 +25,103 @@ import (
 	"knative.dev/pkg/controller"
 	"knative.dev/pkg/logging"
 
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	runinformer "github.com/tektoncd/pipeline/pkg/client/injection/informers/pipeline/v1alpha1/run"
+	pipelineruninformer "github.com/tektoncd/pipeline/pkg/client/injection/informers/pipeline/v1beta1/pipelinerun"
+	taskruninformer "github.com/tektoncd/pipeline/pkg/client/injection/informers/pipeline/v1beta1/taskrun"
 	runreconciler "github.com/tektoncd/pipeline/pkg/client/injection/reconciler/pipeline/v1alpha1/run"
 	pipelinecontroller "github.com/tektoncd/pipeline/pkg/controller"
+	celtask "github.com/vincentpli/cel-tekton/pkg/apis/cel"
+	celv1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/cel/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
 	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	variablestorev1beta1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1beta1"
 	variablestoreclient "github.com/vincentpli/cel-tekton/pkg/client/injection/client"
+	variablestoreinformerfactory "github.com/vincentpli/cel-tekton/pkg/client/injection/informers/factory"
 	"k8s.io/client-go/tools/cache"
 )
 
-// NewController creates a Reconciler and returns the result of NewImpl.
+// NewController creates a Reconciler of the Runs referencing a VariableStore and returns the result of NewImpl.
 func NewController(
 	ctx context.Context,
 	cmw configmap.Watcher,
 ) *controller.Impl {
-	logger := logging.FromContext(ctx)
+	return newController(ctx, cmw, false)
+}
+
+// NewCELController creates a Reconciler of the Runs of the CEL custom task, which evaluates their CEL
+// expressions without any VariableStore, and returns the result of NewImpl.
+func NewCELController(
+	ctx context.Context,
+	cmw configmap.Watcher,
+) *controller.Impl {
+	return newController(ctx, cmw, true)
+}
 
-	variablestoreclientset := variablestoreclient.Get(ctx)
+func newController(ctx context.Context, cmw configmap.Watcher, stateless bool) *controller.Impl {
+	logger := logging.FromContext(ctx)
 
 	runInformer := runinformer.Get(ctx)
+	pipelineRunInformer := pipelineruninformer.Get(ctx)
+	taskRunInformer := taskruninformer.Get(ctx)
 
 	r := &Reconciler{
-		variablestoreClientSet: variablestoreclientset,
-		runLister:              runInformer.Lister(),
+		stateless:         stateless,
+		runLister:         runInformer.Lister(),
+		pipelineRunLister: pipelineRunInformer.Lister(),
+		taskRunLister:     taskRunInformer.Lister(),
+	}
+	filterFunc := pipelinecontroller.FilterRunRef(celv1alpha1.SchemeGroupVersion.String(), celtask.Kind)
+	agentName := "cel-controller"
+	if !stateless {
+		r.variablestoreClientSet = variablestoreclient.Get(ctx)
+		filterFunc = filterVariableStoreRef
+		agentName = "variablestore-controller"
 	}
 
-	impl := runreconciler.NewImpl(ctx, r)
+	impl := runreconciler.NewImpl(ctx, r, func(impl *controller.Impl) controller.Options {
+		configStore := config.NewStore(logger.Named("config-store"))
+		configStore.WatchConfigs(cmw)
+		return controller.Options{
+			AgentName:   agentName,
+			ConfigStore: configStore,
+		}
+	})
 	r.Tracker = tracker.New(impl.EnqueueKey, controller.GetTrackerLease(ctx))
+	r.enqueueAfter = impl.EnqueueKeyAfter
 
 	logger.Info("Setting up event handlers.")
 
 	runInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
-		FilterFunc: pipelinecontroller.FilterRunRef(variablestorev1alpha1.SchemeGroupVersion.String(), "VariableStore"),
+		FilterFunc: filterFunc,
 		Handler:    controller.HandleAll(impl.Enqueue),
 	})
 
+	// The Runs waiting for a condition over their VariableStore, or for one of its locks, track it, so that
+	// they are reconciled again whenever it changes. The informer is started here rather than by sharedmain,
+	// which would start it in the CEL controller as well, whose Runs never read a VariableStore.
+	if !stateless {
+		variablestoreInformerFactory := variablestoreinformerfactory.Get(ctx)
+		variablestoreInformerFactory.Custom().V1alpha1().VariableStores().Informer().AddEventHandler(controller.HandleAll(
+			controller.EnsureTypeMeta(r.Tracker.OnChanged, variablestorev1alpha1.SchemeGroupVersion.WithKind("VariableStore"))))
+		variablestoreInformerFactory.Start(ctx.Done())
+
+		// The Runs waiting for a lock track its holders as well, which release it when they finish
+		pipelineRunInformer.Informer().AddEventHandler(controller.HandleAll(
+			controller.EnsureTypeMeta(r.Tracker.OnChanged, v1beta1.SchemeGroupVersion.WithKind("PipelineRun"))))
+		runInformer.Informer().AddEventHandler(controller.HandleAll(
+			controller.EnsureTypeMeta(r.Tracker.OnChanged, v1alpha1.SchemeGroupVersion.WithKind("Run"))))
+	}
+
 	return impl
 }
+
+// filterVariableStoreRef filters the Runs referencing a VariableStore, through any of its served versions.
+var filterVariableStoreRef = func() func(interface{}) bool {
+	v1alpha1 := pipelinecontroller.FilterRunRef(variablestorev1alpha1.SchemeGroupVersion.String(), "VariableStore")
+	v1beta1 := pipelinecontroller.FilterRunRef(variablestorev1beta1.SchemeGroupVersion.String(), "VariableStore")
+	return func(obj interface{}) bool {
+		return v1alpha1(obj) || v1beta1(obj)
+	}
+}()
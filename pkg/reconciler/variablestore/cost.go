@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+)
+
+// costTracker counts the cost of the evaluation of the CEL expressions of a Run, the number of sub-expressions
+// evaluated, and interrupts the evaluation once a limit is exceeded.
+type costTracker struct {
+	limits config.CostLimits
+
+	// expressionCost is the cost of the CEL expression being evaluated
+	expressionCost int64
+	// runCost is the cost of all the CEL expressions of the Run evaluated so far
+	runCost int64
+	// exceeded describes the exceeded limit, if any
+	exceeded string
+}
+
+func newCostTracker(limits config.CostLimits) *costTracker {
+	return &costTracker{limits: limits}
+}
+
+// reset prepares the evaluation of the next CEL expression.
+func (ct *costTracker) reset() {
+	ct.expressionCost = 0
+}
+
+// add counts the evaluation of a sub-expression, and returns false once a limit is exceeded.
+func (ct *costTracker) add() bool {
+	if ct.exceeded != "" {
+		return false
+	}
+	ct.expressionCost++
+	ct.runCost++
+	switch {
+	case ct.limits.ExpressionCostLimit > 0 && ct.expressionCost > ct.limits.ExpressionCostLimit:
+		ct.exceeded = "expression cost limit"
+	case ct.limits.RunCostLimit > 0 && ct.runCost > ct.limits.RunCostLimit:
+		ct.exceeded = "run cost limit"
+	}
+	return ct.exceeded == ""
+}
+
+// decorator returns an InterpretableDecorator counting the cost of the evaluation. Once a limit is exceeded,
+// every sub-expression evaluates to an error so that the evaluation stops as soon as possible.
+func (ct *costTracker) decorator() interpreter.InterpretableDecorator {
+	return func(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+		switch inst := i.(type) {
+		case interpreter.InterpretableConst:
+			// Constants are free
+			return i, nil
+		case interpreter.InterpretableAttribute:
+			return &evalCostAttr{InterpretableAttribute: inst, tracker: ct}, nil
+		default:
+			return &evalCost{Interpretable: i, tracker: ct}, nil
+		}
+	}
+}
+
+// eval evaluates the Interpretable if the limits are not exceeded yet.
+func (ct *costTracker) eval(i interpreter.Interpretable, activation interpreter.Activation) ref.Val {
+	if !ct.add() {
+		return types.NewErr("evaluation interrupted: %s exceeded", ct.exceeded)
+	}
+	return i.Eval(activation)
+}
+
+type evalCost struct {
+	interpreter.Interpretable
+	tracker *costTracker
+}
+
+// Eval implements interpreter.Interpretable.
+func (e *evalCost) Eval(activation interpreter.Activation) ref.Val {
+	return e.tracker.eval(e.Interpretable, activation)
+}
+
+type evalCostAttr struct {
+	interpreter.InterpretableAttribute
+	tracker *costTracker
+}
+
+// Eval implements interpreter.Interpretable.
+func (e *evalCostAttr) Eval(activation interpreter.Activation) ref.Val {
+	return e.tracker.eval(e.InterpretableAttribute, activation)
+}
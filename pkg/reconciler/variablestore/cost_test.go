@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+)
+
+func TestCostTracker(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		limits       config.CostLimits
+		expressions  []string
+		wantExceeded string
+	}{{
+		name:        "within limits",
+		limits:      config.CostLimits{ExpressionCostLimit: 1000, RunCostLimit: 2000},
+		expressions: []string{"items.map(i, i * 2).size() == 3", "items.exists(i, i > 2)"},
+	}, {
+		name:         "expression cost limit",
+		limits:       config.CostLimits{ExpressionCostLimit: 10, RunCostLimit: 2000},
+		expressions:  []string{"items.map(i, i * 2).size() == 3"},
+		wantExceeded: "expression cost limit",
+	}, {
+		name:         "run cost limit",
+		limits:       config.CostLimits{ExpressionCostLimit: 1000, RunCostLimit: 40},
+		expressions:  []string{"items.map(i, i * 2).size() == 3", "items.map(i, i * 2).size() == 3"},
+		wantExceeded: "run cost limit",
+	}, {
+		name:         "short-circuit does not hide the interruption",
+		limits:       config.CostLimits{ExpressionCostLimit: 10},
+		expressions:  []string{"items.map(i, i * 2).size() == 3 || true"},
+		wantExceeded: "expression cost limit",
+	}, {
+		name:        "disabled",
+		limits:      config.CostLimits{},
+		expressions: []string{"items.map(i, items.map(j, i * j)).size() == 3"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := cel.NewEnv(cel.Declarations(decls.NewVar("items", decls.NewListType(decls.Int))))
+			if err != nil {
+				t.Fatalf("cel.NewEnv() = %v", err)
+			}
+			costs := newCostTracker(tc.limits)
+			for _, expression := range tc.expressions {
+				ast, iss := env.Compile(expression)
+				if iss.Err() != nil {
+					t.Fatalf("Compile(%q) = %v", expression, iss.Err())
+				}
+				prg, err := env.Program(ast, cel.CustomDecorator(costs.decorator()))
+				if err != nil {
+					t.Fatalf("Program(%q) = %v", expression, err)
+				}
+				costs.reset()
+				if _, _, err := prg.Eval(map[string]interface{}{"items": []int64{1, 2, 3}}); err != nil && costs.exceeded == "" {
+					t.Fatalf("Eval(%q) = %v", expression, err)
+				}
+			}
+			if costs.exceeded != tc.wantExceeded {
+				t.Errorf("exceeded = %q, want %q", costs.exceeded, tc.wantExceeded)
+			}
+		})
+	}
+}
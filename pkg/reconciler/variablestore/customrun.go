@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// customRun is a run of a custom task referencing a VariableStore: the v1alpha1 Run of the Tekton release
+// we depend on, or the v1beta1 CustomRun which replaces it in newer releases. The expressions are evaluated
+// by reading and updating runs only through it, so that a reconciler per kind can share the evaluation, and
+// both kinds can be reconciled side by side while Tekton is upgraded.
+//
+// TODO: Only v1alpha1Run implements it so far. Tekton v0.22 has no CustomRun, so supporting CustomRuns still
+// needs the Tekton dependency bumped to a release serving them, then a CustomRun adapter, a reconciler, an
+// informer filtering the CustomRuns referencing a VariableStore and the RBAC of customruns, all sharing
+// reconcile.
+type customRun interface {
+	metav1.Object
+
+	// GetRef returns the reference of the run to its VariableStore.
+	GetRef() *v1beta1.TaskRef
+	// GetParams returns the CEL expressions to evaluate, in order.
+	GetParams() []v1beta1.Param
+	// GetServiceAccountName returns the service account the run runs as.
+	GetServiceAccountName() string
+	// GetStartTime returns when the run started.
+	GetStartTime() *metav1.Time
+	// IsCancelled returns whether the run was cancelled.
+	IsCancelled() bool
+
+	// AddResult adds the result of a CEL expression to the status of the run, replacing the result of the
+	// same name if any, so that a run reconciled again doesn't report it twice.
+	AddResult(name, value string)
+	// EncodeExtraFields sets the extra fields of the status of the run, e.g. its traces.
+	EncodeExtraFields(from interface{}) error
+	// MarkRunning marks the run as running.
+	MarkRunning(reason, messageFormat string, messageA ...interface{})
+	// MarkSucceeded marks the run as succeeded.
+	MarkSucceeded(reason, messageFormat string, messageA ...interface{})
+	// MarkFailed marks the run as failed.
+	MarkFailed(reason, messageFormat string, messageA ...interface{})
+}
+
+// v1alpha1Run is a v1alpha1 Run as a customRun.
+type v1alpha1Run struct {
+	*v1alpha1.Run
+}
+
+var _ customRun = v1alpha1Run{}
+
+func (r v1alpha1Run) GetRef() *v1beta1.TaskRef {
+	return r.Spec.Ref
+}
+
+func (r v1alpha1Run) GetParams() []v1beta1.Param {
+	return r.Spec.Params
+}
+
+func (r v1alpha1Run) GetServiceAccountName() string {
+	return r.Spec.ServiceAccountName
+}
+
+func (r v1alpha1Run) GetStartTime() *metav1.Time {
+	return r.Status.StartTime
+}
+
+func (r v1alpha1Run) AddResult(name, value string) {
+	for i := range r.Status.Results {
+		if r.Status.Results[i].Name == name {
+			r.Status.Results[i].Value = value
+			return
+		}
+	}
+	r.Status.Results = append(r.Status.Results, v1alpha1.RunResult{Name: name, Value: value})
+}
+
+func (r v1alpha1Run) EncodeExtraFields(from interface{}) error {
+	return r.Status.EncodeExtraFields(from)
+}
+
+func (r v1alpha1Run) MarkRunning(reason, messageFormat string, messageA ...interface{}) {
+	r.Status.MarkRunRunning(reason, messageFormat, messageA...)
+}
+
+func (r v1alpha1Run) MarkSucceeded(reason, messageFormat string, messageA ...interface{}) {
+	r.Status.MarkRunSucceeded(reason, messageFormat, messageA...)
+}
+
+func (r v1alpha1Run) MarkFailed(reason, messageFormat string, messageA ...interface{}) {
+	r.Status.MarkRunFailed(reason, messageFormat, messageA...)
+}
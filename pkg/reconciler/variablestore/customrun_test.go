@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+func TestV1alpha1Run(t *testing.T) {
+	ref := &v1beta1.TaskRef{APIVersion: "custom.tekton.dev/v1alpha1", Kind: "VariableStore", Name: "store"}
+	params := []v1beta1.Param{{Name: "a", Value: *v1beta1.NewArrayOrString("1 + 1")}}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default"},
+		Spec:       v1alpha1.RunSpec{Ref: ref, Params: params, ServiceAccountName: "sa"},
+	}
+	var r customRun = v1alpha1Run{run}
+
+	if r.GetRef() != ref {
+		t.Errorf("GetRef() = %v, want %v", r.GetRef(), ref)
+	}
+	if d := cmp.Diff(params, r.GetParams()); d != "" {
+		t.Errorf("GetParams() (-want, +got): %s", d)
+	}
+	if got := r.GetServiceAccountName(); got != "sa" {
+		t.Errorf("GetServiceAccountName() = %s, want sa", got)
+	}
+
+	if r.IsCancelled() {
+		t.Error("IsCancelled() = true, want false")
+	}
+	run.Spec.Status = v1alpha1.RunSpecStatusCancelled
+	if !r.IsCancelled() {
+		t.Error("IsCancelled() = false, want true")
+	}
+
+	run.Status.InitializeConditions()
+	if r.GetStartTime() != run.Status.StartTime || r.GetStartTime() == nil {
+		t.Errorf("GetStartTime() = %v, want %v", r.GetStartTime(), run.Status.StartTime)
+	}
+	r.MarkRunning("Waiting", "Waiting for %s", "approved == 'yes'")
+	if condition := run.Status.GetCondition(apis.ConditionSucceeded); condition == nil || !condition.IsUnknown() ||
+		condition.Reason != "Waiting" || condition.Message != "Waiting for approved == 'yes'" {
+		t.Errorf("MarkRunning() set the condition %v", condition)
+	}
+
+	r.AddResult("a", "2")
+	r.AddResult("b", "3")
+	if d := cmp.Diff([]v1alpha1.RunResult{{Name: "a", Value: "2"}, {Name: "b", Value: "3"}}, run.Status.Results); d != "" {
+		t.Errorf("AddResult() (-want, +got): %s", d)
+	}
+
+	r.MarkFailed("SyntaxError", "CEL expression %s could not be parsed", "a")
+	condition := run.Status.GetCondition(apis.ConditionSucceeded)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != "SyntaxError" ||
+		condition.Message != "CEL expression a could not be parsed" {
+		t.Errorf("MarkFailed() set the condition %v", condition)
+	}
+
+	r.MarkSucceeded("EvaluationSuccess", "CEL expressions were evaluated successfully")
+	if condition := run.Status.GetCondition(apis.ConditionSucceeded); condition == nil || !condition.IsTrue() {
+		t.Errorf("MarkSucceeded() set the condition %v", condition)
+	}
+}
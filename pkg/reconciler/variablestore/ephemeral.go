@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/kmeta"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+)
+
+// wantsEphemeral returns whether the Run opts in to using the ephemeral VariableStore of its PipelineRun.
+func wantsEphemeral(run metav1.Object) bool {
+	return run.GetAnnotations()[variablestores.EphemeralAnnotationKey] == "true"
+}
+
+// ephemeralStoreName returns the name of the ephemeral VariableStore of the PipelineRun seeded from the
+// template: the name of the PipelineRun, suffixed with the name of the template when there is one, so that
+// the Runs referencing different templates don't share their variables.
+func ephemeralStoreName(pipelineRun, template string) string {
+	if template == "" {
+		return pipelineRun
+	}
+	return kmeta.ChildName(pipelineRun, "-"+template)
+}
+
+// getEphemeral returns the name of the PipelineRun whose ephemeral VariableStore the Run uses, and whether it
+// uses one. The annotation is propagated from the PipelineRun to all its Runs, so the Runs which aren't Runs
+// of VariableStores, e.g. the Runs of the CEL custom task, ignore it.
+func getEphemeral(run customRun) (string, bool) {
+	if !wantsEphemeral(run) || !isStoreRun(run) {
+		return "", false
+	}
+	return pipelineRunOwnerName(run)
+}
+
+// storeName returns the name of the VariableStore the Run reads and writes, and whether it has one: the
+// ephemeral VariableStore of its PipelineRun, or else the VariableStore it references.
+func storeName(run customRun) (string, bool) {
+	if !isStoreRun(run) {
+		return "", false
+	}
+	ref := run.GetRef()
+	if pipelineRun, ok := getEphemeral(run); ok {
+		return ephemeralStoreName(pipelineRun, ref.Name), true
+	}
+	return ref.Name, ref.Name != ""
+}
+
+func validateEphemeral(run customRun) (errs *apis.FieldError) {
+	if !wantsEphemeral(run) || !isStoreRun(run) {
+		return nil
+	}
+	if _, ok := pipelineRunOwnerName(run); !ok {
+		errs = errs.Also(apis.ErrInvalidValue("only the Runs of a PipelineRun can use its ephemeral VariableStore",
+			variablestores.EphemeralAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+// isEphemeralStoreOf returns whether the VariableStore is the ephemeral VariableStore of the PipelineRun: it
+// is labelled with its name and owned by it, rather than a VariableStore of a user, or the ephemeral
+// VariableStore of another PipelineRun, which happens to have the same name.
+func isEphemeralStoreOf(vs *variablestorev1alpha1.VariableStore, pipelineRun metav1.OwnerReference) bool {
+	if vs.Labels[variablestores.PipelineRunLabelKey] != pipelineRun.Name {
+		return false
+	}
+	for _, ref := range vs.OwnerReferences {
+		if ref.UID == pipelineRun.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// getEphemeralStore returns the ephemeral VariableStore of the PipelineRun of the Run, which is created when
+// it doesn't exist yet. It is seeded with the variables and the name policy of the VariableStore the Run
+// references, if any, and owned by the PipelineRun so that it is deleted along with it. A VariableStore of
+// the same name which isn't the ephemeral VariableStore of the PipelineRun is never used instead.
+func (r *Reconciler) getEphemeralStore(ctx context.Context, run customRun, pipelineRunName string) (*variablestorev1alpha1.VariableStore, error) {
+	logger := logging.FromContext(ctx)
+	stores := r.variablestoreClientSet.CustomV1alpha1().VariableStores(run.GetNamespace())
+	template := run.GetRef().Name
+	name := ephemeralStoreName(pipelineRunName, template)
+	owner, _ := pipelineRunOwner(run)
+
+	vs, err := stores.Get(ctx, name, metav1.GetOptions{})
+	if err == nil && !isEphemeralStoreOf(vs, owner) {
+		return nil, fmt.Errorf("the VariableStore %s isn't the ephemeral VariableStore of PipelineRun %s", name, pipelineRunName)
+	}
+	if !errors.IsNotFound(err) {
+		return vs, err
+	}
+
+	// The owner reference is built from the one of the Run rather than looked up, since the PipelineRun which
+	// just created the Run may not be in the lister cache yet
+	ephemeral := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: run.GetNamespace(),
+			Labels:    map[string]string{variablestores.PipelineRunLabelKey: pipelineRunName},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion:         v1beta1.SchemeGroupVersion.String(),
+				Kind:               pipeline.PipelineRunControllerName,
+				Name:               owner.Name,
+				UID:                owner.UID,
+				Controller:         ptr.Bool(true),
+				BlockOwnerDeletion: ptr.Bool(true),
+			}},
+		},
+	}
+	if template != "" {
+		seed, err := stores.Get(ctx, template, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ephemeral.Spec.NamePolicy = seed.Spec.NamePolicy
+		ephemeral.Spec.Vars = append([]variablestorev1alpha1.Var{}, seed.Spec.Vars...)
+	}
+
+	vs, err = stores.Create(ctx, ephemeral, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// Another Run of the PipelineRun created it first, or else someone created a VariableStore of that name
+		vs, err = stores.Get(ctx, name, metav1.GetOptions{})
+		if err == nil && !isEphemeralStoreOf(vs, owner) {
+			return nil, fmt.Errorf("the VariableStore %s isn't the ephemeral VariableStore of PipelineRun %s", name, pipelineRunName)
+		}
+		return vs, err
+	}
+	if err == nil {
+		logger.Infof("Created the ephemeral VariableStore %s of PipelineRun %s/%s", name, run.GetNamespace(), pipelineRunName)
+	}
+	return vs, err
+}
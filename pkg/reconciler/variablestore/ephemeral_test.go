@@ -0,0 +1,265 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+func TestReconcileEphemeral(t *testing.T) {
+	ctx := context.Background()
+	template := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "default"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{
+			NamePolicy: variablestorev1alpha1.NamePolicyAlias,
+			Vars:       []variablestorev1alpha1.Var{{Name: "attempts", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+		},
+	}
+	client := fakevariableclientset.NewSimpleClientset(template)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	pipelineRun := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-1", Namespace: "default", UID: "pr-1-uid"}}
+	if err := indexer.Add(pipelineRun); err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+	r := &Reconciler{variablestoreClientSet: client, pipelineRunLister: listers.NewPipelineRunLister(indexer)}
+
+	reconcile := func(name, store, owner string) *v1alpha1.Run {
+		t.Helper()
+		run := &v1alpha1.Run{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				UID:             ktypes.UID(name),
+				Annotations:     map[string]string{"custom.tekton.dev/ephemeral": "true", "custom.tekton.dev/operations": "attempts=Add"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: owner, UID: ktypes.UID(owner + "-uid")}},
+			},
+			Spec: v1alpha1.RunSpec{
+				Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: store},
+				Params: []v1beta1.Param{stringParam("attempts", "1")},
+			},
+		}
+		if owner == "" {
+			run.OwnerReferences = nil
+		}
+		run.Status.InitializeConditions()
+		if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+			t.Fatalf("reconcile(%s) = %v", name, err)
+		}
+		return run
+	}
+
+	// The first Run creates the ephemeral VariableStore from the template, the next ones reuse it
+	for _, tc := range []struct {
+		run, store, want string
+	}{
+		{run: "attempt-1", store: "defaults", want: "2"},
+		{run: "attempt-2", store: "defaults", want: "3"},
+		{run: "attempt-3", want: "1"},
+	} {
+		run := reconcile(tc.run, tc.store, "pr-1")
+		if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+			t.Fatalf("%s set the condition %v, want success", tc.run, c)
+		}
+		if got := runResult(run, "attempts"); got != tc.want {
+			t.Errorf("%s wrote attempts = %s, want %s", tc.run, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		want variablestorev1alpha1.VariableStoreSpec
+	}{{
+		name: "pr-1-defaults",
+		want: variablestorev1alpha1.VariableStoreSpec{
+			NamePolicy: variablestorev1alpha1.NamePolicyAlias,
+			Vars:       []variablestorev1alpha1.Var{{Name: "attempts", Value: "3", Type: variablestorev1alpha1.VarTypeInt}},
+		},
+	}, {
+		name: "pr-1",
+		want: variablestorev1alpha1.VariableStoreSpec{
+			Vars: []variablestorev1alpha1.Var{{Name: "attempts", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+		},
+	}} {
+		got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, tc.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(%s) = %v", tc.name, err)
+		}
+		if d := cmp.Diff([]metav1.OwnerReference{pipelineRun.GetOwnerReference()}, got.OwnerReferences); d != "" {
+			t.Errorf("%s owner references (-want, +got): %s", tc.name, d)
+		}
+		if got.Labels["custom.tekton.dev/pipelineRun"] != "pr-1" {
+			t.Errorf("%s labels = %v, want the PipelineRun", tc.name, got.Labels)
+		}
+		got.Spec.Applied = nil
+		if d := cmp.Diff(tc.want, got.Spec); d != "" {
+			t.Errorf("%s (-want, +got): %s", tc.name, d)
+		}
+	}
+
+	// The template is never written
+	got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "defaults", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if d := cmp.Diff(template.Spec, got.Spec); d != "" {
+		t.Errorf("template (-want, +got): %s", d)
+	}
+
+	// Only the Runs of a PipelineRun can use its ephemeral VariableStore
+	run := reconcile("attempt-4", "defaults", "")
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsFalse() || c.Reason != variablestorev1alpha1.ReasonFailedValidation.String() {
+		t.Errorf("attempt-4 set the condition %v, want it invalid", c)
+	}
+}
+
+func TestReconcileEphemeralPipelineRunNotCached(t *testing.T) {
+	// The PipelineRun which just created the Run isn't in the lister cache yet: the ephemeral VariableStore is
+	// created anyway, and the Run is requeued rather than failed
+	ctx := context.Background()
+	client := fakevariableclientset.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	r := &Reconciler{variablestoreClientSet: client, pipelineRunLister: listers.NewPipelineRunLister(indexer)}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "attempt",
+			Namespace:       "default",
+			UID:             "attempt",
+			Annotations:     map[string]string{"custom.tekton.dev/ephemeral": "true"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: "pr-1", UID: "pr-1-uid"}},
+		},
+		Spec: v1alpha1.RunSpec{
+			Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore"},
+			Params: []v1beta1.Param{stringParam("attempts", "1")},
+		},
+	}
+	run.Status.InitializeConditions()
+	if err := r.reconcile(ctx, v1alpha1Run{run}); !errors.IsNotFound(err) {
+		t.Fatalf("reconcile() = %v, want the PipelineRun not found", err)
+	}
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsUnknown() {
+		t.Errorf("reconcile() set the condition %v, want it requeued", c)
+	}
+	got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "pr-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	pipelineRun := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-1", Namespace: "default", UID: "pr-1-uid"}}
+	if d := cmp.Diff([]metav1.OwnerReference{pipelineRun.GetOwnerReference()}, got.OwnerReferences); d != "" {
+		t.Errorf("owner references (-want, +got): %s", d)
+	}
+
+	// Once the PipelineRun is cached, the Run uses the ephemeral VariableStore created before
+	if err := indexer.Add(pipelineRun); err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+	if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+		t.Fatalf("reconcile() = %v", err)
+	}
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Errorf("reconcile() set the condition %v, want success", c)
+	}
+}
+
+func TestReconcileEphemeralNotOwned(t *testing.T) {
+	ctx := context.Background()
+	ephemeral := func(name, pipelineRun string, uid ktypes.UID) *variablestorev1alpha1.VariableStore {
+		return &variablestorev1alpha1.VariableStore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				Labels:          map[string]string{"custom.tekton.dev/pipelineRun": pipelineRun},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: pipelineRun, UID: uid}},
+			},
+			Spec: variablestorev1alpha1.VariableStoreSpec{Vars: []variablestorev1alpha1.Var{}},
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		existing    *variablestorev1alpha1.VariableStore
+		pipelineRun string
+		template    string
+	}{{
+		name:        "VariableStore of a user",
+		existing:    &variablestorev1alpha1.VariableStore{ObjectMeta: metav1.ObjectMeta{Name: "pr", Namespace: "default"}},
+		pipelineRun: "pr",
+	}, {
+		name:        "ephemeral VariableStore of a deleted PipelineRun of the same name",
+		existing:    ephemeral("pr", "pr", "old-uid"),
+		pipelineRun: "pr",
+	}, {
+		name:        "ephemeral VariableStore of another PipelineRun with a colliding name",
+		existing:    ephemeral("a-b-c", "a-b", "a-b-uid"),
+		pipelineRun: "a",
+		template:    "b-c",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			template := &variablestorev1alpha1.VariableStore{
+				ObjectMeta: metav1.ObjectMeta{Name: "b-c", Namespace: "default"},
+				Spec:       variablestorev1alpha1.VariableStoreSpec{Vars: []variablestorev1alpha1.Var{}},
+			}
+			client := fakevariableclientset.NewSimpleClientset(tc.existing, template)
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := indexer.Add(&v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: tc.pipelineRun, Namespace: "default", UID: "uid"}}); err != nil {
+				t.Fatalf("Add() = %v", err)
+			}
+			r := &Reconciler{variablestoreClientSet: client, pipelineRunLister: listers.NewPipelineRunLister(indexer)}
+			run := &v1alpha1.Run{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "run",
+					Namespace:       "default",
+					UID:             "run-uid",
+					Annotations:     map[string]string{"custom.tekton.dev/ephemeral": "true"},
+					OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: tc.pipelineRun, UID: "uid"}},
+				},
+				Spec: v1alpha1.RunSpec{
+					Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: tc.template},
+					Params: []v1beta1.Param{stringParam("attempts", "1")},
+				},
+			}
+			run.Status.InitializeConditions()
+			if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+				t.Fatalf("reconcile() = %v", err)
+			}
+			if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsFalse() || c.Reason != variablestorev1alpha1.VariableStoreReasonCouldntGet.String() {
+				t.Errorf("reconcile() set the condition %v, want it failed", c)
+			}
+
+			// The existing VariableStore is left alone
+			got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, tc.existing.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Get() = %v", err)
+			}
+			if d := cmp.Diff(tc.existing, got); d != "" {
+				t.Errorf("VariableStore (-want, +got): %s", d)
+			}
+		})
+	}
+}
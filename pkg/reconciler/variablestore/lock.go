@@ -0,0 +1,311 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/tracker"
+)
+
+// getAcquiredLock returns the name of the lock the Run acquires, and whether it acquires one.
+func getAcquiredLock(run metav1.Object) (string, bool) {
+	name, ok := run.GetAnnotations()[variablestores.AcquireAnnotationKey]
+	return name, ok
+}
+
+// getReleasedLock returns the name of the lock the Run releases, and whether it releases one.
+func getReleasedLock(run metav1.Object) (string, bool) {
+	name, ok := run.GetAnnotations()[variablestores.ReleaseAnnotationKey]
+	return name, ok
+}
+
+// getLockCapacity returns how many holders can hold the lock the Run acquires at the same time.
+func getLockCapacity(run metav1.Object) (int64, error) {
+	capacity, ok := run.GetAnnotations()[variablestores.CapacityAnnotationKey]
+	if !ok {
+		return 1, nil
+	}
+	return strconv.ParseInt(capacity, 10, 64)
+}
+
+// validateLocks validates the annotations of the Run acquiring or releasing a lock. They are propagated from
+// the PipelineRun to all its Runs, so the Runs which aren't Runs of VariableStores, e.g. the Runs of the CEL
+// custom task, ignore them.
+func validateLocks(run customRun) (errs *apis.FieldError) {
+	if !isStoreRun(run) {
+		return nil
+	}
+	acquired, acquires := getAcquiredLock(run)
+	if acquires && acquired == "" {
+		errs = errs.Also(apis.ErrInvalidValue("the name of the lock to acquire must not be empty",
+			variablestores.AcquireAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	if capacity, err := getLockCapacity(run); err != nil || capacity < 1 {
+		errs = errs.Also(apis.ErrInvalidValue(run.GetAnnotations()[variablestores.CapacityAnnotationKey],
+			variablestores.CapacityAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	released, releases := getReleasedLock(run)
+	if releases && released == "" {
+		errs = errs.Also(apis.ErrInvalidValue("the name of the lock to release must not be empty",
+			variablestores.ReleaseAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	if acquires && !referencesStore(run) {
+		errs = errs.Also(apis.ErrInvalidValue("the Run doesn't reference a VariableStore whose lock it could acquire",
+			variablestores.AcquireAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	if releases && !referencesStore(run) {
+		errs = errs.Also(apis.ErrInvalidValue("the Run doesn't reference a VariableStore whose lock it could release",
+			variablestores.ReleaseAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+// lockHolder returns the holder the Run acquires and releases locks as.
+func lockHolder(run metav1.Object) variablestorev1alpha1.LockHolder {
+	holder := variablestorev1alpha1.LockHolder{Run: run.GetName(), UID: run.GetUID()}
+	if owner, ok := pipelineRunOwner(run); ok {
+		holder.PipelineRun = owner.Name
+		holder.UID = owner.UID
+	}
+	return holder
+}
+
+// sameHolder returns whether both holders hold locks on behalf of the same PipelineRun, or are the same Run
+// when they are not owned by a PipelineRun. The holders are compared by UID when both have one, so that a
+// PipelineRun or a Run recreated with the name of a holder isn't the same holder.
+func sameHolder(a, b variablestorev1alpha1.LockHolder) bool {
+	if a.UID != "" && b.UID != "" {
+		return a.UID == b.UID
+	}
+	if a.PipelineRun != "" || b.PipelineRun != "" {
+		return a.PipelineRun == b.PipelineRun
+	}
+	return a.Run == b.Run
+}
+
+// holderReference returns the reference to the resource whose lifetime bounds the holding of a lock, which
+// the Tracker tracks for the Runs waiting for the lock.
+func holderReference(namespace string, holder variablestorev1alpha1.LockHolder) tracker.Reference {
+	if holder.PipelineRun != "" {
+		return tracker.Reference{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "PipelineRun",
+			Namespace:  namespace,
+			Name:       holder.PipelineRun,
+		}
+	}
+	return tracker.Reference{
+		APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		Kind:       "Run",
+		Namespace:  namespace,
+		Name:       holder.Run,
+	}
+}
+
+// isReleased returns whether the holder released the lock implicitly: its PipelineRun finished or was
+// deleted, or, when it is not owned by a PipelineRun, its Run was deleted. A PipelineRun or a Run recreated
+// with the same name doesn't hold the lock anymore.
+func (r *Reconciler) isReleased(namespace string, holder variablestorev1alpha1.LockHolder) (bool, error) {
+	if holder.PipelineRun != "" {
+		pipelineRun, err := r.pipelineRunLister.PipelineRuns(namespace).Get(holder.PipelineRun)
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return pipelineRun.IsDone() || holder.UID != "" && pipelineRun.UID != holder.UID, nil
+	}
+	run, err := r.runLister.Runs(namespace).Get(holder.Run)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return holder.UID != "" && run.UID != holder.UID, nil
+}
+
+// findLock returns the index of the lock called name in the VariableStore, -1 if it has none.
+func findLock(store *variablestorev1alpha1.VariableStore, name string) int {
+	for i, lock := range store.Spec.Locks {
+		if lock.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// lock releases, then acquires, the locks of its VariableStore the Run names, and returns the VariableStore
+// as updated, and whether the Run holds the lock it acquires. Until it does, the Run is marked Running,
+// tracks the VariableStore and the holders of the lock so that it is reconciled again whenever they change,
+// and is re-queued for when it times out. It returns false as well when the Run timed out or was cancelled.
+//
+// The VariableStore is updated right away, so that a conflict with another Run updating it at the same time
+// fails the update, and the reconcile is retried with the current holders.
+func (r *Reconciler) lock(ctx context.Context, run customRun, store *variablestorev1alpha1.VariableStore) (*variablestorev1alpha1.VariableStore, bool, error) {
+	logger := logging.FromContext(ctx)
+	holder := lockHolder(run)
+	store = store.DeepCopy()
+	changed := false
+
+	if name, ok := getReleasedLock(run); ok {
+		if i := findLock(store, name); i >= 0 {
+			holders := store.Spec.Locks[i].Holders[:0]
+			for _, h := range store.Spec.Locks[i].Holders {
+				if !sameHolder(h, holder) {
+					holders = append(holders, h)
+				}
+			}
+			if len(holders) != len(store.Spec.Locks[i].Holders) {
+				logger.Infof("Run %s/%s released the lock %s of VariableStore %s", run.GetNamespace(), run.GetName(), name, store.Name)
+				store.Spec.Locks[i].Holders = holders
+				changed = true
+			}
+		}
+	}
+
+	name, acquires := getAcquiredLock(run)
+	acquired := !acquires
+	// mismatch is the capacity of the lock held with another capacity than the one the Run acquires it with
+	var mismatch int64
+	if acquires {
+		capacity, err := getLockCapacity(run)
+		if err != nil {
+			return nil, false, err
+		}
+		i := findLock(store, name)
+		if i < 0 {
+			store.Spec.Locks = append(store.Spec.Locks, variablestorev1alpha1.Lock{Name: name, Capacity: capacity})
+			i = len(store.Spec.Locks) - 1
+			changed = true
+		}
+		lock := &store.Spec.Locks[i]
+
+		// The holders whose PipelineRun finished, or whose Run was deleted, don't hold the lock anymore
+		var holders []variablestorev1alpha1.LockHolder
+		for _, h := range lock.Holders {
+			released, err := r.isReleased(run.GetNamespace(), h)
+			if err != nil {
+				return nil, false, err
+			}
+			if released {
+				changed = true
+				continue
+			}
+			if sameHolder(h, holder) {
+				acquired = true
+			}
+			holders = append(holders, h)
+		}
+		lock.Holders = holders
+
+		// The capacity of a lock is the one of the Run which created it, and only changes while nobody holds
+		// it, so that a Run can't let in more holders than the holders acquired it for
+		if !acquired && lock.Capacity != capacity {
+			if len(lock.Holders) == 0 {
+				lock.Capacity = capacity
+				changed = true
+			} else {
+				mismatch = lock.Capacity
+			}
+		}
+
+		if !acquired && mismatch == 0 && int64(len(lock.Holders)) < lock.Capacity {
+			logger.Infof("Run %s/%s acquired the lock %s of VariableStore %s", run.GetNamespace(), run.GetName(), name, store.Name)
+			lock.Holders = append(lock.Holders, holder)
+			acquired = true
+			changed = true
+		}
+	}
+
+	if changed {
+		updated, err := r.variablestoreClientSet.CustomV1alpha1().VariableStores(store.Namespace).Update(ctx, store, metav1.UpdateOptions{})
+		if err != nil {
+			logger.Infof("Couldn't update the locks of VariableStore %s when reconciling Run %s/%s, retrying: %v", store.Name, run.GetNamespace(), run.GetName(), err)
+			return nil, false, err
+		}
+		store = updated
+	}
+	if acquired {
+		return store, true, nil
+	}
+	if mismatch != 0 {
+		capacity, _ := getLockCapacity(run)
+		logger.Infof("Run %s/%s acquires the lock %s of VariableStore %s with the capacity %d, it is held with %d", run.GetNamespace(), run.GetName(), name, store.Name, capacity, mismatch)
+		run.MarkFailed(variablestorev1alpha1.ReasonFailedValidation.String(),
+			"The lock %s is held with the capacity %d, not %d", name, mismatch, capacity)
+		return store, false, nil
+	}
+
+	// The lock is full, wait for one of its holders to release it
+	if run.IsCancelled() {
+		logger.Infof("Run %s/%s was cancelled while waiting for the lock %s", run.GetNamespace(), run.GetName(), name)
+		run.MarkFailed(variablestorev1alpha1.ReasonCancelled.String(),
+			"Run was cancelled while waiting for the lock %s", name)
+		return store, false, nil
+	}
+	timeout, err := getWaitTimeout(run)
+	if err != nil {
+		return nil, false, err
+	}
+	elapsed := time.Since(run.GetStartTime().Time)
+	if elapsed >= timeout {
+		logger.Infof("Run %s/%s timed out after %s waiting for the lock %s", run.GetNamespace(), run.GetName(), timeout, name)
+		run.MarkFailed(variablestorev1alpha1.ReasonWaitTimeout.String(),
+			"Run timed out after %s waiting for the lock %s", timeout, name)
+		return store, false, nil
+	}
+
+	lock := store.Spec.Locks[findLock(store, name)]
+	if err := r.Tracker.TrackReference(storeReference(run), run); err != nil {
+		return nil, false, err
+	}
+	holders := make([]string, 0, len(lock.Holders))
+	for _, h := range lock.Holders {
+		if err := r.Tracker.TrackReference(holderReference(run.GetNamespace(), h), run); err != nil {
+			return nil, false, err
+		}
+		holders = append(holders, formatHolder(h))
+	}
+	r.enqueueAfter(ktypes.NamespacedName{Namespace: run.GetNamespace(), Name: run.GetName()}, timeout-elapsed)
+	run.MarkRunning(variablestorev1alpha1.ReasonWaitingForLock.String(),
+		"Waiting for the lock %s, held by %s", name, strings.Join(holders, ", "))
+	return store, false, nil
+}
+
+// formatHolder returns the holder as reported to the Runs waiting for the lock.
+func formatHolder(holder variablestorev1alpha1.LockHolder) string {
+	if holder.PipelineRun != "" {
+		return fmt.Sprintf("PipelineRun %s", holder.PipelineRun)
+	}
+	return fmt.Sprintf("Run %s", holder.Run)
+}
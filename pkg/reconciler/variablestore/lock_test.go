@@ -0,0 +1,273 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	listersalpha "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1alpha1"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/tracker"
+)
+
+// lockFixture reconciles Runs acquiring and releasing the locks of a VariableStore on behalf of PipelineRuns.
+type lockFixture struct {
+	t            *testing.T
+	r            *Reconciler
+	client       *fakevariableclientset.Clientset
+	pipelineRuns cache.Indexer
+}
+
+func newLockFixture(t *testing.T, pipelineRuns ...string) *lockFixture {
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec:       variablestorev1alpha1.VariableStoreSpec{Vars: []variablestorev1alpha1.Var{}},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, name := range pipelineRuns {
+		if err := indexer.Add(&v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: ktypes.UID(name)}}); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	return &lockFixture{
+		t:      t,
+		client: client,
+		r: &Reconciler{
+			variablestoreClientSet: client,
+			pipelineRunLister:      listers.NewPipelineRunLister(indexer),
+			runLister:              listersalpha.NewRunLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+			Tracker:                tracker.New(func(ktypes.NamespacedName) {}, time.Hour),
+			enqueueAfter:           func(ktypes.NamespacedName, time.Duration) {},
+		},
+		pipelineRuns: indexer,
+	}
+}
+
+// newRun returns the Run called name, owned by the PipelineRun, referencing the VariableStore.
+func (f *lockFixture) newRun(name, pipelineRun string, annotations map[string]string) *v1alpha1.Run {
+	f.t.Helper()
+	owner := metav1.OwnerReference{Kind: "PipelineRun", Name: pipelineRun}
+	if obj, ok, err := f.pipelineRuns.GetByKey("default/" + pipelineRun); err != nil {
+		f.t.Fatalf("GetByKey() = %v", err)
+	} else if ok {
+		owner.UID = obj.(*v1beta1.PipelineRun).UID
+	}
+	return &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			UID:             ktypes.UID(pipelineRun + "/" + name),
+			Annotations:     annotations,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: v1alpha1.RunSpec{
+			Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+			Params: []v1beta1.Param{stringParam("deploy", "'now'")},
+		},
+	}
+}
+
+// reconcile reconciles the Run called name, owned by the PipelineRun, and returns its condition.
+func (f *lockFixture) reconcile(name, pipelineRun string, annotations map[string]string) *apis.Condition {
+	f.t.Helper()
+	return f.reconcileRun(f.newRun(name, pipelineRun, annotations))
+}
+
+// reconcileRun reconciles the Run and returns its condition.
+func (f *lockFixture) reconcileRun(run *v1alpha1.Run) *apis.Condition {
+	f.t.Helper()
+	run.Status.InitializeConditions()
+	if err := f.r.reconcile(context.Background(), v1alpha1Run{run}); err != nil {
+		f.t.Fatalf("reconcile(%s) = %v", run.Name, err)
+	}
+	return run.Status.GetCondition(apis.ConditionSucceeded)
+}
+
+// locks returns the locks of the VariableStore.
+func (f *lockFixture) locks() []variablestorev1alpha1.Lock {
+	f.t.Helper()
+	store, err := f.client.CustomV1alpha1().VariableStores("default").Get(context.Background(), "store", metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("Get() = %v", err)
+	}
+	return store.Spec.Locks
+}
+
+func TestReconcileMutex(t *testing.T) {
+	f := newLockFixture(t, "pr-1", "pr-2", "pr-3")
+	acquire := map[string]string{"custom.tekton.dev/acquire": "deploy"}
+	release := map[string]string{"custom.tekton.dev/release": "deploy"}
+
+	if c := f.reconcile("acquire-1", "pr-1", acquire); !c.IsTrue() {
+		t.Fatalf("acquire-1 set the condition %v, want it to succeed", c)
+	}
+	want := []variablestorev1alpha1.Lock{{Name: "deploy", Capacity: 1, Holders: []variablestorev1alpha1.LockHolder{{Run: "acquire-1", PipelineRun: "pr-1", UID: "pr-1"}}}}
+	if d := cmp.Diff(want, f.locks()); d != "" {
+		t.Errorf("locks (-want, +got): %s", d)
+	}
+
+	// Acquiring the lock again on behalf of the same PipelineRun is a no-op
+	if c := f.reconcile("acquire-1-again", "pr-1", acquire); !c.IsTrue() {
+		t.Fatalf("acquire-1-again set the condition %v, want it to succeed", c)
+	}
+	if d := cmp.Diff(want, f.locks()); d != "" {
+		t.Errorf("locks (-want, +got): %s", d)
+	}
+
+	c := f.reconcile("acquire-2", "pr-2", acquire)
+	if !c.IsUnknown() || c.Reason != variablestorev1alpha1.ReasonWaitingForLock.String() ||
+		c.Message != "Waiting for the lock deploy, held by PipelineRun pr-1" {
+		t.Fatalf("acquire-2 set the condition %v, want it waiting for the lock", c)
+	}
+
+	if c := f.reconcile("release-1", "pr-1", release); !c.IsTrue() {
+		t.Fatalf("release-1 set the condition %v, want it to succeed", c)
+	}
+	if got := f.locks()[0].Holders; len(got) != 0 {
+		t.Errorf("holders = %v, want none", got)
+	}
+
+	if c := f.reconcile("acquire-2", "pr-2", acquire); !c.IsTrue() {
+		t.Fatalf("acquire-2 set the condition %v, want it to succeed", c)
+	}
+
+	// The lock is released when the PipelineRun holding it finishes
+	pr2 := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-2", Namespace: "default", UID: "pr-2"}}
+	pr2.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionTrue})
+	if err := f.pipelineRuns.Update(pr2); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if c := f.reconcile("acquire-3", "pr-3", acquire); !c.IsTrue() {
+		t.Fatalf("acquire-3 set the condition %v, want it to succeed", c)
+	}
+	want = []variablestorev1alpha1.Lock{{Name: "deploy", Capacity: 1, Holders: []variablestorev1alpha1.LockHolder{{Run: "acquire-3", PipelineRun: "pr-3", UID: "pr-3"}}}}
+	if d := cmp.Diff(want, f.locks()); d != "" {
+		t.Errorf("locks (-want, +got): %s", d)
+	}
+}
+
+func TestReconcileSemaphore(t *testing.T) {
+	f := newLockFixture(t, "pr-1", "pr-2", "pr-3")
+	acquire := map[string]string{"custom.tekton.dev/acquire": "build", "custom.tekton.dev/capacity": "2"}
+
+	for _, pipelineRun := range []string{"pr-1", "pr-2"} {
+		if c := f.reconcile("acquire", pipelineRun, acquire); !c.IsTrue() {
+			t.Fatalf("acquire of %s set the condition %v, want it to succeed", pipelineRun, c)
+		}
+	}
+	c := f.reconcile("acquire", "pr-3", acquire)
+	if !c.IsUnknown() || c.Message != "Waiting for the lock build, held by PipelineRun pr-1, PipelineRun pr-2" {
+		t.Fatalf("acquire of pr-3 set the condition %v, want it waiting for the lock", c)
+	}
+
+	// The PipelineRun holding the lock was deleted
+	if err := f.pipelineRuns.Delete(&v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-1", Namespace: "default"}}); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if c := f.reconcile("acquire", "pr-3", acquire); !c.IsTrue() {
+		t.Fatalf("acquire of pr-3 set the condition %v, want it to succeed", c)
+	}
+}
+
+func TestReconcileLockCapacity(t *testing.T) {
+	f := newLockFixture(t, "pr-1", "pr-2")
+	semaphore := map[string]string{"custom.tekton.dev/acquire": "deploy", "custom.tekton.dev/capacity": "2"}
+	mutex := map[string]string{"custom.tekton.dev/acquire": "deploy"}
+
+	if c := f.reconcile("acquire", "pr-1", semaphore); !c.IsTrue() {
+		t.Fatalf("acquire of pr-1 set the condition %v, want it to succeed", c)
+	}
+
+	// The lock is held with another capacity
+	c := f.reconcile("acquire", "pr-2", mutex)
+	if !c.IsFalse() || c.Reason != variablestorev1alpha1.ReasonFailedValidation.String() ||
+		c.Message != "The lock deploy is held with the capacity 2, not 1" {
+		t.Fatalf("acquire of pr-2 set the condition %v, want it invalid", c)
+	}
+	if got := f.locks()[0].Capacity; got != 2 {
+		t.Errorf("capacity = %d, want 2", got)
+	}
+
+	// Nobody holds the lock anymore, its capacity changes
+	if c := f.reconcile("release", "pr-1", map[string]string{"custom.tekton.dev/release": "deploy"}); !c.IsTrue() {
+		t.Fatalf("release of pr-1 set the condition %v, want it to succeed", c)
+	}
+	if c := f.reconcile("acquire", "pr-2", mutex); !c.IsTrue() {
+		t.Fatalf("acquire of pr-2 set the condition %v, want it to succeed", c)
+	}
+	if got := f.locks()[0].Capacity; got != 1 {
+		t.Errorf("capacity = %d, want 1", got)
+	}
+}
+
+func TestReconcileLockRecreatedHolder(t *testing.T) {
+	f := newLockFixture(t, "pr-1", "pr-2")
+	acquire := map[string]string{"custom.tekton.dev/acquire": "deploy"}
+
+	if c := f.reconcile("acquire", "pr-1", acquire); !c.IsTrue() {
+		t.Fatalf("acquire of pr-1 set the condition %v, want it to succeed", c)
+	}
+
+	// The PipelineRun holding the lock is deleted, and another one is created with its name
+	if err := f.pipelineRuns.Update(&v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-1", Namespace: "default", UID: "pr-1-again"}}); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if c := f.reconcile("acquire", "pr-2", acquire); !c.IsTrue() {
+		t.Fatalf("acquire of pr-2 set the condition %v, want it to succeed", c)
+	}
+	if c := f.reconcile("acquire-again", "pr-1", acquire); !c.IsUnknown() || c.Reason != variablestorev1alpha1.ReasonWaitingForLock.String() {
+		t.Fatalf("acquire of the new pr-1 set the condition %v, want it waiting for the lock", c)
+	}
+}
+
+func TestReconcileLockValidation(t *testing.T) {
+	f := newLockFixture(t, "pr-1")
+	for _, annotations := range []map[string]string{
+		{"custom.tekton.dev/acquire": ""},
+		{"custom.tekton.dev/acquire": "deploy", "custom.tekton.dev/capacity": "0"},
+		{"custom.tekton.dev/release": ""},
+	} {
+		if c := f.reconcile("run", "pr-1", annotations); !c.IsFalse() || c.Reason != variablestorev1alpha1.ReasonFailedValidation.String() {
+			t.Errorf("reconcile() with %v set the condition %v, want it invalid", annotations, c)
+		}
+	}
+
+	// A Run of VariableStores without a VariableStore has no lock to acquire or release
+	for _, annotations := range []map[string]string{
+		{"custom.tekton.dev/acquire": "deploy"},
+		{"custom.tekton.dev/release": "deploy"},
+	} {
+		run := f.newRun("run", "pr-1", annotations)
+		run.Spec.Ref.Name = ""
+		if c := f.reconcileRun(run); !c.IsFalse() || c.Reason != variablestorev1alpha1.ReasonFailedValidation.String() {
+			t.Errorf("reconcile() without a VariableStore with %v set the condition %v, want it invalid", annotations, c)
+		}
+	}
+}
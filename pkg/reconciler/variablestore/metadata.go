@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runMetadata returns the metadata of the Run exposed to expressions as the `run` variable.
+func runMetadata(run customRun) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               run.GetName(),
+		"namespace":          run.GetNamespace(),
+		"labels":             stringMap(run.GetLabels()),
+		"annotations":        stringMap(run.GetAnnotations()),
+		"serviceAccountName": run.GetServiceAccountName(),
+	}
+}
+
+// pipelineRunMetadata returns the metadata of the PipelineRun exposed to expressions as the
+// `pipelineRun` variable. It is empty when the Run is not owned by a PipelineRun.
+func pipelineRunMetadata(pr *v1beta1.PipelineRun) map[string]interface{} {
+	if pr == nil {
+		return map[string]interface{}{}
+	}
+
+	params := make(map[string]interface{}, len(pr.Spec.Params))
+	for _, param := range pr.Spec.Params {
+		if param.Value.Type == v1beta1.ParamTypeArray {
+			params[param.Name] = param.Value.ArrayVal
+		} else {
+			params[param.Name] = param.Value.StringVal
+		}
+	}
+
+	return map[string]interface{}{
+		"name":        pr.Name,
+		"namespace":   pr.Namespace,
+		"labels":      stringMap(pr.Labels),
+		"annotations": stringMap(pr.Annotations),
+		"params":      params,
+	}
+}
+
+// takenNames returns the names under which the variables of the VariableStore, if any, and the params of the
+// Run are exposed to expressions. They hide the metadata variables of the same names, like a `run` variable
+// of a VariableStore created before the metadata was exposed.
+func takenNames(run customRun, store *variablestorev1alpha1.VariableStore) map[string]struct{} {
+	taken := map[string]struct{}{}
+	if store != nil {
+		for _, variable := range visibleVars(run, store) {
+			taken[variablestorev1alpha1.Alias(variable.Name)] = struct{}{}
+		}
+	}
+	for _, param := range run.GetParams() {
+		taken[variablestorev1alpha1.Alias(param.Name)] = struct{}{}
+	}
+	return taken
+}
+
+// pipelineRunOwner returns the reference to the PipelineRun owning the Run, if any.
+func pipelineRunOwner(run metav1.Object) (metav1.OwnerReference, bool) {
+	for _, ref := range run.GetOwnerReferences() {
+		if ref.Kind == pipeline.PipelineRunControllerName {
+			return ref, true
+		}
+	}
+	return metav1.OwnerReference{}, false
+}
+
+// pipelineRunOwnerName returns the name of the PipelineRun owning the Run, if any.
+func pipelineRunOwnerName(run metav1.Object) (string, bool) {
+	ref, ok := pipelineRunOwner(run)
+	return ref.Name, ok
+}
+
+// stringMap returns m, or an empty map when m is nil, so that expressions could always index it.
+func stringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
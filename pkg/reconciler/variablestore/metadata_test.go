@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+// reconcileWithPipelineRun reconciles a Run owned by the PipelineRun called pipelineRun, which is in the
+// lister when it isn't nil, referencing a VariableStore holding the vars.
+func reconcileWithPipelineRun(t *testing.T, pipelineRun *v1beta1.PipelineRun, vars []variablestorev1alpha1.Var, params ...v1beta1.Param) (*v1alpha1.Run, error) {
+	t.Helper()
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec:       variablestorev1alpha1.VariableStoreSpec{Vars: vars},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if pipelineRun != nil {
+		if err := indexer.Add(pipelineRun); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	r := &Reconciler{
+		variablestoreClientSet: fakevariableclientset.NewSimpleClientset(store),
+		pipelineRunLister:      listers.NewPipelineRunLister(indexer),
+	}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "deploy-abcde-check",
+			Namespace:       "default",
+			UID:             "run-uid",
+			Labels:          map[string]string{"app": "shop"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: "deploy-abcde"}},
+		},
+		Spec: v1alpha1.RunSpec{
+			Ref:                &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+			Params:             params,
+			ServiceAccountName: "deployer",
+		},
+	}
+	run.Status.InitializeConditions()
+	return run, r.reconcile(context.Background(), v1alpha1Run{run})
+}
+
+func TestReconcileMetadata(t *testing.T) {
+	pipelineRun := &v1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "deploy-abcde", Namespace: "default"},
+		Spec:       v1beta1.PipelineRunSpec{Params: []v1beta1.Param{stringParam("env", "prod")}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		vars   []variablestorev1alpha1.Var
+		params []v1beta1.Param
+		want   map[string]string
+	}{{
+		name: "run and pipelineRun",
+		params: []v1beta1.Param{
+			stringParam("name", "run.name"),
+			stringParam("app", "run.labels['app']"),
+			stringParam("sa", "run.serviceAccountName"),
+			stringParam("pipeline", "pipelineRun.name"),
+			stringParam("env", "pipelineRun.params['env']"),
+		},
+		want: map[string]string{"name": "deploy-abcde-check", "app": "shop", "sa": "deployer", "pipeline": "deploy-abcde", "env": "prod"},
+	}, {
+		name:   "variable hiding run",
+		vars:   []variablestorev1alpha1.Var{{Name: "run", Value: "42", Type: variablestorev1alpha1.VarTypeString}},
+		params: []v1beta1.Param{stringParam("seen", "run")},
+		want:   map[string]string{"seen": "42"},
+	}, {
+		name:   "param hiding pipelineRun",
+		params: []v1beta1.Param{stringParam("pipelineRun", "'mine'"), stringParam("seen", "pipelineRun")},
+		want:   map[string]string{"pipelineRun": "mine", "seen": "mine"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			run, err := reconcileWithPipelineRun(t, pipelineRun, tc.vars, tc.params...)
+			if err != nil {
+				t.Fatalf("reconcile() = %v", err)
+			}
+			if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+				t.Fatalf("reconcile() set the condition %v, want success", c)
+			}
+			for name, want := range tc.want {
+				if got := runResult(run, name); got != want {
+					t.Errorf("result %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcilePipelineRunNotCached(t *testing.T) {
+	// The PipelineRun which created the Run is not in the lister cache yet, the Run is requeued
+	run, err := reconcileWithPipelineRun(t, nil, nil, stringParam("pipeline", "pipelineRun.name"))
+	if !errors.IsNotFound(err) {
+		t.Errorf("reconcile() = %v, want a NotFound error", err)
+	}
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsUnknown() {
+		t.Errorf("reconcile() set the condition %v, want the Run still running", c)
+	}
+}
+
+func TestPipelineRunMetadataWithoutPipelineRun(t *testing.T) {
+	if got := pipelineRunMetadata(nil); len(got) != 0 {
+		t.Errorf("pipelineRunMetadata(nil) = %v, want an empty map", got)
+	}
+}
@@ -0,0 +1,374 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+)
+
+// getOperations returns the operations the values of the params of the Run are written with, keyed by
+// param. The entries of the annotation which are not `param=Operation` are returned as they are, with an
+// empty operation.
+func getOperations(run metav1.Object) map[string]variablestorev1alpha1.Operation {
+	operations := map[string]variablestorev1alpha1.Operation{}
+	for _, entry := range strings.Split(run.GetAnnotations()[variablestores.OperationsAnnotationKey], ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			operations[entry] = ""
+			continue
+		}
+		operations[strings.TrimSpace(parts[0])] = variablestorev1alpha1.Operation(strings.TrimSpace(parts[1]))
+	}
+	return operations
+}
+
+func validateOperations(run customRun) (errs *apis.FieldError) {
+	operations := getOperations(run)
+	params := map[string]struct{}{}
+	for _, param := range run.GetParams() {
+		params[param.Name] = struct{}{}
+	}
+	names := make([]string, 0, len(operations))
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch operation := operations[name]; operation {
+		case variablestorev1alpha1.OperationSet, variablestorev1alpha1.OperationAdd, variablestorev1alpha1.OperationAppend,
+			variablestorev1alpha1.OperationInsert, variablestorev1alpha1.OperationRemove:
+		case "":
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not of the form param=Operation", name),
+				variablestores.OperationsAnnotationKey).ViaField("metadata", "annotations"))
+			continue
+		default:
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not an operation, the value of %s can't be written with it", operation, name),
+				variablestores.OperationsAnnotationKey).ViaField("metadata", "annotations"))
+		}
+		if _, ok := params[name]; !ok {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not a param of the Run", name),
+				variablestores.OperationsAnnotationKey).ViaField("metadata", "annotations"))
+		}
+	}
+	if len(operations) > 0 && !referencesStore(run) {
+		errs = errs.Also(apis.ErrInvalidValue("the Run doesn't reference a VariableStore to write to",
+			variablestores.OperationsAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+// write is a variable written to the VariableStore, with the value of the CEL expression of the param it is
+// written from and the operation it is written with.
+type write struct {
+	param     string
+	variable  variablestorev1alpha1.Var
+	value     ref.Val
+	operation variablestorev1alpha1.Operation
+}
+
+// write applies the writes to the variables of the VariableStore and updates it, recording that the writes of
+// the Run were applied in the same update. On conflict, the writes are applied again to the latest version of
+// the VariableStore, so that no concurrent update is lost, unless it records them already. It returns the
+// variables as written, e.g. the incremented counters, and false when the Run failed because of them.
+func (r *Reconciler) write(ctx context.Context, run customRun, store *variablestorev1alpha1.VariableStore, writes []write) ([]variablestorev1alpha1.Var, bool, error) {
+	logger := logging.FromContext(ctx)
+	var written []variablestorev1alpha1.Var
+	var failed *write
+	var failure error
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if applied, ok := findApplied(store, run.GetUID()); ok {
+			written = appliedResults(applied)
+			return nil
+		}
+
+		// A transactional Run writes to the overlay of its PipelineRun, on top of the variables, and stages its
+		// writes there to apply them again on commit
+		updated := store.DeepCopy()
+		vars, base := &updated.Spec.Vars, []variablestorev1alpha1.Var(nil)
+		var overlay *variablestorev1alpha1.Overlay
+		if owner, ok := getTransaction(run); ok {
+			overlay = getOverlay(&updated.Spec, owner)
+			vars, base = &overlay.Vars, updated.Spec.Vars
+		}
+		written = make([]variablestorev1alpha1.Var, 0, len(writes))
+		for i := range writes {
+			var variable variablestorev1alpha1.Var
+			*vars, variable, failure = apply(*vars, base, writes[i])
+			if failure == nil && overlay != nil {
+				var staged variablestorev1alpha1.StagedWrite
+				if staged, failure = stage(writes[i], variable); failure == nil {
+					overlay.Writes = append(overlay.Writes, staged)
+				}
+			}
+			if failure != nil {
+				failed = &writes[i]
+				return nil
+			}
+			written = append(written, variable)
+		}
+		updated.Spec.Applied = recordApplied(updated.Spec.Applied, run, written)
+
+		_, err := r.variablestoreClientSet.CustomV1alpha1().VariableStores(store.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			logger.Infof("VariableStore %s was updated concurrently when reconciling Run %s/%s, writing again: %v", store.Name, run.GetNamespace(), run.GetName(), err)
+			latest, getErr := r.variablestoreClientSet.CustomV1alpha1().VariableStores(store.Namespace).Get(ctx, store.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			store = latest
+		}
+		return err
+	})
+	switch {
+	case failed != nil:
+		logger.Errorf("The value of CEL expression %s could not be written when reconciling Run %s/%s: %v", failed.param, run.GetNamespace(), run.GetName(), failure)
+		run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+			"The value of CEL expression %s could not be written: %v", failed.param, failure)
+		return nil, false, nil
+	case errors.IsConflict(err):
+		// Still conflicting, the Run is reconciled again and the writes retried later
+		logger.Infof("Couldn't update VariableStore %s when reconciling Run %s/%s, retrying: %v", store.Name, run.GetNamespace(), run.GetName(), err)
+		return nil, false, err
+	case err != nil:
+		logger.Errorf("Update VariableStore: %s hit excetion: %v", store.Name, err)
+		run.MarkFailed(variablestorev1alpha1.VariableStoreReasonUpdateFaild.String(),
+			"Update VariableStore: %s hit excetion: %v", store.Name, err)
+		return nil, false, nil
+	}
+	return written, true, nil
+}
+
+// findApplied returns the record of the Run whose writes were applied to the VariableStore, and whether the
+// VariableStore records it.
+func findApplied(store *variablestorev1alpha1.VariableStore, uid ktypes.UID) (variablestorev1alpha1.AppliedRun, bool) {
+	for _, applied := range store.Spec.Applied {
+		if applied.UID == uid {
+			return applied, true
+		}
+	}
+	return variablestorev1alpha1.AppliedRun{}, false
+}
+
+// appliedResults returns the results of the Run whose writes were applied, the variables as written.
+func appliedResults(applied variablestorev1alpha1.AppliedRun) []variablestorev1alpha1.Var {
+	written := make([]variablestorev1alpha1.Var, 0, len(applied.Results))
+	for _, result := range applied.Results {
+		written = append(written, variablestorev1alpha1.Var{Name: result.Name, Value: result.Value})
+	}
+	return written
+}
+
+// recordApplied records that the writes of the Run were applied, forgetting the oldest Runs over
+// MaxAppliedRuns.
+func recordApplied(records []variablestorev1alpha1.AppliedRun, run customRun, written []variablestorev1alpha1.Var) []variablestorev1alpha1.AppliedRun {
+	applied := variablestorev1alpha1.AppliedRun{UID: run.GetUID(), Name: run.GetName()}
+	for _, variable := range written {
+		applied.Results = append(applied.Results, variablestorev1alpha1.AppliedResult{Name: variable.Name, Value: variable.Value})
+	}
+	records = append(records, applied)
+	if len(records) > variablestorev1alpha1.MaxAppliedRuns {
+		records = append([]variablestorev1alpha1.AppliedRun{}, records[len(records)-variablestorev1alpha1.MaxAppliedRuns:]...)
+	}
+	return records
+}
+
+// apply applies the write to the variables, and returns them with the variable as written. The variables
+// missing from vars are read from base, if any.
+func apply(vars, base []variablestorev1alpha1.Var, w write) ([]variablestorev1alpha1.Var, variablestorev1alpha1.Var, error) {
+	var current *variablestorev1alpha1.Var
+	if contain, index := containsParam(w.variable.Name, vars); contain {
+		current = &vars[index]
+	} else if contain, index := containsParam(w.variable.Name, base); contain {
+		current = &base[index]
+	}
+
+	variable := w.variable
+	var err error
+	switch w.operation {
+	case variablestorev1alpha1.OperationAdd:
+		variable, err = add(current, w.variable.Name, w.value)
+	case variablestorev1alpha1.OperationAppend, variablestorev1alpha1.OperationInsert, variablestorev1alpha1.OperationRemove:
+		variable, err = update(current, w.variable.Name, w.value, w.operation)
+	}
+	if err != nil {
+		return vars, variable, err
+	}
+	return setVar(vars, variable), variable, nil
+}
+
+// add adds the value, an int or a double, to the numeric variable, which counts as 0 when missing. The sum
+// of two ints is an int, and a double otherwise.
+func add(current *variablestorev1alpha1.Var, name string, value ref.Val) (variablestorev1alpha1.Var, error) {
+	var i int64
+	var f float64
+	double := false
+	if current != nil {
+		t := current.Type
+		if t == "" {
+			t = variablestorev1alpha1.InferVarType(current.Value)
+		}
+		var err error
+		switch t {
+		case variablestorev1alpha1.VarTypeInt:
+			i, err = strconv.ParseInt(strings.TrimSpace(current.Value), 10, 64)
+		case variablestorev1alpha1.VarTypeDouble:
+			f, err = strconv.ParseFloat(strings.TrimSpace(current.Value), 64)
+			double = true
+		default:
+			err = fmt.Errorf("the variable %s holds a %s, not a number", name, t)
+		}
+		if err != nil {
+			return variablestorev1alpha1.Var{}, err
+		}
+	}
+
+	switch value := value.(type) {
+	case types.Int:
+		if double {
+			f += float64(value)
+			break
+		}
+		if (value > 0 && i > math.MaxInt64-int64(value)) || (value < 0 && i < math.MinInt64-int64(value)) {
+			return variablestorev1alpha1.Var{}, fmt.Errorf("adding %d to the variable %s overflows", value, name)
+		}
+		i += int64(value)
+	case types.Double:
+		if !double {
+			f, double = float64(i), true
+		}
+		f += float64(value)
+	default:
+		return variablestorev1alpha1.Var{}, fmt.Errorf("only an int or a double can be added to the variable %s, not a %s", name, value.Type().TypeName())
+	}
+
+	if double {
+		return variablestorev1alpha1.Var{Name: name, Value: strconv.FormatFloat(f, 'g', -1, 64), Type: variablestorev1alpha1.VarTypeDouble}, nil
+	}
+	return variablestorev1alpha1.Var{Name: name, Value: strconv.FormatInt(i, 10), Type: variablestorev1alpha1.VarTypeInt}, nil
+}
+
+// update appends, inserts or removes the value to or from the list or map variable, written in JSON. A
+// missing variable is an empty list, or an empty map when a map is inserted to it.
+func update(current *variablestorev1alpha1.Var, name string, value ref.Val, operation variablestorev1alpha1.Operation) (variablestorev1alpha1.Var, error) {
+	v, err := value.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return variablestorev1alpha1.Var{}, err
+	}
+	operand := v.(*structpb.Value).AsInterface()
+	elements, isList := operand.([]interface{})
+	if !isList {
+		elements = []interface{}{operand}
+	}
+
+	var collection interface{} = []interface{}{}
+	if _, isMap := operand.(map[string]interface{}); isMap && operation == variablestorev1alpha1.OperationInsert {
+		collection = map[string]interface{}{}
+	}
+	if current != nil && current.Value != "" {
+		if err := json.Unmarshal([]byte(current.Value), &collection); err != nil {
+			return variablestorev1alpha1.Var{}, fmt.Errorf("the variable %s holds neither a list nor a map: %v", name, err)
+		}
+	}
+
+	switch collection := collection.(type) {
+	case []interface{}:
+		switch operation {
+		case variablestorev1alpha1.OperationAppend:
+			collection = append(collection, elements...)
+		case variablestorev1alpha1.OperationInsert:
+			for _, element := range elements {
+				if !containsElement(collection, element) {
+					collection = append(collection, element)
+				}
+			}
+		case variablestorev1alpha1.OperationRemove:
+			kept := []interface{}{}
+			for _, element := range collection {
+				if !containsElement(elements, element) {
+					kept = append(kept, element)
+				}
+			}
+			collection = kept
+		}
+		return jsonVar(name, collection)
+	case map[string]interface{}:
+		switch operation {
+		case variablestorev1alpha1.OperationInsert:
+			entries, ok := operand.(map[string]interface{})
+			if !ok {
+				return variablestorev1alpha1.Var{}, fmt.Errorf("only a map can be inserted to the map variable %s", name)
+			}
+			for key, entry := range entries {
+				collection[key] = entry
+			}
+		case variablestorev1alpha1.OperationRemove:
+			for _, key := range elements {
+				k, ok := key.(string)
+				if !ok {
+					return variablestorev1alpha1.Var{}, fmt.Errorf("only string keys can be removed from the map variable %s", name)
+				}
+				delete(collection, k)
+			}
+		default:
+			return variablestorev1alpha1.Var{}, fmt.Errorf("the variable %s holds a map, which can't be appended to", name)
+		}
+		return jsonVar(name, collection)
+	default:
+		return variablestorev1alpha1.Var{}, fmt.Errorf("the variable %s holds neither a list nor a map", name)
+	}
+}
+
+// containsElement returns whether the list holds the element.
+func containsElement(list []interface{}, element interface{}) bool {
+	for _, e := range list {
+		if reflect.DeepEqual(e, element) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonVar returns the variable holding the list or map, written in JSON like the results of the Run.
+func jsonVar(name string, collection interface{}) (variablestorev1alpha1.Var, error) {
+	b, err := json.Marshal(collection)
+	if err != nil {
+		return variablestorev1alpha1.Var{}, err
+	}
+	return variablestorev1alpha1.Var{Name: name, Value: string(b), Type: variablestorev1alpha1.VarTypeString}, nil
+}
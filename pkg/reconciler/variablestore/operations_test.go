@@ -0,0 +1,304 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	ktesting "k8s.io/client-go/testing"
+	"knative.dev/pkg/apis"
+)
+
+func TestReconcileOperations(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		vars       []variablestorev1alpha1.Var
+		operations string
+		params     []v1beta1.Param
+		wantReason string
+		want       []variablestorev1alpha1.Var
+	}{{
+		name:       "increment missing counter",
+		operations: "builds=Add",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		want:       []variablestorev1alpha1.Var{{Name: "builds", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+	}, {
+		name:       "increment counter",
+		vars:       []variablestorev1alpha1.Var{{Name: "builds", Value: "41", Type: variablestorev1alpha1.VarTypeInt}},
+		operations: "builds=Add",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		want:       []variablestorev1alpha1.Var{{Name: "builds", Value: "42", Type: variablestorev1alpha1.VarTypeInt}},
+	}, {
+		name:       "decrement counter",
+		vars:       []variablestorev1alpha1.Var{{Name: "slots", Value: "3"}},
+		operations: "slots=Add",
+		params:     []v1beta1.Param{stringParam("slots", "-1")},
+		want:       []variablestorev1alpha1.Var{{Name: "slots", Value: "2", Type: variablestorev1alpha1.VarTypeInt}},
+	}, {
+		name:       "add double",
+		vars:       []variablestorev1alpha1.Var{{Name: "ratio", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+		operations: "ratio=Add",
+		params:     []v1beta1.Param{stringParam("ratio", "0.5")},
+		want:       []variablestorev1alpha1.Var{{Name: "ratio", Value: "1.5", Type: variablestorev1alpha1.VarTypeDouble}},
+	}, {
+		name:       "add to string",
+		vars:       []variablestorev1alpha1.Var{{Name: "builds", Value: "many", Type: variablestorev1alpha1.VarTypeString}},
+		operations: "builds=Add",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		wantReason: variablestorev1alpha1.ReasonEvaluationError.String(),
+	}, {
+		name:       "add string",
+		operations: "builds=Add",
+		params:     []v1beta1.Param{stringParam("builds", "'1'")},
+		wantReason: variablestorev1alpha1.ReasonEvaluationError.String(),
+	}, {
+		name:       "append",
+		vars:       []variablestorev1alpha1.Var{{Name: "versions", Value: `["v1"]`}},
+		operations: "versions=Append",
+		params:     []v1beta1.Param{stringParam("versions", "'v1'")},
+		want:       []variablestorev1alpha1.Var{{Name: "versions", Value: `["v1","v1"]`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "append list to missing",
+		operations: " versions = Append ",
+		params:     []v1beta1.Param{stringParam("versions", "['v1', 'v2']")},
+		want:       []variablestorev1alpha1.Var{{Name: "versions", Value: `["v1","v2"]`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "append to map",
+		vars:       []variablestorev1alpha1.Var{{Name: "versions", Value: `{"a":1}`}},
+		operations: "versions=Append",
+		params:     []v1beta1.Param{stringParam("versions", "'v1'")},
+		wantReason: variablestorev1alpha1.ReasonEvaluationError.String(),
+	}, {
+		name:       "insert to set",
+		vars:       []variablestorev1alpha1.Var{{Name: "regions", Value: `["eu","us"]`}},
+		operations: "regions=Insert",
+		params:     []v1beta1.Param{stringParam("regions", "['us', 'ap']")},
+		want:       []variablestorev1alpha1.Var{{Name: "regions", Value: `["eu","us","ap"]`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "insert to map",
+		vars:       []variablestorev1alpha1.Var{{Name: "deployed", Value: `{"eu":"v1","us":"v1"}`}},
+		operations: "deployed=Insert",
+		params:     []v1beta1.Param{stringParam("deployed", "{'us': 'v2'}")},
+		want:       []variablestorev1alpha1.Var{{Name: "deployed", Value: `{"eu":"v1","us":"v2"}`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "insert map to missing",
+		operations: "deployed=Insert",
+		params:     []v1beta1.Param{stringParam("deployed", "{'us': 2}")},
+		want:       []variablestorev1alpha1.Var{{Name: "deployed", Value: `{"us":2}`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "remove from list",
+		vars:       []variablestorev1alpha1.Var{{Name: "regions", Value: `["eu","us","eu"]`}},
+		operations: "regions=Remove",
+		params:     []v1beta1.Param{stringParam("regions", "'eu'")},
+		want:       []variablestorev1alpha1.Var{{Name: "regions", Value: `["us"]`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "remove from map",
+		vars:       []variablestorev1alpha1.Var{{Name: "deployed", Value: `{"eu":"v1","us":"v1","ap":"v1"}`}},
+		operations: "deployed=Remove",
+		params:     []v1beta1.Param{stringParam("deployed", "['eu', 'ap']")},
+		want:       []variablestorev1alpha1.Var{{Name: "deployed", Value: `{"us":"v1"}`, Type: variablestorev1alpha1.VarTypeString}},
+	}, {
+		name:       "remove from string",
+		vars:       []variablestorev1alpha1.Var{{Name: "regions", Value: "eu"}},
+		operations: "regions=Remove",
+		params:     []v1beta1.Param{stringParam("regions", "'eu'")},
+		wantReason: variablestorev1alpha1.ReasonEvaluationError.String(),
+	}, {
+		name:       "set",
+		vars:       []variablestorev1alpha1.Var{{Name: "builds", Value: "41", Type: variablestorev1alpha1.VarTypeInt}},
+		operations: "builds=Set",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		want:       []variablestorev1alpha1.Var{{Name: "builds", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+	}, {
+		name:       "malformed",
+		operations: "builds",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		wantReason: variablestorev1alpha1.ReasonFailedValidation.String(),
+	}, {
+		name:       "unknown operation",
+		operations: "builds=Multiply",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		wantReason: variablestorev1alpha1.ReasonFailedValidation.String(),
+	}, {
+		name:       "not a param",
+		operations: "build=Add",
+		params:     []v1beta1.Param{stringParam("builds", "1")},
+		wantReason: variablestorev1alpha1.ReasonFailedValidation.String(),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			vars := append([]variablestorev1alpha1.Var{}, tc.vars...)
+			run, store := reconcileRun(t, vars, map[string]string{"custom.tekton.dev/operations": tc.operations}, tc.params...)
+			condition := run.Status.GetCondition(apis.ConditionSucceeded)
+			if tc.wantReason != "" {
+				if !condition.IsFalse() || condition.Reason != tc.wantReason {
+					t.Fatalf("reconcile() set the condition %v, want the reason %s", condition, tc.wantReason)
+				}
+				return
+			}
+			if !condition.IsTrue() {
+				t.Fatalf("reconcile() set the condition %v, want success", condition)
+			}
+			for _, want := range tc.want {
+				contain, index := containsParam(want.Name, store.Spec.Vars)
+				if !contain || store.Spec.Vars[index] != want {
+					t.Errorf("VariableStore vars = %v, want %v", store.Spec.Vars, want)
+				}
+				if result := runResult(run, want.Name); result != want.Value {
+					t.Errorf("Run result %s = %q, want %q", want.Name, result, want.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileOperationsConflict(t *testing.T) {
+	ctx := context.Background()
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{Vars: []variablestorev1alpha1.Var{
+			{Name: "builds", Value: "1", Type: variablestorev1alpha1.VarTypeInt},
+		}},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+
+	// Another Run increments the counter between the reconciled Run reading and updating the VariableStore
+	conflicts := 0
+	client.PrependReactor("update", "variablestores", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		concurrent := store.DeepCopy()
+		concurrent.Spec.Vars[0].Value = "10"
+		if err := client.Tracker().Update(variablestorev1alpha1.SchemeGroupVersion.WithResource("variablestores"), concurrent, "default"); err != nil {
+			return true, nil, err
+		}
+		return true, nil, apierrors.NewConflict(variablestorev1alpha1.Resource("variablestores"), "store", nil)
+	})
+
+	r := &Reconciler{variablestoreClientSet: client}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default", Annotations: map[string]string{
+			"custom.tekton.dev/operations": "builds=Add",
+		}},
+		Spec: v1alpha1.RunSpec{
+			Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+			Params: []v1beta1.Param{stringParam("builds", "1")},
+		},
+	}
+	run.Status.InitializeConditions()
+	if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+		t.Fatalf("reconcile() = %v", err)
+	}
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Fatalf("reconcile() set the condition %v, want success", c)
+	}
+	if result := runResult(run, "builds"); result != "11" {
+		t.Errorf("Run result builds = %q, want 11", result)
+	}
+	updated, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got := updated.Spec.Vars[0].Value; got != "11" {
+		t.Errorf("VariableStore var builds = %q, want 11", got)
+	}
+}
+
+func TestReconcileExactlyOnce(t *testing.T) {
+	ctx := context.Background()
+	applied := make([]variablestorev1alpha1.AppliedRun, 0, variablestorev1alpha1.MaxAppliedRuns)
+	for i := 0; i < variablestorev1alpha1.MaxAppliedRuns; i++ {
+		applied = append(applied, variablestorev1alpha1.AppliedRun{UID: ktypes.UID(fmt.Sprintf("uid-%d", i))})
+	}
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{
+			Vars:    []variablestorev1alpha1.Var{{Name: "builds", Value: "41", Type: variablestorev1alpha1.VarTypeInt}},
+			Applied: applied,
+		},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+	r := &Reconciler{variablestoreClientSet: client}
+	newRun := func() *v1alpha1.Run {
+		run := &v1alpha1.Run{
+			ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default", UID: "run-uid", Annotations: map[string]string{
+				"custom.tekton.dev/operations": "builds=Add",
+			}},
+			Spec: v1alpha1.RunSpec{
+				Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+				Params: []v1beta1.Param{stringParam("builds", "1")},
+			},
+		}
+		run.Status.InitializeConditions()
+		return run
+	}
+
+	// The Run is reconciled twice, the second time as if its status wasn't persisted
+	run := newRun()
+	for _, run := range []*v1alpha1.Run{run, run, newRun()} {
+		if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+			t.Fatalf("reconcile() = %v", err)
+		}
+		if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+			t.Fatalf("reconcile() set the condition %v, want success", c)
+		}
+		want := []v1alpha1.RunResult{{Name: "builds", Value: "42"}}
+		if d := cmp.Diff(want, run.Status.Results); d != "" {
+			t.Errorf("Run results (-want, +got): %s", d)
+		}
+	}
+
+	updated, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got := updated.Spec.Vars[0].Value; got != "42" {
+		t.Errorf("VariableStore var builds = %q, want 42", got)
+	}
+	if got := len(updated.Spec.Applied); got != variablestorev1alpha1.MaxAppliedRuns {
+		t.Fatalf("VariableStore records %d applied Runs, want %d", got, variablestorev1alpha1.MaxAppliedRuns)
+	}
+	if got := updated.Spec.Applied[0].UID; got != "uid-1" {
+		t.Errorf("VariableStore records %s first, want the oldest Run forgotten", got)
+	}
+	want := variablestorev1alpha1.AppliedRun{UID: "run-uid", Name: "run", Results: []variablestorev1alpha1.AppliedResult{{Name: "builds", Value: "42"}}}
+	if d := cmp.Diff(want, updated.Spec.Applied[variablestorev1alpha1.MaxAppliedRuns-1]); d != "" {
+		t.Errorf("VariableStore applied Run (-want, +got): %s", d)
+	}
+}
+
+// runResult returns the value of the result of the Run called name.
+func runResult(run *v1alpha1.Run, name string) string {
+	for _, result := range run.Status.Results {
+		if result.Name == name {
+			return result.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/types/known/structpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getArrayParamsPolicy returns how the array params of the Run are evaluated, Expressions by default.
+func getArrayParamsPolicy(run metav1.Object) variablestorev1alpha1.ArrayParamsPolicy {
+	if policy, ok := run.GetAnnotations()[variablestores.ArrayParamsAnnotationKey]; ok {
+		return variablestorev1alpha1.ArrayParamsPolicy(policy)
+	}
+	return variablestorev1alpha1.ArrayParamsExpressions
+}
+
+// resultValue returns the value of a CEL expression as written in the results of the Run and in the
+// VariableStore. Lists and maps are written in JSON, the Runs of Tekton v0.22 only having string results.
+func resultValue(out ref.Val) (string, error) {
+	switch out.Type() {
+	case types.ListType, types.MapType:
+		value, err := out.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(value.(*structpb.Value).AsInterface())
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return fmt.Sprintf("%s", out.ConvertToType(types.StringType).Value()), nil
+	}
+}
+
+// contextValue returns the value of a CEL expression as exposed to the next expressions: lists and maps as
+// they are, so that they could be indexed, and the other values as written in the results.
+func contextValue(out ref.Val, value string) interface{} {
+	switch out.Type() {
+	case types.ListType, types.MapType:
+		return out
+	default:
+		return value
+	}
+}
+
+// contextType returns the type a CEL expression is declared of for the next expressions, dynamic for lists
+// and maps so that they could be iterated.
+func contextType(out ref.Val) *exprpb.Type {
+	switch out.Type() {
+	case types.ListType, types.MapType:
+		return decls.Dyn
+	default:
+		return decls.Any
+	}
+}
+
+// getExpandedParams returns the names of the params of the Run whose values are expanded.
+func getExpandedParams(run metav1.Object) map[string]struct{} {
+	expanded := map[string]struct{}{}
+	for _, name := range strings.Split(run.GetAnnotations()[variablestores.ExpandAnnotationKey], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			expanded[name] = struct{}{}
+		}
+	}
+	return expanded
+}
+
+// outputs returns the variables the value of the CEL expression of the param is written to, in the results
+// of the Run and in the VariableStore: the param itself, or when the param is expanded and its value is a
+// map or a list, a variable per entry named after the param and the key or index of the entry, e.g.
+// `decision.region` or `targets.0`. The map entries are sorted by key. The values the variables are written
+// from are returned along with them.
+func outputs(name string, out ref.Val, expand bool) ([]variablestorev1alpha1.Var, []ref.Val, error) {
+	names := []string{name}
+	values := []ref.Val{out}
+	if expand {
+		switch out := out.(type) {
+		case traits.Mapper:
+			keys := map[string]ref.Val{}
+			for it := out.Iterator(); it.HasNext() == types.True; {
+				key := it.Next()
+				keys[fmt.Sprintf("%s", key.ConvertToType(types.StringType).Value())] = key
+			}
+			names, values = make([]string, 0, len(keys)), make([]ref.Val, 0, len(keys))
+			for key := range keys {
+				names = append(names, key)
+			}
+			sort.Strings(names)
+			for i, key := range names {
+				values = append(values, out.Get(keys[key]))
+				names[i] = name + "." + key
+			}
+		case traits.Lister:
+			size := int(out.Size().(types.Int))
+			names, values = make([]string, 0, size), make([]ref.Val, 0, size)
+			for i := 0; i < size; i++ {
+				names = append(names, fmt.Sprintf("%s.%d", name, i))
+				values = append(values, out.Get(types.Int(i)))
+			}
+		}
+	}
+
+	vars := make([]variablestorev1alpha1.Var, 0, len(names))
+	for i, value := range values {
+		v, err := resultValue(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		vars = append(vars, variablestorev1alpha1.Var{Name: names[i], Value: v, Type: varType(value)})
+	}
+	return vars, values, nil
+}
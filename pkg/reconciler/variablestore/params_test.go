@@ -0,0 +1,240 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+func stringParam(name, value string) v1beta1.Param {
+	return v1beta1.Param{Name: name, Value: *v1beta1.NewArrayOrString(value)}
+}
+
+func arrayParam(name string, values ...string) v1beta1.Param {
+	return v1beta1.Param{Name: name, Value: *v1beta1.NewArrayOrString(values[0], values[1:]...)}
+}
+
+// reconcileRun reconciles a Run with the params and annotations, referencing a VariableStore holding the
+// vars, and returns the reconciled Run and VariableStore.
+func reconcileRun(t *testing.T, vars []variablestorev1alpha1.Var, annotations map[string]string, params ...v1beta1.Param) (*v1alpha1.Run, *variablestorev1alpha1.VariableStore) {
+	t.Helper()
+	ctx := context.Background()
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec:       variablestorev1alpha1.VariableStoreSpec{Vars: vars},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+	r := &Reconciler{variablestoreClientSet: client}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default", UID: "run-uid", Annotations: annotations},
+		Spec: v1alpha1.RunSpec{
+			Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+			Params: params,
+		},
+	}
+	run.Status.InitializeConditions()
+	if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+		t.Fatalf("reconcile() = %v", err)
+	}
+	store, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	return run, store
+}
+
+func TestReconcileArrayParams(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		params      []v1beta1.Param
+		wantReason  string
+		wantResults []v1alpha1.RunResult
+	}{{
+		name:   "expressions",
+		params: []v1beta1.Param{arrayParam("a", "1 + 1", "'x'", "true"), stringParam("b", "a[0] == 2 && size(a) == 3")},
+		wantResults: []v1alpha1.RunResult{
+			{Name: "a", Value: `[2,"x",true]`},
+			{Name: "b", Value: "true"},
+		},
+	}, {
+		name:        "literals",
+		annotations: map[string]string{"custom.tekton.dev/array-params": "Literals"},
+		params:      []v1beta1.Param{arrayParam("a", "1 + 1", "b"), stringParam("b", "a.exists(x, x == '1 + 1')")},
+		wantResults: []v1alpha1.RunResult{
+			{Name: "a", Value: `["1 + 1","b"]`},
+			{Name: "b", Value: "true"},
+		},
+	}, {
+		name:        "maps",
+		params:      []v1beta1.Param{stringParam("a", "{'x': [1, 2], 'y': 'z'}"), stringParam("b", "a.x[1]")},
+		wantResults: []v1alpha1.RunResult{{Name: "a", Value: `{"x":[1,2],"y":"z"}`}, {Name: "b", Value: "2"}},
+	}, {
+		name:        "unknown element",
+		annotations: map[string]string{"custom.tekton.dev/unknowns": "Fail"},
+		params:      []v1beta1.Param{arrayParam("a", "1", "missing + 1"), stringParam("b", "a[0]")},
+		wantReason:  variablestorev1alpha1.ReasonPartialEvaluation.String(),
+	}, {
+		name:       "syntax error",
+		params:     []v1beta1.Param{arrayParam("a", "1", "1 +")},
+		wantReason: variablestorev1alpha1.ReasonSyntaxError.String(),
+	}, {
+		name:       "empty expression",
+		params:     []v1beta1.Param{arrayParam("a", "1", "")},
+		wantReason: variablestorev1alpha1.ReasonFailedValidation.String(),
+	}, {
+		name:        "invalid policy",
+		annotations: map[string]string{"custom.tekton.dev/array-params": "Strings"},
+		params:      []v1beta1.Param{arrayParam("a", "1")},
+		wantReason:  variablestorev1alpha1.ReasonFailedValidation.String(),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			run, store := reconcileRun(t, []variablestorev1alpha1.Var{}, tc.annotations, tc.params...)
+			condition := run.Status.GetCondition(apis.ConditionSucceeded)
+			if tc.wantReason != "" {
+				if !condition.IsFalse() || condition.Reason != tc.wantReason {
+					t.Fatalf("reconcile() set the condition %v, want the reason %s", condition, tc.wantReason)
+				}
+				return
+			}
+			if !condition.IsTrue() {
+				t.Fatalf("reconcile() set the condition %v", condition)
+			}
+			if d := cmp.Diff(tc.wantResults, run.Status.Results); d != "" {
+				t.Errorf("results (-want, +got): %s", d)
+			}
+			if got, want := store.Spec.Vars[0].Value, tc.wantResults[0].Value; got != want {
+				t.Errorf("VariableStore var %s = %s, want %s", store.Spec.Vars[0].Name, got, want)
+			}
+		})
+	}
+}
+
+func TestResultValue(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{value: "x", want: "x"},
+		{value: int64(3), want: "3"},
+		{value: []string{"a", "b"}, want: `["a","b"]`},
+		{value: map[string]interface{}{"b": 1.5, "a": []bool{true}}, want: `{"a":[true],"b":1.5}`},
+	} {
+		out := types.DefaultTypeAdapter.NativeToValue(tc.value)
+		got, err := resultValue(out)
+		if err != nil {
+			t.Fatalf("resultValue(%v) = %v", tc.value, err)
+		}
+		if got != tc.want {
+			t.Errorf("resultValue(%v) = %s, want %s", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestReconcileExpandedParams(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		params      []v1beta1.Param
+		wantReason  string
+		wantResults []v1alpha1.RunResult
+	}{{
+		name:        "map",
+		annotations: map[string]string{"custom.tekton.dev/expand": "decision"},
+		params: []v1beta1.Param{
+			stringParam("decision", "{'region': 'eu', 'replicas': 3, 'zones': ['a', 'b']}"),
+			stringParam("big", "decision.replicas > 2"),
+		},
+		wantResults: []v1alpha1.RunResult{
+			{Name: "decision.region", Value: "eu"},
+			{Name: "decision.replicas", Value: "3"},
+			{Name: "decision.zones", Value: `["a","b"]`},
+			{Name: "big", Value: "true"},
+		},
+	}, {
+		name:        "list",
+		annotations: map[string]string{"custom.tekton.dev/expand": "targets, other"},
+		params:      []v1beta1.Param{arrayParam("targets", "'eu'", "1 + 1"), stringParam("other", "'x'")},
+		wantResults: []v1alpha1.RunResult{
+			{Name: "targets.0", Value: "eu"},
+			{Name: "targets.1", Value: "2"},
+			{Name: "other", Value: "x"},
+		},
+	}, {
+		name:        "not expanded",
+		params:      []v1beta1.Param{stringParam("decision", "{'region': 'eu'}")},
+		wantResults: []v1alpha1.RunResult{{Name: "decision", Value: `{"region":"eu"}`}},
+	}, {
+		name:        "unknown param",
+		annotations: map[string]string{"custom.tekton.dev/expand": "decision,missing"},
+		params:      []v1beta1.Param{stringParam("decision", "{'region': 'eu'}")},
+		wantReason:  variablestorev1alpha1.ReasonFailedValidation.String(),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			run, store := reconcileRun(t, []variablestorev1alpha1.Var{}, tc.annotations, tc.params...)
+			condition := run.Status.GetCondition(apis.ConditionSucceeded)
+			if tc.wantReason != "" {
+				if !condition.IsFalse() || condition.Reason != tc.wantReason {
+					t.Fatalf("reconcile() set the condition %v, want the reason %s", condition, tc.wantReason)
+				}
+				return
+			}
+			if !condition.IsTrue() {
+				t.Fatalf("reconcile() set the condition %v", condition)
+			}
+			if d := cmp.Diff(tc.wantResults, run.Status.Results); d != "" {
+				t.Errorf("results (-want, +got): %s", d)
+			}
+			written := map[string]string{}
+			for _, variable := range store.Spec.Vars {
+				written[variable.Name] = variable.Value
+			}
+			for _, result := range tc.wantResults {
+				if written[result.Name] != result.Value {
+					t.Errorf("VariableStore var %s = %q, want %q", result.Name, written[result.Name], result.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputsTypes(t *testing.T) {
+	out := types.DefaultTypeAdapter.NativeToValue(map[string]interface{}{"a": true, "b": int64(1), "c": 1.5, "d": "x"})
+	got, _, err := outputs("p", out, true)
+	if err != nil {
+		t.Fatalf("outputs() = %v", err)
+	}
+	want := []variablestorev1alpha1.Var{
+		{Name: "p.a", Value: "true", Type: variablestorev1alpha1.VarTypeBool},
+		{Name: "p.b", Value: "1", Type: variablestorev1alpha1.VarTypeInt},
+		{Name: "p.c", Value: "1.5", Type: variablestorev1alpha1.VarTypeDouble},
+		{Name: "p.d", Value: "x", Type: variablestorev1alpha1.VarTypeString},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("outputs() (-want, +got): %s", d)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/interpreter"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getUnknownsPolicy returns the UnknownsPolicy of the Run, and whether the Run opted in to partial evaluation.
+func getUnknownsPolicy(run metav1.Object) (variablestorev1alpha1.UnknownsPolicy, bool) {
+	policy, ok := run.GetAnnotations()[variablestores.UnknownsAnnotationKey]
+	return variablestorev1alpha1.UnknownsPolicy(policy), ok
+}
+
+// unknownPatterns returns the attribute patterns matching the unknown variables.
+func unknownPatterns(unknowns map[string]struct{}) []*interpreter.AttributePattern {
+	patterns := make([]*interpreter.AttributePattern, 0, len(unknowns))
+	for name := range unknowns {
+		patterns = append(patterns, cel.AttributePattern(name))
+	}
+	return patterns
+}
+
+// referencedUnknowns returns the sorted names of the unknown variables referenced by the checked Ast.
+func referencedUnknowns(ast *cel.Ast, unknowns map[string]struct{}) ([]string, error) {
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	names := []string{}
+	for _, reference := range checked.GetReferenceMap() {
+		name := reference.GetName()
+		if _, unknown := unknowns[name]; !unknown {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// residualExpression returns what remains of the CEL expression once evaluated with the known variables.
+func residualExpression(env *cel.Env, ast *cel.Ast, details *cel.EvalDetails) (string, error) {
+	residual, err := env.ResidualAst(ast, details)
+	if err != nil {
+		return "", err
+	}
+	return cel.AstToString(residual)
+}
+
+// newResidual describes what remains of the partially evaluated CEL expression of the param.
+func newResidual(env *cel.Env, ast *cel.Ast, details *cel.EvalDetails, name string, unknowns map[string]struct{}) (variablestorev1alpha1.Residual, error) {
+	expression, err := residualExpression(env, ast, details)
+	if err != nil {
+		return variablestorev1alpha1.Residual{}, err
+	}
+	names, err := referencedUnknowns(ast, unknowns)
+	if err != nil {
+		return variablestorev1alpha1.Residual{}, err
+	}
+	return variablestorev1alpha1.Residual{
+		Name:       name,
+		Expression: expression,
+		Unknowns:   names,
+	}, nil
+}
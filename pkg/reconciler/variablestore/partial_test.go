@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/go-cmp/cmp"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+)
+
+func TestPartialEvaluation(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		expression   string
+		wantUnknown  bool
+		wantResidual variablestorev1alpha1.Residual
+	}{{
+		name:       "known only",
+		expression: "job_priority == 'high'",
+	}, {
+		name:        "unknowns",
+		expression:  "job_priority == 'high' && alert_enable == 'true' || retries > 3",
+		wantUnknown: true,
+		wantResidual: variablestorev1alpha1.Residual{
+			Name:       "unknowns",
+			Expression: `alert_enable == "true" || retries > 3`,
+			Unknowns:   []string{"alert_enable", "retries"},
+		},
+	}, {
+		name:       "short-circuited unknown",
+		expression: "job_priority == 'low' && alert_enable == 'true'",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := cel.NewEnv(cel.Declarations(decls.NewVar("job_priority", decls.Any)))
+			if err != nil {
+				t.Fatalf("cel.NewEnv() = %v", err)
+			}
+			unknowns := map[string]struct{}{}
+			env, ast, iss := celenv.CompilePartial(env, tc.expression, unknowns)
+			if iss.Err() != nil {
+				t.Fatalf("celenv.CompilePartial() = %v", iss.Err())
+			}
+			prg, err := env.Program(ast, cel.EvalOptions(cel.OptPartialEval, cel.OptTrackState))
+			if err != nil {
+				t.Fatalf("Program() = %v", err)
+			}
+			activation, err := cel.PartialVars(map[string]interface{}{"job_priority": "high"}, unknownPatterns(unknowns)...)
+			if err != nil {
+				t.Fatalf("PartialVars() = %v", err)
+			}
+			out, details, err := prg.Eval(activation)
+			if err != nil {
+				t.Fatalf("Eval() = %v", err)
+			}
+			if got := types.IsUnknown(out); got != tc.wantUnknown {
+				t.Fatalf("Eval() = %v, want unknown: %v", out, tc.wantUnknown)
+			}
+			if !tc.wantUnknown {
+				return
+			}
+			residual, err := newResidual(env, ast, details, tc.name, unknowns)
+			if err != nil {
+				t.Fatalf("newResidual() = %v", err)
+			}
+			if d := cmp.Diff(tc.wantResidual, residual); d != "" {
+				t.Errorf("newResidual() diff (-want, +got): %s", d)
+			}
+		})
+	}
+}
+
+func TestCompilePartialSyntaxError(t *testing.T) {
+	env, err := cel.NewEnv()
+	if err != nil {
+		t.Fatalf("cel.NewEnv() = %v", err)
+	}
+	if _, _, iss := celenv.CompilePartial(env, "missing ==", map[string]struct{}{}); iss.Err() == nil {
+		t.Error("celenv.CompilePartial() succeeded, want syntax error")
+	}
+}
+
+func TestCompilePartialUndeclaredFunction(t *testing.T) {
+	env, err := cel.NewEnv()
+	if err != nil {
+		t.Fatalf("cel.NewEnv() = %v", err)
+	}
+	unknowns := map[string]struct{}{}
+	_, _, iss := celenv.CompilePartial(env, "foo(missing)", unknowns)
+	if iss.Err() == nil {
+		t.Fatal("celenv.CompilePartial() succeeded, want undeclared function error")
+	}
+	if want := "undeclared reference to 'foo'"; !strings.Contains(iss.Err().Error(), want) {
+		t.Errorf("celenv.CompilePartial() = %v, want error containing %q", iss.Err(), want)
+	}
+	if _, ok := unknowns["foo"]; ok {
+		t.Errorf("celenv.CompilePartial() added the function foo to the unknowns %v", unknowns)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/pkg/apis"
+)
+
+const (
+	taskStatusSucceeded = "Succeeded"
+	taskStatusFailed    = "Failed"
+	taskStatusRunning   = "Running"
+	taskStatusSkipped   = "Skipped"
+)
+
+// wantsTasks returns whether the Run opted in to reading the other tasks of its PipelineRun.
+func wantsTasks(run metav1.Object) bool {
+	return run.GetAnnotations()[variablestores.TasksAnnotationKey] == "true"
+}
+
+// getTasks returns the TaskRuns and Runs of the PipelineRun owning the Run, keyed by pipeline task
+// name, as exposed to expressions through the `tasks` variable. For example:
+//
+//	tasks['build'].results['digest']
+//	tasks['test'].status == 'Succeeded'
+func (r *Reconciler) getTasks(run metav1.Object, pipelineRun *v1beta1.PipelineRun) (map[string]interface{}, error) {
+	tasks := map[string]interface{}{}
+	if pipelineRun == nil {
+		return tasks, nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{
+		pipeline.GroupName + pipeline.PipelineRunLabelKey: pipelineRun.Name,
+	})
+
+	taskRuns, err := r.taskRunLister.TaskRuns(run.GetNamespace()).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, tr := range taskRuns {
+		pipelineTask, ok := tr.Labels[pipeline.GroupName+pipeline.PipelineTaskLabelKey]
+		if !ok {
+			continue
+		}
+		results := make(map[string]string, len(tr.Status.TaskRunResults))
+		for _, result := range tr.Status.TaskRunResults {
+			results[result.Name] = result.Value
+		}
+		tasks[pipelineTask] = taskMetadata(tr.Name, results, tr.Status.GetCondition(apis.ConditionSucceeded))
+	}
+
+	runs, err := r.runLister.Runs(run.GetNamespace()).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, sibling := range runs {
+		if sibling.UID == run.GetUID() {
+			continue
+		}
+		pipelineTask, ok := sibling.Labels[pipeline.GroupName+pipeline.PipelineTaskLabelKey]
+		if !ok {
+			continue
+		}
+		results := make(map[string]string, len(sibling.Status.Results))
+		for _, result := range sibling.Status.Results {
+			results[result.Name] = result.Value
+		}
+		tasks[pipelineTask] = taskMetadata(sibling.Name, results, sibling.Status.GetCondition(apis.ConditionSucceeded))
+	}
+
+	// The tasks skipped because of their when expressions have neither a TaskRun nor a Run
+	for _, skipped := range pipelineRun.Status.SkippedTasks {
+		tasks[skipped.Name] = map[string]interface{}{
+			"name":    "",
+			"results": map[string]string{},
+			"status":  taskStatusSkipped,
+			"reason":  "",
+		}
+	}
+
+	return tasks, nil
+}
+
+func taskMetadata(name string, results map[string]string, condition *apis.Condition) map[string]interface{} {
+	status, reason := taskStatusRunning, ""
+	if condition != nil {
+		reason = condition.Reason
+		if condition.IsTrue() {
+			status = taskStatusSucceeded
+		} else if condition.IsFalse() {
+			status = taskStatusFailed
+		}
+	}
+
+	return map[string]interface{}{
+		"name":    name,
+		"results": results,
+		"status":  status,
+		"reason":  reason,
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	listersalpha "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1alpha1"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+// taskLabels returns the labels Tekton sets on the TaskRuns and Runs of the pipeline task of the PipelineRun.
+func taskLabels(pipelineRun, pipelineTask string) map[string]string {
+	return map[string]string{"tekton.dev/pipelineRun": pipelineRun, "tekton.dev/pipelineTask": pipelineTask}
+}
+
+func TestReconcileTasks(t *testing.T) {
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+
+	pipelineRuns := newIndexer()
+	pipelineRun := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr", Namespace: "default"}}
+	pipelineRun.Status.SkippedTasks = []v1beta1.SkippedTask{{Name: "notify"}}
+	taskRuns := newIndexer()
+	build := &v1beta1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-build", Namespace: "default", Labels: taskLabels("pr", "build")}}
+	build.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionTrue, Reason: "Succeeded"})
+	build.Status.TaskRunResults = []v1beta1.TaskRunResult{{Name: "digest", Value: "sha256:abc"}}
+	test := &v1beta1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-test", Namespace: "default", Labels: taskLabels("pr", "test")}}
+	test.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Reason: "Failed"})
+	lint := &v1beta1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "pr-lint", Namespace: "default", Labels: taskLabels("pr", "lint")}}
+	other := &v1beta1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "other-scan", Namespace: "default", Labels: taskLabels("other", "scan")}}
+	runs := newIndexer()
+	approve := &v1alpha1.Run{ObjectMeta: metav1.ObjectMeta{Name: "pr-approve", Namespace: "default", UID: "approve-uid", Labels: taskLabels("pr", "approve")}}
+	approve.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionTrue})
+	approve.Status.Results = []v1alpha1.RunResult{{Name: "approver", Value: "alice"}}
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "pr-gate",
+			Namespace:       "default",
+			UID:             "gate-uid",
+			Labels:          taskLabels("pr", "gate"),
+			Annotations:     map[string]string{"custom.tekton.dev/tasks": "true"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: "pr"}},
+		},
+		Spec: v1alpha1.RunSpec{
+			Ref: &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore"},
+			Params: []v1beta1.Param{
+				stringParam("digest", "tasks['build'].results['digest']"),
+				stringParam("build", "tasks['build'].status + '/' + tasks['build'].reason + '/' + tasks['build'].name"),
+				stringParam("test", "tasks['test'].status + '/' + tasks['test'].reason"),
+				stringParam("lint", "tasks['lint'].status"),
+				stringParam("notify", "tasks['notify'].status"),
+				stringParam("approver", "tasks['approve'].results['approver']"),
+				stringParam("others", "'scan' in tasks || 'gate' in tasks"),
+			},
+		},
+	}
+	for indexer, objs := range map[cache.Indexer][]interface{}{
+		pipelineRuns: {pipelineRun},
+		taskRuns:     {build, test, lint, other},
+		runs:         {approve, run},
+	} {
+		for _, obj := range objs {
+			if err := indexer.Add(obj); err != nil {
+				t.Fatalf("Add() = %v", err)
+			}
+		}
+	}
+	r := &Reconciler{
+		variablestoreClientSet: fakevariableclientset.NewSimpleClientset(),
+		pipelineRunLister:      listers.NewPipelineRunLister(pipelineRuns),
+		taskRunLister:          listers.NewTaskRunLister(taskRuns),
+		runLister:              listersalpha.NewRunLister(runs),
+	}
+
+	run.Status.InitializeConditions()
+	if err := r.reconcile(context.Background(), v1alpha1Run{run}); err != nil {
+		t.Fatalf("reconcile() = %v", err)
+	}
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Fatalf("reconcile() set the condition %v, want success", c)
+	}
+	for name, want := range map[string]string{
+		"digest":   "sha256:abc",
+		"build":    "Succeeded/Succeeded/pr-build",
+		"test":     "Failed/Failed",
+		"lint":     "Running",
+		"notify":   "Skipped",
+		"approver": "alice",
+		"others":   "false",
+	} {
+		if got := runResult(run, name); got != want {
+			t.Errorf("result %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReconcileTasksNotOptedIn(t *testing.T) {
+	// Without the annotation, the `tasks` variable isn't declared
+	run, _ := reconcileRun(t, nil, nil, stringParam("status", "tasks['build'].status"))
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsFalse() || c.Reason != variablestorev1alpha1.ReasonSyntaxError.String() {
+		t.Errorf("reconcile() set the condition %v, want a syntax error", c)
+	}
+}
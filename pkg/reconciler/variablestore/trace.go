@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/parser"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+	"github.com/vincentpli/cel-tekton/pkg/celext"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// wantsTrace returns whether the Run turned on the tracing of the evaluation of its CEL expressions.
+func wantsTrace(run metav1.Object) bool {
+	return run.GetAnnotations()[variablestores.TraceAnnotationKey] == "true"
+}
+
+// newTrace describes how the CEL expression of the param was evaluated: the values of its sub-expressions
+// and the VariableStore variables it references. storeVars maps the names under which the VariableStore variables
+// are referenced in expressions to their names. budget is how many bytes of sub-expressions and values the
+// traces of the Run could still hold: the steps past it are left out and the trace is marked truncated.
+func newTrace(name string, ast *cel.Ast, details *cel.EvalDetails, storeVars map[string]string, budget *int) (variablestorev1alpha1.Trace, error) {
+	trace := variablestorev1alpha1.Trace{Name: name}
+
+	exprs := map[int64]*exprpb.Expr{}
+	read := map[string]struct{}{}
+	celext.Walk(ast.Expr(), func(e *exprpb.Expr) {
+		exprs[e.GetId()] = e
+		if variable, ok := readVar(e, storeVars); ok {
+			read[variable] = struct{}{}
+		}
+	})
+
+	if details != nil && details.State() != nil {
+		state := details.State()
+		ids := state.IDs()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			e, ok := exprs[id]
+			if !ok || e.GetConstExpr() != nil {
+				continue
+			}
+			val, ok := state.Value(id)
+			if !ok {
+				continue
+			}
+			expression, err := parser.Unparse(e, ast.SourceInfo())
+			if err != nil {
+				return trace, err
+			}
+			value := formatValue(val)
+			size := len(expression) + len(value)
+			if size > *budget {
+				trace.Truncated = true
+				break
+			}
+			*budget -= size
+			trace.Steps = append(trace.Steps, variablestorev1alpha1.TraceStep{
+				Expression: expression,
+				Value:      value,
+			})
+		}
+	}
+
+	for variable := range read {
+		trace.Vars = append(trace.Vars, variable)
+	}
+	sort.Strings(trace.Vars)
+	return trace, nil
+}
+
+// readVar returns the name of the VariableStore variable referenced by the expression, if any: either through its
+// identifier, e.g. `job_priority`, or through the vars map, e.g. `vars.job_priority` or `vars['is-red']`.
+func readVar(e *exprpb.Expr, storeVars map[string]string) (string, bool) {
+	switch e.GetExprKind().(type) {
+	case *exprpb.Expr_IdentExpr:
+		variable, ok := storeVars[e.GetIdentExpr().GetName()]
+		return variable, ok
+	case *exprpb.Expr_SelectExpr:
+		sel := e.GetSelectExpr()
+		if sel.GetOperand().GetIdentExpr().GetName() == celenv.VarsVar {
+			return lookupVar(sel.GetField(), storeVars)
+		}
+	case *exprpb.Expr_CallExpr:
+		call := e.GetCallExpr()
+		if call.GetFunction() == operators.Index && len(call.GetArgs()) == 2 &&
+			call.GetArgs()[0].GetIdentExpr().GetName() == celenv.VarsVar {
+			if key, ok := call.GetArgs()[1].GetConstExpr().GetConstantKind().(*exprpb.Constant_StringValue); ok {
+				return lookupVar(key.StringValue, storeVars)
+			}
+		}
+	}
+	return "", false
+}
+
+// lookupVar returns whether the name is the one of a VariableStore variable.
+func lookupVar(name string, storeVars map[string]string) (string, bool) {
+	for _, variable := range storeVars {
+		if variable == name {
+			return variable, true
+		}
+	}
+	return "", false
+}
+
+// formatValue returns a human readable representation of a CEL value.
+func formatValue(val ref.Val) string {
+	switch {
+	case types.IsUnknown(val):
+		return "<unknown>"
+	case types.IsError(val):
+		return fmt.Sprintf("<error: %v>", val)
+	}
+	if str, ok := val.ConvertToType(types.StringType).(types.String); ok {
+		return string(str)
+	}
+	return fmt.Sprintf("%v", val.Value())
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/go-cmp/cmp"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+)
+
+func TestNewTrace(t *testing.T) {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewVar("job_priority", decls.Any),
+		decls.NewVar("is_red", decls.Any),
+		decls.NewVar(celenv.VarsVar, decls.NewMapType(decls.String, decls.Dyn)),
+	))
+	if err != nil {
+		t.Fatalf("cel.NewEnv() = %v", err)
+	}
+	ast, iss := env.Compile("job_priority == 'high' && vars['is-red'] == 'true'")
+	if iss.Err() != nil {
+		t.Fatalf("Compile() = %v", iss.Err())
+	}
+	prg, err := env.Program(ast, cel.EvalOptions(cel.OptTrackState))
+	if err != nil {
+		t.Fatalf("Program() = %v", err)
+	}
+	_, details, err := prg.Eval(map[string]interface{}{
+		"job_priority": "normal",
+		"is_red":       "true",
+		celenv.VarsVar: map[string]interface{}{"job_priority": "normal", "is-red": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Eval() = %v", err)
+	}
+
+	storeVars := map[string]string{"job_priority": "job_priority", "is_red": "is-red"}
+	budget := variablestorev1alpha1.MaxTraceSize
+	got, err := newTrace("alert", ast, details, storeVars, &budget)
+	if err != nil {
+		t.Fatalf("newTrace() = %v", err)
+	}
+	want := variablestorev1alpha1.Trace{
+		Name: "alert",
+		Steps: []variablestorev1alpha1.TraceStep{
+			{Expression: "job_priority", Value: "normal"},
+			{Expression: `job_priority == "high"`, Value: "false"},
+			{Expression: `job_priority == "high" && vars["is-red"] == "true"`, Value: "false"},
+		},
+		Vars: []string{"is-red", "job_priority"},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("newTrace() diff (-want, +got): %s", d)
+	}
+	if spent := variablestorev1alpha1.MaxTraceSize - budget; spent != 100 {
+		t.Errorf("newTrace() spent %d bytes of the budget, want 100", spent)
+	}
+
+	// The steps past the budget are left out
+	budget = 45
+	got, err = newTrace("alert", ast, details, storeVars, &budget)
+	if err != nil {
+		t.Fatalf("newTrace() = %v", err)
+	}
+	want.Steps = want.Steps[:2]
+	want.Truncated = true
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("newTrace() diff (-want, +got): %s", d)
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	"github.com/google/cel-go/common/types"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	variableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned"
+	variablestorelisters "github.com/vincentpli/cel-tekton/pkg/client/listers/variablestores/v1alpha1"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+)
+
+// wantsTransaction returns whether the Run opts in to staging its writes in the overlay of its PipelineRun.
+func wantsTransaction(run metav1.Object) bool {
+	return run.GetAnnotations()[variablestores.TransactionAnnotationKey] == "true"
+}
+
+// getTransaction returns the reference to the PipelineRun in whose overlay the Run stages its writes, and
+// whether it stages them. The annotation is propagated from the PipelineRun to all its Runs, so the Runs which
+// don't reference a VariableStore, e.g. the Runs of the CEL custom task, ignore it.
+func getTransaction(run customRun) (metav1.OwnerReference, bool) {
+	if !wantsTransaction(run) || !referencesStore(run) {
+		return metav1.OwnerReference{}, false
+	}
+	return pipelineRunOwner(run)
+}
+
+func validateTransaction(run customRun) (errs *apis.FieldError) {
+	if !wantsTransaction(run) || !referencesStore(run) {
+		return nil
+	}
+	if _, ok := pipelineRunOwnerName(run); !ok {
+		errs = errs.Also(apis.ErrInvalidValue("only the Runs of a PipelineRun can stage their writes in its overlay",
+			variablestores.TransactionAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+// isOverlayOf returns whether the overlay is the overlay of the PipelineRun of the name and UID, rather than of
+// a deleted PipelineRun of the same name. The overlays staged before they recorded the UID of their PipelineRun,
+// or looked up with no UID, are matched by name only.
+func isOverlayOf(overlay variablestorev1alpha1.Overlay, pipelineRun string, uid ktypes.UID) bool {
+	if overlay.PipelineRun != pipelineRun {
+		return false
+	}
+	return overlay.UID == "" || uid == "" || overlay.UID == uid
+}
+
+// findOverlay returns the index of the overlay of the PipelineRun, or -1 when there is none.
+func findOverlay(overlays []variablestorev1alpha1.Overlay, pipelineRun string, uid ktypes.UID) int {
+	for i, overlay := range overlays {
+		if isOverlayOf(overlay, pipelineRun, uid) {
+			return i
+		}
+	}
+	return -1
+}
+
+// getOverlay returns the overlay of the PipelineRun, which is added when there is none. The overlay left behind
+// by a deleted PipelineRun of the same name is replaced, discarding its writes.
+func getOverlay(spec *variablestorev1alpha1.VariableStoreSpec, owner metav1.OwnerReference) *variablestorev1alpha1.Overlay {
+	i := findOverlay(spec.Overlays, owner.Name, "")
+	switch {
+	case i < 0:
+		spec.Overlays = append(spec.Overlays, variablestorev1alpha1.Overlay{PipelineRun: owner.Name, UID: owner.UID})
+		i = len(spec.Overlays) - 1
+	case !isOverlayOf(spec.Overlays[i], owner.Name, owner.UID):
+		spec.Overlays[i] = variablestorev1alpha1.Overlay{PipelineRun: owner.Name, UID: owner.UID}
+	}
+	return &spec.Overlays[i]
+}
+
+// stage returns the write, applied to the overlay as the variable, as staged in the overlay: with the value
+// of the variable for Set, and else with the operand of the operation, which is applied again on commit.
+func stage(w write, variable variablestorev1alpha1.Var) (variablestorev1alpha1.StagedWrite, error) {
+	staged := variablestorev1alpha1.StagedWrite{Name: variable.Name, Operation: w.operation}
+	switch w.operation {
+	case variablestorev1alpha1.OperationAdd:
+		switch value := w.value.(type) {
+		case types.Int:
+			staged.Value, staged.Type = strconv.FormatInt(int64(value), 10), variablestorev1alpha1.VarTypeInt
+		case types.Double:
+			staged.Value, staged.Type = strconv.FormatFloat(float64(value), 'g', -1, 64), variablestorev1alpha1.VarTypeDouble
+		}
+	case variablestorev1alpha1.OperationAppend, variablestorev1alpha1.OperationInsert, variablestorev1alpha1.OperationRemove:
+		v, err := w.value.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+		if err != nil {
+			return staged, err
+		}
+		operand, err := json.Marshal(v.(*structpb.Value).AsInterface())
+		if err != nil {
+			return staged, err
+		}
+		staged.Value = string(operand)
+	default:
+		staged.Operation = variablestorev1alpha1.OperationSet
+		staged.Value, staged.Type = variable.Value, variable.Type
+	}
+	return staged, nil
+}
+
+// replay applies the staged write to the variables, and returns them.
+func replay(vars []variablestorev1alpha1.Var, staged variablestorev1alpha1.StagedWrite) ([]variablestorev1alpha1.Var, error) {
+	w := write{param: staged.Name, variable: variablestorev1alpha1.Var{Name: staged.Name}, operation: staged.Operation}
+	switch staged.Operation {
+	case variablestorev1alpha1.OperationSet:
+		return setVar(vars, variablestorev1alpha1.Var{Name: staged.Name, Value: staged.Value, Type: staged.Type}), nil
+	case variablestorev1alpha1.OperationAdd:
+		if staged.Type == variablestorev1alpha1.VarTypeDouble {
+			f, err := strconv.ParseFloat(staged.Value, 64)
+			if err != nil {
+				return vars, err
+			}
+			w.value = types.Double(f)
+			break
+		}
+		i, err := strconv.ParseInt(staged.Value, 10, 64)
+		if err != nil {
+			return vars, err
+		}
+		w.value = types.Int(i)
+	default:
+		var operand interface{}
+		if err := json.Unmarshal([]byte(staged.Value), &operand); err != nil {
+			return vars, err
+		}
+		w.value = types.DefaultTypeAdapter.NativeToValue(operand)
+	}
+	vars, _, err := apply(vars, nil, w)
+	return vars, err
+}
+
+// commit returns the variables of the VariableStore with the writes staged in the overlay applied again, so
+// that the writes to the same variables committed since are kept. The overlays staged no writes before the
+// writes were staged, their variables overwrite the variables. A write which can't be applied again, e.g.
+// because the variable it adds to was set to a string since, overwrites the variable with the value the
+// Runs of the PipelineRun saw.
+func commit(ctx context.Context, vars []variablestorev1alpha1.Var, overlay variablestorev1alpha1.Overlay) []variablestorev1alpha1.Var {
+	logger := logging.FromContext(ctx)
+	if len(overlay.Writes) == 0 {
+		for _, variable := range overlay.Vars {
+			vars = setVar(vars, variable)
+		}
+		return vars
+	}
+	for _, staged := range overlay.Writes {
+		replayed, err := replay(vars, staged)
+		if err == nil {
+			vars = replayed
+			continue
+		}
+		logger.Errorf("Couldn't apply the write of %s staged by PipelineRun %s again, overwriting it: %v", staged.Name, overlay.PipelineRun, err)
+		if contain, index := containsParam(staged.Name, overlay.Vars); contain {
+			vars = setVar(vars, overlay.Vars[index])
+		}
+	}
+	return vars
+}
+
+// visibleVars returns the variables of the VariableStore the Run sees: for a transactional Run, the variables
+// overlaid with the ones its PipelineRun wrote.
+func visibleVars(run customRun, store *variablestorev1alpha1.VariableStore) []variablestorev1alpha1.Var {
+	owner, ok := getTransaction(run)
+	if !ok {
+		return store.Spec.Vars
+	}
+	i := findOverlay(store.Spec.Overlays, owner.Name, owner.UID)
+	if i < 0 {
+		return store.Spec.Vars
+	}
+	vars := append([]variablestorev1alpha1.Var{}, store.Spec.Vars...)
+	for _, variable := range store.Spec.Overlays[i].Vars {
+		vars = setVar(vars, variable)
+	}
+	return vars
+}
+
+// setVar returns the variables with the variable, replacing the variable of the same name if any.
+func setVar(vars []variablestorev1alpha1.Var, variable variablestorev1alpha1.Var) []variablestorev1alpha1.Var {
+	if contain, index := containsParam(variable.Name, vars); contain {
+		vars[index] = variable
+		return vars
+	}
+	return append(vars, variable)
+}
+
+// TransactionReconciler commits the overlays of the PipelineRuns which succeeded to the variables of their
+// VariableStores, and discards the overlays of the ones which failed, were cancelled or were deleted, even if
+// recreated with the same name since.
+type TransactionReconciler struct {
+	variablestoreClientSet variableclientset.Interface
+
+	pipelineRunLister   listers.PipelineRunLister
+	variablestoreLister variablestorelisters.VariableStoreLister
+}
+
+// Check that our TransactionReconciler implements controller.Reconciler
+var _ controller.Reconciler = (*TransactionReconciler)(nil)
+
+// NewTransactionReconciler returns a TransactionReconciler updating the VariableStores with the client, and
+// looking up the PipelineRuns and the VariableStores with the listers.
+func NewTransactionReconciler(
+	client variableclientset.Interface,
+	pipelineRunLister listers.PipelineRunLister,
+	variablestoreLister variablestorelisters.VariableStoreLister,
+) *TransactionReconciler {
+	return &TransactionReconciler{
+		variablestoreClientSet: client,
+		pipelineRunLister:      pipelineRunLister,
+		variablestoreLister:    variablestoreLister,
+	}
+}
+
+// Reconcile implements controller.Reconciler, the key being the key of a PipelineRun.
+func (r *TransactionReconciler) Reconcile(ctx context.Context, key string) error {
+	logger := logging.FromContext(ctx)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		logger.Errorf("Invalid PipelineRun key %s: %v", key, err)
+		return nil
+	}
+
+	// The overlays of a PipelineRun which isn't found are discarded, whatever their UID
+	var uid ktypes.UID
+	done, commit := true, false
+	pipelineRun, err := r.pipelineRunLister.PipelineRuns(namespace).Get(name)
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		uid = pipelineRun.UID
+		done = pipelineRun.IsDone()
+		commit = done && pipelineRun.Status.GetCondition(apis.ConditionSucceeded).IsTrue()
+	}
+
+	stores, err := r.variablestoreLister.VariableStores(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, store := range stores {
+		i := findOverlay(store.Spec.Overlays, name, "")
+		if i < 0 {
+			continue
+		}
+		// The overlay of a deleted PipelineRun of the same name is discarded rather than committed
+		overlay := store.Spec.Overlays[i]
+		current := isOverlayOf(overlay, name, uid)
+		if current && !done {
+			continue
+		}
+		if err := r.close(ctx, store, name, overlay.UID, commit && current); err != nil {
+			logger.Errorf("Couldn't close the overlay of PipelineRun %s/%s in VariableStore %s: %v", namespace, name, store.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// close removes the overlay of the PipelineRun of the UID from the VariableStore, and commits its writes to the
+// variables of the VariableStore in the same update when committing is true. On conflict, the writes are
+// committed again to the latest variables.
+func (r *TransactionReconciler) close(ctx context.Context, store *variablestorev1alpha1.VariableStore, pipelineRun string, uid ktypes.UID, committing bool) error {
+	logger := logging.FromContext(ctx)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		i := findOverlay(store.Spec.Overlays, pipelineRun, uid)
+		if i < 0 {
+			return nil
+		}
+
+		updated := store.DeepCopy()
+		if committing {
+			updated.Spec.Vars = commit(ctx, updated.Spec.Vars, updated.Spec.Overlays[i])
+		}
+		updated.Spec.Overlays = append(updated.Spec.Overlays[:i], updated.Spec.Overlays[i+1:]...)
+
+		_, err := r.variablestoreClientSet.CustomV1alpha1().VariableStores(store.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			latest, getErr := r.variablestoreClientSet.CustomV1alpha1().VariableStores(store.Namespace).Get(ctx, store.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			store = latest
+			return err
+		}
+		if err == nil {
+			if committing {
+				logger.Infof("Committed the overlay of PipelineRun %s/%s to VariableStore %s", store.Namespace, pipelineRun, store.Name)
+			} else {
+				logger.Infof("Discarded the overlay of PipelineRun %s/%s in VariableStore %s", store.Namespace, pipelineRun, store.Name)
+			}
+		}
+		return err
+	})
+}
@@ -0,0 +1,352 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	variablestorelisters "github.com/vincentpli/cel-tekton/pkg/client/listers/variablestores/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+// reconcileTransactional reconciles the transactional Run called name, owned by the PipelineRun, and returns it.
+func reconcileTransactional(t *testing.T, r *Reconciler, name, pipelineRun string, params ...v1beta1.Param) *v1alpha1.Run {
+	t.Helper()
+	run := &v1alpha1.Run{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			UID:             ktypes.UID(name),
+			Annotations:     map[string]string{"custom.tekton.dev/transaction": "true", "custom.tekton.dev/operations": "count=Add"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "PipelineRun", Name: pipelineRun, UID: ktypes.UID(pipelineRun)}},
+		},
+		Spec: v1alpha1.RunSpec{
+			Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+			Params: params,
+		},
+	}
+	if pipelineRun == "" {
+		run.OwnerReferences = nil
+	}
+	run.Status.InitializeConditions()
+	if err := r.reconcile(context.Background(), v1alpha1Run{run}); err != nil {
+		t.Fatalf("reconcile(%s) = %v", name, err)
+	}
+	return run
+}
+
+func TestReconcileTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{Vars: []variablestorev1alpha1.Var{
+			{Name: "count", Value: "1", Type: variablestorev1alpha1.VarTypeInt},
+		}},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, name := range []string{"pr-1", "pr-2"} {
+		if err := indexer.Add(&v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: ktypes.UID(name)}}); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	r := &Reconciler{variablestoreClientSet: client, pipelineRunLister: listers.NewPipelineRunLister(indexer)}
+
+	// The writes of pr-1 are staged in its overlay, which only its Runs see
+	for _, tc := range []struct {
+		run, pipelineRun, want string
+	}{
+		{run: "count-1", pipelineRun: "pr-1", want: "1"},
+		{run: "count-2", pipelineRun: "pr-1", want: "2"},
+		{run: "count-3", pipelineRun: "pr-2", want: "1"},
+	} {
+		run := reconcileTransactional(t, r, tc.run, tc.pipelineRun, stringParam("seen", "vars['count']"), stringParam("count", "1"))
+		if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+			t.Fatalf("%s set the condition %v, want success", tc.run, c)
+		}
+		if seen := runResult(run, "seen"); seen != tc.want {
+			t.Errorf("%s saw count = %s, want %s", tc.run, seen, tc.want)
+		}
+	}
+
+	got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	set := func(name, value string) variablestorev1alpha1.StagedWrite {
+		return variablestorev1alpha1.StagedWrite{Name: name, Operation: variablestorev1alpha1.OperationSet, Value: value, Type: variablestorev1alpha1.VarTypeString}
+	}
+	increment := variablestorev1alpha1.StagedWrite{Name: "count", Operation: variablestorev1alpha1.OperationAdd, Value: "1", Type: variablestorev1alpha1.VarTypeInt}
+	want := variablestorev1alpha1.VariableStoreSpec{
+		Vars: []variablestorev1alpha1.Var{{Name: "count", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+		Overlays: []variablestorev1alpha1.Overlay{
+			{PipelineRun: "pr-1", UID: "pr-1", Vars: []variablestorev1alpha1.Var{
+				{Name: "seen", Value: "2", Type: variablestorev1alpha1.VarTypeString},
+				{Name: "count", Value: "3", Type: variablestorev1alpha1.VarTypeInt},
+			}, Writes: []variablestorev1alpha1.StagedWrite{set("seen", "1"), increment, set("seen", "2"), increment}},
+			{PipelineRun: "pr-2", UID: "pr-2", Vars: []variablestorev1alpha1.Var{
+				{Name: "seen", Value: "1", Type: variablestorev1alpha1.VarTypeString},
+				{Name: "count", Value: "2", Type: variablestorev1alpha1.VarTypeInt},
+			}, Writes: []variablestorev1alpha1.StagedWrite{set("seen", "1"), increment}},
+		},
+	}
+	got.Spec.Applied = nil
+	if d := cmp.Diff(want, got.Spec); d != "" {
+		t.Errorf("VariableStore (-want, +got): %s", d)
+	}
+
+	// Only the Runs of a PipelineRun can stage their writes
+	run := reconcileTransactional(t, r, "count-4", "", stringParam("count", "1"))
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsFalse() || c.Reason != variablestorev1alpha1.ReasonFailedValidation.String() {
+		t.Errorf("count-4 set the condition %v, want it invalid", c)
+	}
+
+	// Both PipelineRuns succeed, the increments of both are committed
+	stores := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	tr := &TransactionReconciler{
+		variablestoreClientSet: client,
+		pipelineRunLister:      listers.NewPipelineRunLister(indexer),
+		variablestoreLister:    variablestorelisters.NewVariableStoreLister(stores),
+	}
+	for _, name := range []string{"pr-1", "pr-2"} {
+		pipelineRun := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: ktypes.UID(name)}}
+		pipelineRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionTrue})
+		if err := indexer.Update(pipelineRun); err != nil {
+			t.Fatalf("Update() = %v", err)
+		}
+		latest, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get() = %v", err)
+		}
+		if err := stores.Add(latest); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+		if err := tr.Reconcile(ctx, "default/"+name); err != nil {
+			t.Fatalf("Reconcile(%s) = %v", name, err)
+		}
+	}
+	got, err = client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	wantVars := []variablestorev1alpha1.Var{
+		{Name: "count", Value: "4", Type: variablestorev1alpha1.VarTypeInt},
+		{Name: "seen", Value: "1", Type: variablestorev1alpha1.VarTypeString},
+	}
+	if d := cmp.Diff(wantVars, got.Spec.Vars); d != "" {
+		t.Errorf("variables (-want, +got): %s", d)
+	}
+	if len(got.Spec.Overlays) != 0 {
+		t.Errorf("overlays = %v, want none", got.Spec.Overlays)
+	}
+}
+
+func TestReconcileTransactionRecreated(t *testing.T) {
+	// The overlay left behind by a deleted PipelineRun of the same name is neither seen nor extended by the
+	// Runs of the recreated PipelineRun, but replaced
+	ctx := context.Background()
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{
+			Vars: []variablestorev1alpha1.Var{{Name: "count", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+			Overlays: []variablestorev1alpha1.Overlay{{
+				PipelineRun: "pr",
+				UID:         "deleted-uid",
+				Vars:        []variablestorev1alpha1.Var{{Name: "count", Value: "10", Type: variablestorev1alpha1.VarTypeInt}},
+				Writes:      []variablestorev1alpha1.StagedWrite{{Name: "count", Operation: variablestorev1alpha1.OperationAdd, Value: "9", Type: variablestorev1alpha1.VarTypeInt}},
+			}},
+		},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if err := indexer.Add(&v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "pr", Namespace: "default", UID: "pr"}}); err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+	r := &Reconciler{variablestoreClientSet: client, pipelineRunLister: listers.NewPipelineRunLister(indexer)}
+
+	run := reconcileTransactional(t, r, "count", "pr", stringParam("seen", "vars['count']"), stringParam("count", "1"))
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Fatalf("reconcile() set the condition %v, want success", c)
+	}
+	if seen := runResult(run, "seen"); seen != "1" {
+		t.Errorf("seen count = %s, want 1", seen)
+	}
+
+	got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	want := []variablestorev1alpha1.Overlay{{
+		PipelineRun: "pr",
+		UID:         "pr",
+		Vars: []variablestorev1alpha1.Var{
+			{Name: "seen", Value: "1", Type: variablestorev1alpha1.VarTypeString},
+			{Name: "count", Value: "2", Type: variablestorev1alpha1.VarTypeInt},
+		},
+		Writes: []variablestorev1alpha1.StagedWrite{
+			{Name: "seen", Operation: variablestorev1alpha1.OperationSet, Value: "1", Type: variablestorev1alpha1.VarTypeString},
+			{Name: "count", Operation: variablestorev1alpha1.OperationAdd, Value: "1", Type: variablestorev1alpha1.VarTypeInt},
+		},
+	}}
+	if d := cmp.Diff(want, got.Spec.Overlays); d != "" {
+		t.Errorf("overlays (-want, +got): %s", d)
+	}
+}
+
+func TestCommitStagedWrites(t *testing.T) {
+	values := map[string]ref.Val{
+		"double": types.Double(0.5),
+		"list":   types.DefaultTypeAdapter.NativeToValue([]interface{}{"b", "c"}),
+		"map":    types.DefaultTypeAdapter.NativeToValue(map[string]interface{}{"eu": "v2"}),
+		"name":   types.String("c"),
+	}
+	writes := []write{
+		{param: "ratio", variable: variablestorev1alpha1.Var{Name: "ratio"}, value: values["double"], operation: variablestorev1alpha1.OperationAdd},
+		{param: "tags", variable: variablestorev1alpha1.Var{Name: "tags"}, value: values["list"], operation: variablestorev1alpha1.OperationAppend},
+		{param: "versions", variable: variablestorev1alpha1.Var{Name: "versions"}, value: values["map"], operation: variablestorev1alpha1.OperationInsert},
+		{param: "users", variable: variablestorev1alpha1.Var{Name: "users"}, value: values["name"], operation: variablestorev1alpha1.OperationRemove},
+		{param: "count", variable: variablestorev1alpha1.Var{Name: "count"}, value: types.Int(1), operation: variablestorev1alpha1.OperationAdd},
+	}
+
+	// The writes are staged in the overlay on top of the variables as they were then
+	before := []variablestorev1alpha1.Var{
+		{Name: "ratio", Value: "1", Type: variablestorev1alpha1.VarTypeDouble},
+		{Name: "tags", Value: `["a"]`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "versions", Value: `{"us":"v1"}`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "users", Value: `["a","c"]`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "count", Value: "1", Type: variablestorev1alpha1.VarTypeInt},
+	}
+	overlay := variablestorev1alpha1.Overlay{PipelineRun: "pr"}
+	for _, w := range writes {
+		var variable variablestorev1alpha1.Var
+		var err error
+		overlay.Vars, variable, err = apply(overlay.Vars, before, w)
+		if err != nil {
+			t.Fatalf("apply(%s) = %v", w.param, err)
+		}
+		staged, err := stage(w, variable)
+		if err != nil {
+			t.Fatalf("stage(%s) = %v", w.param, err)
+		}
+		overlay.Writes = append(overlay.Writes, staged)
+	}
+
+	// The variables were written concurrently since: the writes are applied to them again, except the Add to
+	// the variable set to a string, which is overwritten
+	latest := []variablestorev1alpha1.Var{
+		{Name: "ratio", Value: "2", Type: variablestorev1alpha1.VarTypeDouble},
+		{Name: "tags", Value: `["a","z"]`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "versions", Value: `{"ap":"v0","us":"v1"}`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "users", Value: `["a","c","d"]`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "count", Value: "many", Type: variablestorev1alpha1.VarTypeString},
+	}
+	want := []variablestorev1alpha1.Var{
+		{Name: "ratio", Value: "2.5", Type: variablestorev1alpha1.VarTypeDouble},
+		{Name: "tags", Value: `["a","z","b","c"]`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "versions", Value: `{"ap":"v0","eu":"v2","us":"v1"}`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "users", Value: `["a","d"]`, Type: variablestorev1alpha1.VarTypeString},
+		{Name: "count", Value: "2", Type: variablestorev1alpha1.VarTypeInt},
+	}
+	if d := cmp.Diff(want, commit(context.Background(), latest, overlay)); d != "" {
+		t.Errorf("commit() (-want, +got): %s", d)
+	}
+}
+
+func TestTransactionReconciler(t *testing.T) {
+	ctx := context.Background()
+	overlay := func(pipelineRun, value string) variablestorev1alpha1.Overlay {
+		return variablestorev1alpha1.Overlay{PipelineRun: pipelineRun, UID: ktypes.UID(pipelineRun), Vars: []variablestorev1alpha1.Var{
+			{Name: "count", Value: value, Type: variablestorev1alpha1.VarTypeInt},
+			{Name: pipelineRun, Value: "true", Type: variablestorev1alpha1.VarTypeBool},
+		}}
+	}
+	// The overlay of a PipelineRun deleted and recreated with the same name since, which succeeded
+	recreated := overlay("recreated", "6")
+	recreated.UID = "deleted-uid"
+	store := &variablestorev1alpha1.VariableStore{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+		Spec: variablestorev1alpha1.VariableStoreSpec{
+			Vars: []variablestorev1alpha1.Var{{Name: "count", Value: "1", Type: variablestorev1alpha1.VarTypeInt}},
+			Overlays: []variablestorev1alpha1.Overlay{
+				overlay("succeeded", "2"), overlay("failed", "3"), overlay("running", "4"), overlay("deleted", "5"),
+				recreated,
+			},
+		},
+	}
+	client := fakevariableclientset.NewSimpleClientset(store)
+	stores := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	pipelineRuns := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for name, status := range map[string]corev1.ConditionStatus{
+		"succeeded": corev1.ConditionTrue,
+		"failed":    corev1.ConditionFalse,
+		"running":   corev1.ConditionUnknown,
+		"recreated": corev1.ConditionTrue,
+	} {
+		pipelineRun := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: ktypes.UID(name)}}
+		pipelineRun.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: status})
+		if err := pipelineRuns.Add(pipelineRun); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	r := &TransactionReconciler{
+		variablestoreClientSet: client,
+		pipelineRunLister:      listers.NewPipelineRunLister(pipelineRuns),
+		variablestoreLister:    variablestorelisters.NewVariableStoreLister(stores),
+	}
+
+	for _, pipelineRun := range []string{"succeeded", "failed", "running", "deleted", "recreated"} {
+		// The lister sees the VariableStore as updated by the previous reconciles
+		latest, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get() = %v", err)
+		}
+		if err := stores.Update(latest); err != nil {
+			t.Fatalf("Update() = %v", err)
+		}
+		if err := r.Reconcile(ctx, "default/"+pipelineRun); err != nil {
+			t.Fatalf("Reconcile(%s) = %v", pipelineRun, err)
+		}
+	}
+
+	got, err := client.CustomV1alpha1().VariableStores("default").Get(ctx, "store", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	want := variablestorev1alpha1.VariableStoreSpec{
+		Vars: []variablestorev1alpha1.Var{
+			{Name: "count", Value: "2", Type: variablestorev1alpha1.VarTypeInt},
+			{Name: "succeeded", Value: "true", Type: variablestorev1alpha1.VarTypeBool},
+		},
+		Overlays: []variablestorev1alpha1.Overlay{overlay("running", "4")},
+	}
+	if d := cmp.Diff(want, got.Spec); d != "" {
+		t.Errorf("VariableStore (-want, +got): %s", d)
+	}
+}
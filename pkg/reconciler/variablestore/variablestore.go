@@ -19,6 +19,7 @@ package variablestore
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -26,7 +27,15 @@ import (
 	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	runreconciler "github.com/tektoncd/pipeline/pkg/client/injection/reconciler/pipeline/v1alpha1/run"
 	listersalpha "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1alpha1"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1beta1"
+	celtask "github.com/vincentpli/cel-tekton/pkg/apis/cel"
+	celv1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/cel/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/apis/config"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
 	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	variablestorev1beta1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1beta1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+	"github.com/vincentpli/cel-tekton/pkg/celext"
 	variableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/logging"
@@ -36,9 +45,12 @@ import (
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
 
 	"github.com/tektoncd/pipeline/pkg/reconciler/events"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
 )
 
 // Reconciler implements addressableservicereconciler.Interface for
@@ -48,11 +60,20 @@ type Reconciler struct {
 	// so that we can immediately react to changes tracked resources.
 	Tracker tracker.Interface
 
+	// enqueueAfter re-queues a Run after a delay, e.g. when it times out waiting for its VariableStore
+	enqueueAfter func(key ktypes.NamespacedName, delay time.Duration)
+
+	// stateless is whether the Reconciler reconciles the Runs of the CEL custom task, which never read nor
+	// write a VariableStore, rather than the Runs referencing a VariableStore
+	stateless bool
+
 	//Clientset about resources
 	variablestoreClientSet variableclientset.Interface
 
 	// Listers index properties about resources
-	runLister listersalpha.RunLister
+	runLister         listersalpha.RunLister
+	pipelineRunLister listers.PipelineRunLister
+	taskRunLister     listers.TaskRunLister
 }
 
 // Check that our Reconciler implements Interface
@@ -66,10 +87,8 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, run *v1alpha1.Run) recon
 
 	// Check that the Run references a Exception CRD.  The logic is controller.go should ensure that only this type of Run
 	// is reconciled this controller but it never hurts to do some bullet-proofing.
-	if run.Spec.Ref == nil ||
-		run.Spec.Ref.APIVersion != variablestorev1alpha1.SchemeGroupVersion.String() ||
-		run.Spec.Ref.Kind != "VariableStore" {
-		logger.Errorf("Received control for a Run %s/%s that does not reference a VariableStore custom CRD", run.Namespace, run.Name)
+	if !r.references(run.Spec.Ref) {
+		logger.Errorf("Received control for a Run %s/%s that does not reference a %s custom task", run.Namespace, run.Name, r.kind())
 		return nil
 	}
 
@@ -101,7 +120,7 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, run *v1alpha1.Run) recon
 	beforeCondition := run.Status.GetCondition(apis.ConditionSucceeded)
 
 	// Reconcile the Run
-	if err := r.reconcile(ctx, run); err != nil {
+	if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
 		logger.Errorf("Reconcile error: %v", err.Error())
 		merr = multierror.Append(merr, err)
 	}
@@ -113,140 +132,415 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, run *v1alpha1.Run) recon
 	return merr
 }
 
-func (r *Reconciler) reconcile(ctx context.Context, run *v1alpha1.Run) error {
+func (r *Reconciler) reconcile(ctx context.Context, run customRun) error {
 	logger := logging.FromContext(ctx)
 	variablestore, err := r.getVariableStore(ctx, run)
 	if err != nil {
-		logger.Errorf("Error retrieving VariableStore for Run %s/%s: %s", run.Namespace, run.Name, err)
-		run.Status.MarkRunFailed(variablestorev1alpha1.VariableStoreReasonCouldntGet.String(),
+		logger.Errorf("Error retrieving VariableStore for Run %s/%s: %s", run.GetNamespace(), run.GetName(), err)
+		run.MarkFailed(variablestorev1alpha1.VariableStoreReasonCouldntGet.String(),
 			"Error retrieving VariableStore for Run %s/%s: %s",
-			run.Namespace, run.Name, err)
+			run.GetNamespace(), run.GetName(), err)
 		return nil
 	}
 
-	if err := validate(run); err != nil {
-		logger.Errorf("Run %s/%s is invalid because of %s", run.Namespace, run.Name, err)
-		run.Status.MarkRunFailed(variablestorev1alpha1.ReasonFailedValidation.String(),
+	namePolicy := variablestorev1alpha1.NamePolicyAlias
+	if variablestore != nil {
+		namePolicy = variablestore.Spec.GetNamePolicy()
+	}
+
+	if err := validate(run, namePolicy); err != nil {
+		logger.Errorf("Run %s/%s is invalid because of %s", run.GetNamespace(), run.GetName(), err)
+		run.MarkFailed(variablestorev1alpha1.ReasonFailedValidation.String(),
 			"Run can't be run because it has an invalid spec - %v", err)
 		return nil
 	}
 
-	// Create a program environment configured with the standard library of CEL functions and macros
-	env, err := cel.NewEnv(cel.Declarations())
+	// A Run whose writes were applied already, e.g. before the controller restarted, isn't evaluated again
+	if variablestore != nil {
+		if applied, ok := findApplied(variablestore, run.GetUID()); ok {
+			logger.Infof("The writes of Run %s/%s were applied to VariableStore %s already", run.GetNamespace(), run.GetName(), variablestore.Name)
+			for _, result := range appliedResults(applied) {
+				run.AddResult(result.Name, result.Value)
+			}
+			run.MarkSucceeded(variablestorev1alpha1.ReasonEvaluationSuccess.String(),
+				"The writes of the Run were applied to VariableStore %s already", variablestore.Name)
+			return nil
+		}
+	}
+
+	pipelineRun, err := r.getPipelineRun(run)
+	if errors.IsNotFound(err) {
+		// The PipelineRun which just created the Run may not be in the lister cache yet
+		logger.Infof("PipelineRun of Run %s/%s not found, requeuing: %s", run.GetNamespace(), run.GetName(), err)
+		return err
+	}
+	if err != nil {
+		logger.Errorf("Error retrieving PipelineRun for Run %s/%s: %s", run.GetNamespace(), run.GetName(), err)
+		run.MarkFailed(variablestorev1alpha1.ReasonCouldntGetPipelineRun.String(),
+			"Error retrieving PipelineRun for Run %s/%s: %s",
+			run.GetNamespace(), run.GetName(), err)
+		return nil
+	}
+
+	// Create a program environment configured with the standard library of CEL functions and macros,
+	// plus the functions provided by cel-tekton and the metadata of the Run, unless hidden by variables
+	taken := takenNames(run, variablestore)
+	env, err := celenv.NewEnv(taken)
 	if err != nil {
-		logger.Errorf("Couldn't create a program env with standard library of CEL functions & macros when reconciling Run %s/%s: %v", run.Namespace, run.Name, err)
+		logger.Errorf("Couldn't create a program env with standard library of CEL functions & macros when reconciling Run %s/%s: %v", run.GetNamespace(), run.GetName(), err)
 		return err
 	}
 
-	var runResults []v1alpha1.RunResult
-	contextExpressions := map[string]interface{}{}
+	var writes []write
+	extraFields := &variablestorev1alpha1.RunExtraFields{}
+	defer func() {
+		// Report the residuals and traces however the reconcile ends, they help understanding failures
+		if !extraFields.IsEmpty() {
+			if err := run.EncodeExtraFields(extraFields); err != nil {
+				logger.Errorf("Couldn't encode the extra fields of Run %s/%s: %v", run.GetNamespace(), run.GetName(), err)
+			}
+		}
+	}()
+
+	vars := map[string]interface{}{}
+	storeVars := map[string]string{}
+	contextExpressions := map[string]interface{}{
+		celenv.RunVar:         runMetadata(run),
+		celenv.PipelineRunVar: pipelineRunMetadata(pipelineRun),
+		celenv.VarsVar:        vars,
+	}
+
+	// The other tasks of the PipelineRun are only exposed when the Run opts in
+	if _, hidden := taken[celenv.TasksVar]; wantsTasks(run) && !hidden {
+		tasks, err := r.getTasks(run, pipelineRun)
+		if err != nil {
+			logger.Errorf("Error listing the tasks of the PipelineRun for Run %s/%s: %s", run.GetNamespace(), run.GetName(), err)
+			return err
+		}
+
+		contextExpressions[celenv.TasksVar] = tasks
+		env, err = env.Extend(cel.Declarations(celenv.TasksDecl))
+		if err != nil {
+			logger.Errorf("Tasks could not be add to context env when reconciling Run %s/%s: %v", run.GetNamespace(), run.GetName(), err)
+			return err
+		}
+	}
 
 	// If refrenced VariableStore not null, all variables in that will be the context
 	if variablestore != nil {
-		for _, variable := range variablestore.Spec.Vars {
-			contain, _ := containsVar(variable.Name, run.Spec.Params)
+		for _, variable := range visibleVars(run, variablestore) {
+			vars[variable.Name] = variable.Value
+
+			contain, _ := containsVar(variable.Name, run.GetParams())
 			if contain {
 				continue
 			}
 
-			contextExpressions[variable.Name] = variable.Value
-			env, err = env.Extend(cel.Declarations(decls.NewVar(variable.Name, decls.Any)))
+			// Variables whose names are not valid CEL identifiers are exposed under an alias
+			alias := variablestorev1alpha1.Alias(variable.Name)
+			contextExpressions[alias] = variable.Value
+			storeVars[alias] = variable.Name
+			env, err = env.Extend(cel.Declarations(decls.NewVar(alias, decls.Any)))
 			if err != nil {
-				logger.Errorf("CEL expression %s could not be add to context env when reconciling Run %s/%s: %v", variable.Name, run.Namespace, run.Name, err)
-				run.Status.MarkRunFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+				logger.Errorf("CEL expression %s could not be add to context env when reconciling Run %s/%s: %v", variable.Name, run.GetNamespace(), run.GetName(), err)
+				run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
 					"CEL expression %s could not be add to context env", variable.Name, err)
 				return nil
 			}
 		}
 	}
 
-	for _, param := range run.Spec.Params {
+	// With partial evaluation, missing variables are treated as unknowns rather than failing the Run
+	unknownsPolicy, partial := getUnknownsPolicy(run)
+	unknowns := map[string]struct{}{}
+	var programOptions []cel.ProgramOption
+	if partial {
+		programOptions = append(programOptions, cel.EvalOptions(cel.OptPartialEval, cel.OptTrackState))
+	}
+
+	// With tracing, the values of the sub-expressions are recorded
+	trace := wantsTrace(run)
+	traceBudget := variablestorev1alpha1.MaxTraceSize
+	if trace {
+		programOptions = append(programOptions, cel.EvalOptions(cel.OptTrackState))
+	}
+
+	// The cost of the evaluation is limited, so that a runaway expression can't starve the other Runs
+	limits := config.FromContextOrDefaults(ctx).Limits.ForNamespace(run.GetNamespace())
+	costs := newCostTracker(limits)
+	programOptions = append(programOptions, cel.CustomDecorator(costs.decorator()))
+
+	// A Run waiting for a condition over its VariableStore only evaluates its expressions once it is true
+	if condition, ok := getWaitCondition(run); ok {
+		if ready, err := r.wait(ctx, run, condition, env, contextExpressions, programOptions, costs); !ready || err != nil {
+			return err
+		}
+	}
+
+	// A Run acquiring a lock only evaluates its expressions once it holds it
+	_, acquires := getAcquiredLock(run)
+	_, releases := getReleasedLock(run)
+	if (acquires || releases) && variablestore != nil {
+		store, acquired, err := r.lock(ctx, run, variablestore)
+		if !acquired || err != nil {
+			return err
+		}
+		variablestore = store
+	}
+
+	// evaluate evaluates the CEL expression called name: a param, or an element of an array param. It returns
+	// false when the Run failed because of the expression.
+	evaluate := func(name, expression string) (ref.Val, bool, error) {
 		// Combine the Parse and Check phases CEL program compilation to produce an Ast and associated issues
-		ast, iss := env.Compile(param.Value.StringVal)
+		var ast *cel.Ast
+		var iss *cel.Issues
+		if partial {
+			env, ast, iss = celenv.CompilePartial(env, expression, unknowns)
+		} else {
+			ast, iss = env.Compile(expression)
+		}
 		if iss.Err() != nil {
-			logger.Errorf("CEL expression %s could not be parsed when reconciling Run %s/%s: %v", param.Name, run.Namespace, run.Name, iss.Err())
-			run.Status.MarkRunFailed(variablestorev1alpha1.ReasonSyntaxError.String(),
-				"CEL expression %s could not be parsed", param.Name, iss.Err())
-			return nil
+			logger.Errorf("CEL expression %s could not be parsed when reconciling Run %s/%s: %v", name, run.GetNamespace(), run.GetName(), iss.Err())
+			run.MarkFailed(variablestorev1alpha1.ReasonSyntaxError.String(),
+				"CEL expression %s could not be parsed", name, iss.Err())
+			return nil, false, nil
 		}
 
 		// Generate an evaluable instance of the Ast within the environment
-		prg, err := env.Program(ast)
+		prg, err := env.Program(ast, programOptions...)
 		if err != nil {
-			logger.Errorf("CEL expression %s could not be evaluated when reconciling Run %s/%s: %v", param.Name, run.Namespace, run.Name, err)
-			run.Status.MarkRunFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
-				"CEL expression %s could not be evaluated", param.Name, err)
-			return nil
+			logger.Errorf("CEL expression %s could not be evaluated when reconciling Run %s/%s: %v", name, run.GetNamespace(), run.GetName(), err)
+			run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+				"CEL expression %s could not be evaluated", name, err)
+			return nil, false, nil
 		}
 
 		// Evaluate the CEL expression (Ast)
-		out, _, err := prg.Eval(contextExpressions)
+		var activation interface{} = contextExpressions
+		if partial {
+			activation, err = cel.PartialVars(contextExpressions, unknownPatterns(unknowns)...)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		costs.reset()
+		out, details, err := prg.Eval(activation)
+		if costs.exceeded != "" {
+			logger.Errorf("CEL expression %s exceeded the %s when reconciling Run %s/%s", name, costs.exceeded, run.GetNamespace(), run.GetName())
+			run.MarkFailed(variablestorev1alpha1.ReasonCostLimitExceeded.String(),
+				"CEL expression %s exceeded the %s of namespace %s, expression cost limit: %d, run cost limit: %d",
+				name, costs.exceeded, run.GetNamespace(), limits.ExpressionCostLimit, limits.RunCostLimit)
+			return nil, false, nil
+		}
+		if trace {
+			t, err := newTrace(name, ast, details, storeVars, &traceBudget)
+			if err != nil {
+				logger.Warnf("CEL expression %s could not be traced when reconciling Run %s/%s: %v", name, run.GetNamespace(), run.GetName(), err)
+			}
+			extraFields.Traces = append(extraFields.Traces, t)
+		}
+		if reason, message, ok := celext.AssertionFailure(err); ok {
+			logger.Infof("CEL expression %s failed an assertion when reconciling Run %s/%s: %s: %s", name, run.GetNamespace(), run.GetName(), reason, message)
+			run.MarkFailed(reason, "%s", message)
+			return nil, false, nil
+		}
 		if err != nil {
-			logger.Errorf("CEL expression %s could not be evaluated when reconciling Run %s/%s: %v", param.Name, run.Namespace, run.Name, err)
-			run.Status.MarkRunFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
-				"CEL expression %s could not be evaluated", param.Name, err)
-			return nil
+			logger.Errorf("CEL expression %s could not be evaluated when reconciling Run %s/%s: %v", name, run.GetNamespace(), run.GetName(), err)
+			run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+				"CEL expression %s could not be evaluated", name, err)
+			return nil, false, nil
 		}
 
-		// Evaluation of CEL expression was successful
-		logger.Infof("CEL expression %s evaluated successfully when reconciling Run %s/%s", param.Name, run.Namespace, run.Name)
-		runResults = append(runResults, v1alpha1.RunResult{
-			Name:  param.Name,
-			Value: fmt.Sprintf("%s", out.ConvertToType(types.StringType).Value()),
-		})
-		contextExpressions[param.Name] = fmt.Sprintf("%s", out.ConvertToType(types.StringType).Value())
-		env, err = env.Extend(cel.Declarations(decls.NewVar(param.Name, decls.Any)))
-		if err != nil {
-			logger.Errorf("CEL expression %s could not be add to context env when reconciling Run %s/%s: %v", param.Name, run.Namespace, run.Name, err)
-			run.Status.MarkRunFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
-				"CEL expression %s could not be add to context env", param.Name, err)
-			return nil
+		// The CEL expression references unknown variables, record what remains of it
+		if types.IsUnknown(out) {
+			residual, err := newResidual(env, ast, details, name, unknowns)
+			if err != nil {
+				logger.Errorf("CEL expression %s could not be partially evaluated when reconciling Run %s/%s: %v", name, run.GetNamespace(), run.GetName(), err)
+				run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+					"CEL expression %s could not be partially evaluated: %v", name, err)
+				return nil, false, nil
+			}
+			logger.Infof("CEL expression %s was partially evaluated when reconciling Run %s/%s, unknowns: %v", name, run.GetNamespace(), run.GetName(), residual.Unknowns)
+			extraFields.Residuals = append(extraFields.Residuals, residual)
 		}
+		return out, true, nil
+	}
 
-		//Append calculated variables to VariableStore
-		if variablestore != nil {
-			variable := variablestorev1alpha1.Var{
-				Name:  param.Name,
-				Value: fmt.Sprintf("%s", out.ConvertToType(types.StringType).Value()),
+	arrayParams := getArrayParamsPolicy(run)
+	expanded := getExpandedParams(run)
+	operations := getOperations(run)
+	for _, param := range run.GetParams() {
+		var out ref.Val
+		switch {
+		case param.Value.Type != v1beta1.ParamTypeArray:
+			value, ok, err := evaluate(param.Name, param.Value.StringVal)
+			if !ok {
+				return err
+			}
+			out = value
+		case arrayParams == variablestorev1alpha1.ArrayParamsLiterals:
+			out = types.NewStringList(types.DefaultTypeAdapter, param.Value.ArrayVal)
+		default:
+			// Every element of the array param is a CEL expression, the param is the list of their values
+			elems := make([]ref.Val, 0, len(param.Value.ArrayVal))
+			for i, expression := range param.Value.ArrayVal {
+				value, ok, err := evaluate(fmt.Sprintf("%s[%d]", param.Name, i), expression)
+				if !ok {
+					return err
+				}
+				if types.IsUnknown(value) {
+					out = value
+				}
+				elems = append(elems, value)
 			}
+			if out == nil {
+				out = types.NewRefValList(types.DefaultTypeAdapter, elems)
+			}
+		}
 
-			contain, index := containsParam(param.Name, variablestore.Spec.Vars)
-			if contain {
-				variablestore.Spec.Vars[index] = variable
-			} else {
-				variablestore.Spec.Vars = append(variablestore.Spec.Vars, variable)
+		// The CEL expression references unknown variables, treat the param as unknown for the next expressions
+		alias := variablestorev1alpha1.Alias(param.Name)
+		if types.IsUnknown(out) {
+			if _, declared := unknowns[alias]; declared {
+				continue
 			}
+			unknowns[alias] = struct{}{}
+			env, err = env.Extend(cel.Declarations(decls.NewVar(alias, decls.Dyn)))
+			if err != nil {
+				logger.Errorf("CEL expression %s could not be add to context env when reconciling Run %s/%s: %v", param.Name, run.GetNamespace(), run.GetName(), err)
+				run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+					"CEL expression %s could not be add to context env: %v", param.Name, err)
+				return nil
+			}
+			continue
 		}
 
-	}
+		value, err := resultValue(out)
+		var written []variablestorev1alpha1.Var
+		var values []ref.Val
+		if err == nil {
+			_, expand := expanded[param.Name]
+			written, values, err = outputs(param.Name, out, expand)
+		}
+		if err != nil {
+			logger.Errorf("The value of CEL expression %s could not be written when reconciling Run %s/%s: %v", param.Name, run.GetNamespace(), run.GetName(), err)
+			run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+				"The value of CEL expression %s could not be written: %v", param.Name, err)
+			return nil
+		}
 
-	// Update VariableStore ??? variablestore do not existed
-	if variablestore != nil {
-		_, err = r.variablestoreClientSet.CustomV1alpha1().VariableStores(run.Namespace).Update(ctx, variablestore, metav1.UpdateOptions{})
+		// Evaluation of CEL expression was successful
+		logger.Infof("CEL expression %s evaluated successfully when reconciling Run %s/%s", param.Name, run.GetNamespace(), run.GetName())
+		operation, ok := operations[param.Name]
+		if !ok {
+			operation = variablestorev1alpha1.OperationSet
+		}
+		for i, variable := range written {
+			writes = append(writes, write{param: param.Name, variable: variable, value: values[i], operation: operation})
+		}
+		contextExpressions[alias] = contextValue(out, value)
+		vars[param.Name] = contextExpressions[alias]
+		if _, declared := unknowns[alias]; declared {
+			// Referenced by a previous expression before being evaluated, it is already declared
+			delete(unknowns, alias)
+		} else {
+			env, err = env.Extend(cel.Declarations(decls.NewVar(alias, contextType(out))))
+		}
 		if err != nil {
-			logger.Errorf("Update VariableStore: %s hit excetion: %v", variablestore.Name, err)
-			run.Status.MarkRunFailed(variablestorev1alpha1.VariableStoreReasonUpdateFaild.String(),
-				"Update VariableStore: %s hit excetion: %v", variablestore.Name, err)
+			logger.Errorf("CEL expression %s could not be add to context env when reconciling Run %s/%s: %v", param.Name, run.GetNamespace(), run.GetName(), err)
+			run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+				"CEL expression %s could not be add to context env", param.Name, err)
 			return nil
 		}
 	}
 
-	run.Status.Results = append(run.Status.Results, runResults...)
-	run.Status.MarkRunSucceeded(variablestorev1alpha1.ReasonEvaluationSuccess.String(),
+	if len(extraFields.Residuals) > 0 && unknownsPolicy == variablestorev1alpha1.UnknownsPolicyFail {
+		run.MarkFailed(variablestorev1alpha1.ReasonPartialEvaluation.String(),
+			"%d CEL expressions could only be partially evaluated because of unknown variables", len(extraFields.Residuals))
+		return nil
+	}
+
+	// Write the calculated variables to the VariableStore, the results are the variables as written
+	results := make([]variablestorev1alpha1.Var, 0, len(writes))
+	if variablestore != nil {
+		written, ok, err := r.write(ctx, run, variablestore, writes)
+		if !ok || err != nil {
+			return err
+		}
+		results = written
+	} else {
+		for _, w := range writes {
+			results = append(results, w.variable)
+		}
+	}
+
+	for _, result := range results {
+		run.AddResult(result.Name, result.Value)
+	}
+	if len(extraFields.Residuals) > 0 {
+		run.MarkSucceeded(variablestorev1alpha1.ReasonPartialEvaluation.String(),
+			"%d CEL expressions could only be partially evaluated because of unknown variables", len(extraFields.Residuals))
+		return nil
+	}
+	run.MarkSucceeded(variablestorev1alpha1.ReasonEvaluationSuccess.String(),
 		"CEL expressions were evaluated successfully")
 
 	return nil
 }
 
-func (r *Reconciler) getVariableStore(ctx context.Context, run *v1alpha1.Run) (*variablestorev1alpha1.VariableStore, error) {
+// references returns whether the reference of a Run references the custom task of the Reconciler.
+func (r *Reconciler) references(ref *v1beta1.TaskRef) bool {
+	if ref == nil || ref.Kind != v1beta1.TaskKind(r.kind()) {
+		return false
+	}
+	if r.stateless {
+		return ref.APIVersion == celv1alpha1.SchemeGroupVersion.String()
+	}
+	return ref.APIVersion == variablestorev1alpha1.SchemeGroupVersion.String() ||
+		ref.APIVersion == variablestorev1beta1.SchemeGroupVersion.String()
+}
+
+// kind returns the kind of the custom task of the Reconciler.
+func (r *Reconciler) kind() string {
+	if r.stateless {
+		return celtask.Kind
+	}
+	return "VariableStore"
+}
+
+// isStoreRun returns whether the Run is a Run of VariableStores. The annotations of a PipelineRun are
+// propagated to all its Runs, so the Runs of the other custom tasks, e.g. the CEL custom task, ignore the
+// annotations applying to VariableStores.
+func isStoreRun(run customRun) bool {
+	ref := run.GetRef()
+	return ref != nil && ref.Kind == "VariableStore"
+}
+
+// referencesStore returns whether the Run has a VariableStore, by name or the ephemeral one of its
+// PipelineRun, which it could wait for or hold the locks of.
+func referencesStore(run customRun) bool {
+	_, ok := storeName(run)
+	return ok
+}
+
+func (r *Reconciler) getVariableStore(ctx context.Context, run customRun) (*variablestorev1alpha1.VariableStore, error) {
 	var variablestore *variablestorev1alpha1.VariableStore
 
-	if run.Spec.Ref != nil && run.Spec.Ref.Name != "" {
+	// The Runs of the CEL custom task never read a VariableStore, so they never write one either
+	if r.stateless {
+		return nil, nil
+	}
+
+	if pipelineRun, ok := getEphemeral(run); ok {
+		return r.getEphemeralStore(ctx, run, pipelineRun)
+	}
+
+	if ref := run.GetRef(); ref != nil && ref.Name != "" {
 		// Use the k8 client to get the TaskLoop rather than the lister.  This avoids a timing issue where
 		// the TaskLoop is not yet in the lister cache if it is created at nearly the same time as the Run.
 		// See https://github.com/tektoncd/pipeline/issues/2740 for discussion on this issue.
 		//
-		vs, err := r.variablestoreClientSet.CustomV1alpha1().VariableStores(run.Namespace).Get(ctx, run.Spec.Ref.Name, metav1.GetOptions{})
+		vs, err := r.variablestoreClientSet.CustomV1alpha1().VariableStores(run.GetNamespace()).Get(ctx, ref.Name, metav1.GetOptions{})
 		if err != nil {
 			return nil, err
 		}
@@ -257,32 +551,122 @@ func (r *Reconciler) getVariableStore(ctx context.Context, run *v1alpha1.Run) (*
 	return variablestore, nil
 }
 
-func validate(run *v1alpha1.Run) (errs *apis.FieldError) {
+func (r *Reconciler) getPipelineRun(run customRun) (*v1beta1.PipelineRun, error) {
+	name, ok := pipelineRunOwnerName(run)
+	if !ok {
+		return nil, nil
+	}
+
+	return r.pipelineRunLister.PipelineRuns(run.GetNamespace()).Get(name)
+}
+
+func validate(run customRun, namePolicy variablestorev1alpha1.NamePolicy) (errs *apis.FieldError) {
 	errs = errs.Also(validateExpressionsProvided(run))
 	errs = errs.Also(validateExpressionsType(run))
+	errs = errs.Also(validateExpressionsName(run, namePolicy))
+	errs = errs.Also(validateUnknownsPolicy(run))
+	errs = errs.Also(validateArrayParamsPolicy(run))
+	errs = errs.Also(validateExpandedParams(run, namePolicy))
+	errs = errs.Also(validateWait(run))
+	errs = errs.Also(validateLocks(run))
+	errs = errs.Also(validateOperations(run))
+	errs = errs.Also(validateTransaction(run))
+	errs = errs.Also(validateEphemeral(run))
 	return errs
 }
 
-func validateExpressionsProvided(run *v1alpha1.Run) (errs *apis.FieldError) {
-	if len(run.Spec.Params) == 0 {
+func validateExpressionsProvided(run customRun) (errs *apis.FieldError) {
+	if len(run.GetParams()) == 0 {
 		errs = errs.Also(apis.ErrMissingField("params"))
 	}
 	return errs
 }
 
-func validateExpressionsType(run *v1alpha1.Run) (errs *apis.FieldError) {
-	for _, param := range run.Spec.Params {
-		if param.Value.StringVal == "" {
-			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("CEL expression parameter %s must be a string", param.Name),
-				"value").ViaFieldKey("params", param.Name))
+func validateExpressionsType(run customRun) (errs *apis.FieldError) {
+	literals := getArrayParamsPolicy(run) == variablestorev1alpha1.ArrayParamsLiterals
+	for _, param := range run.GetParams() {
+		switch {
+		case param.Value.Type != v1beta1.ParamTypeArray:
+			if param.Value.StringVal == "" {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("CEL expression parameter %s must be a string or an array", param.Name),
+					"value").ViaFieldKey("params", param.Name))
+			}
+		case !literals:
+			for i, expression := range param.Value.ArrayVal {
+				if expression == "" {
+					errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("CEL expression parameter %s must only hold non-empty expressions", param.Name),
+						fmt.Sprintf("value[%d]", i)).ViaFieldKey("params", param.Name))
+				}
+			}
 		}
 	}
 	return errs
 }
 
+func validateExpressionsName(run customRun, namePolicy variablestorev1alpha1.NamePolicy) (errs *apis.FieldError) {
+	aliases := make(map[string]string, len(run.GetParams()))
+	for _, param := range run.GetParams() {
+		if !variablestorev1alpha1.IsIdentifier(param.Name) && namePolicy == variablestorev1alpha1.NamePolicyReject {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("CEL expression parameter %s is not a valid CEL identifier and couldn't be referenced in expressions", param.Name),
+				"name").ViaFieldKey("params", param.Name))
+			continue
+		}
+
+		alias := variablestorev1alpha1.Alias(param.Name)
+		if name, ok := aliases[alias]; ok && name != param.Name {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("CEL expression parameter %s is referenced in expressions as %s, which is already used by %s", param.Name, alias, name),
+				"name").ViaFieldKey("params", param.Name))
+			continue
+		}
+		aliases[alias] = param.Name
+	}
+	return errs
+}
+
+func validateUnknownsPolicy(run customRun) (errs *apis.FieldError) {
+	if policy, ok := getUnknownsPolicy(run); ok &&
+		policy != variablestorev1alpha1.UnknownsPolicyFail && policy != variablestorev1alpha1.UnknownsPolicySucceed {
+		errs = errs.Also(apis.ErrInvalidValue(policy, variablestores.UnknownsAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+func validateArrayParamsPolicy(run customRun) (errs *apis.FieldError) {
+	switch policy := getArrayParamsPolicy(run); policy {
+	case variablestorev1alpha1.ArrayParamsExpressions, variablestorev1alpha1.ArrayParamsLiterals:
+	default:
+		errs = errs.Also(apis.ErrInvalidValue(policy, variablestores.ArrayParamsAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+func validateExpandedParams(run customRun, namePolicy variablestorev1alpha1.NamePolicy) (errs *apis.FieldError) {
+	expanded := getExpandedParams(run)
+	for _, param := range run.GetParams() {
+		delete(expanded, param.Name)
+	}
+	names := make([]string, 0, len(expanded))
+	for name := range expanded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not a param of the Run", name),
+			variablestores.ExpandAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	if len(getExpandedParams(run)) > 0 && namePolicy == variablestorev1alpha1.NamePolicyReject {
+		errs = errs.Also(apis.ErrInvalidValue("the variables params are expanded into are not valid CEL identifiers, which the VariableStore rejects",
+			variablestores.ExpandAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+// containsVar returns whether a param overrides the variable, i.e. both are referenced in expressions
+// under the same name.
 func containsVar(varName string, params []v1beta1.Param) (bool, int) {
+	alias := variablestorev1alpha1.Alias(varName)
 	for index, param := range params {
-		if param.Name == varName {
+		if variablestorev1alpha1.Alias(param.Name) == alias {
 			return true, index
 		}
 	}
@@ -290,6 +674,20 @@ func containsVar(varName string, params []v1beta1.Param) (bool, int) {
 	return false, -1
 }
 
+// varType returns the type of the variable storing the result of a CEL expression.
+func varType(out ref.Val) variablestorev1alpha1.VarType {
+	switch out.Type() {
+	case types.BoolType:
+		return variablestorev1alpha1.VarTypeBool
+	case types.IntType:
+		return variablestorev1alpha1.VarTypeInt
+	case types.DoubleType:
+		return variablestorev1alpha1.VarTypeDouble
+	default:
+		return variablestorev1alpha1.VarTypeString
+	}
+}
+
 func containsParam(paramName string, vars []variablestorev1alpha1.Var) (bool, int) {
 	for index, variable := range vars {
 		if variable.Name == paramName {
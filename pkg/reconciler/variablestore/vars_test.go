@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"knative.dev/pkg/apis"
+)
+
+func TestReconcileVars(t *testing.T) {
+	vars := []variablestorev1alpha1.Var{
+		{Name: "is-red", Value: "true", Type: variablestorev1alpha1.VarTypeBool},
+		{Name: "region", Value: "eu", Type: variablestorev1alpha1.VarTypeString},
+	}
+	run, _ := reconcileRun(t, vars, nil,
+		stringParam("color", "vars['is-red'] == 'true' ? 'red' : 'blue'"),
+		stringParam("feature_x", "has(vars.feature_x) ? vars.feature_x : 'off'"),
+		stringParam("previous", "vars['color'] + '/' + vars.region"),
+		stringParam("count", "size(vars)"),
+		stringParam("names", "vars.exists(k, k == 'is-red')"),
+	)
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Fatalf("reconcile() set the condition %v, want success", c)
+	}
+	for name, want := range map[string]string{
+		"color":     "red",
+		"feature_x": "off",
+		"previous":  "red/eu",
+		"count":     "5",
+		"names":     "true",
+	} {
+		if got := runResult(run, name); got != want {
+			t.Errorf("result %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReconcileVarsHiddenByParam(t *testing.T) {
+	// A param named like a variable hides it, in the `vars` map too once evaluated
+	vars := []variablestorev1alpha1.Var{{Name: "region", Value: "eu", Type: variablestorev1alpha1.VarTypeString}}
+	run, _ := reconcileRun(t, vars, nil,
+		stringParam("before", "vars.region"),
+		stringParam("region", "'us'"),
+		stringParam("after", "vars.region"),
+	)
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Fatalf("reconcile() set the condition %v, want success", c)
+	}
+	for name, want := range map[string]string{"before": "eu", "after": "us"} {
+		if got := runResult(run, name); got != want {
+			t.Errorf("result %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReconcileVarsDefaulted(t *testing.T) {
+	// The values of a legacy VariableStore go through the defaulting webhook when written back, and keep
+	// comparing equal to the strings they were
+	spec := variablestorev1alpha1.VariableStoreSpec{Vars: []variablestorev1alpha1.Var{
+		{Name: "approved", Value: "yes"},
+		{Name: "country", Value: "no"},
+	}}
+	spec.SetDefaults(context.Background())
+	run, _ := reconcileRun(t, spec.Vars, nil,
+		stringParam("approved_yes", "approved == 'yes'"),
+		stringParam("norway", "country == 'no'"),
+	)
+	if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+		t.Fatalf("reconcile() set the condition %v, want success", c)
+	}
+	for name, want := range map[string]string{"approved_yes": "true", "norway": "true"} {
+		if got := runResult(run, name); got != want {
+			t.Errorf("result %s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/vincentpli/cel-tekton/pkg/apis/variablestores"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	"github.com/vincentpli/cel-tekton/pkg/celenv"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/tracker"
+)
+
+// defaultWaitTimeout is how long a Run waits for its condition when it doesn't set a timeout.
+const defaultWaitTimeout = time.Hour
+
+// getWaitCondition returns the condition over its VariableStore the Run waits for, and whether it waits. The
+// annotation is propagated from the PipelineRun to all its Runs, so the Runs which aren't Runs of
+// VariableStores, e.g. the Runs of the CEL custom task, ignore it.
+func getWaitCondition(run customRun) (string, bool) {
+	if !isStoreRun(run) {
+		return "", false
+	}
+	condition, ok := run.GetAnnotations()[variablestores.WaitForAnnotationKey]
+	return condition, ok
+}
+
+// getWaitTimeout returns how long the Run waits for its condition.
+func getWaitTimeout(run metav1.Object) (time.Duration, error) {
+	timeout, ok := run.GetAnnotations()[variablestores.WaitTimeoutAnnotationKey]
+	if !ok {
+		return defaultWaitTimeout, nil
+	}
+	return time.ParseDuration(timeout)
+}
+
+func validateWait(run customRun) (errs *apis.FieldError) {
+	if !isStoreRun(run) {
+		return nil
+	}
+	condition, ok := getWaitCondition(run)
+	if ok && strings.TrimSpace(condition) == "" {
+		errs = errs.Also(apis.ErrInvalidValue("the condition to wait for must not be empty",
+			variablestores.WaitForAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	if ok && !referencesStore(run) {
+		errs = errs.Also(apis.ErrInvalidValue("the Run doesn't reference a VariableStore whose variables it could wait for",
+			variablestores.WaitForAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	if timeout, err := getWaitTimeout(run); err != nil || timeout <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue(run.GetAnnotations()[variablestores.WaitTimeoutAnnotationKey],
+			variablestores.WaitTimeoutAnnotationKey).ViaField("metadata", "annotations"))
+	}
+	return errs
+}
+
+// wait evaluates the condition the Run waits for over the variables of its VariableStore, and returns whether
+// it is true. Until it is, the Run is marked Running, tracks its VariableStore so that it is reconciled again
+// whenever the VariableStore changes, and is re-queued for when it times out. It returns false as well when
+// the Run failed: the condition couldn't be evaluated, the Run timed out or it was cancelled.
+func (r *Reconciler) wait(ctx context.Context, run customRun, condition string, env *cel.Env, activation map[string]interface{},
+	programOptions []cel.ProgramOption, costs *costTracker) (bool, error) {
+	logger := logging.FromContext(ctx)
+
+	if run.IsCancelled() {
+		logger.Infof("Run %s/%s was cancelled while waiting for %s", run.GetNamespace(), run.GetName(), condition)
+		run.MarkFailed(variablestorev1alpha1.ReasonCancelled.String(),
+			"Run was cancelled while waiting for %s", condition)
+		return false, nil
+	}
+
+	// Evaluate the condition, the variables missing from the VariableStore being unknown until they are set
+	unknowns := map[string]struct{}{}
+	env, ast, iss := celenv.CompilePartial(env, condition, unknowns)
+	if iss.Err() != nil {
+		logger.Errorf("Condition %s could not be parsed when reconciling Run %s/%s: %v", condition, run.GetNamespace(), run.GetName(), iss.Err())
+		run.MarkFailed(variablestorev1alpha1.ReasonSyntaxError.String(),
+			"Condition %s could not be parsed: %v", condition, iss.Err())
+		return false, nil
+	}
+	prg, err := env.Program(ast, append(programOptions, cel.EvalOptions(cel.OptPartialEval))...)
+	if err != nil {
+		logger.Errorf("Condition %s could not be evaluated when reconciling Run %s/%s: %v", condition, run.GetNamespace(), run.GetName(), err)
+		run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+			"Condition %s could not be evaluated: %v", condition, err)
+		return false, nil
+	}
+	vars, err := cel.PartialVars(activation, unknownPatterns(unknowns)...)
+	if err != nil {
+		return false, err
+	}
+	costs.reset()
+	out, _, err := prg.Eval(vars)
+	if costs.exceeded != "" {
+		logger.Errorf("Condition %s exceeded the %s when reconciling Run %s/%s", condition, costs.exceeded, run.GetNamespace(), run.GetName())
+		run.MarkFailed(variablestorev1alpha1.ReasonCostLimitExceeded.String(),
+			"Condition %s exceeded the %s of namespace %s", condition, costs.exceeded, run.GetNamespace())
+		return false, nil
+	}
+	if err != nil {
+		logger.Errorf("Condition %s could not be evaluated when reconciling Run %s/%s: %v", condition, run.GetNamespace(), run.GetName(), err)
+		run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+			"Condition %s could not be evaluated: %v", condition, err)
+		return false, nil
+	}
+	if out == types.True {
+		logger.Infof("Condition %s is true when reconciling Run %s/%s", condition, run.GetNamespace(), run.GetName())
+		return true, nil
+	}
+	if out != types.False && !types.IsUnknown(out) {
+		logger.Errorf("Condition %s is not a bool when reconciling Run %s/%s: %v", condition, run.GetNamespace(), run.GetName(), out)
+		run.MarkFailed(variablestorev1alpha1.ReasonEvaluationError.String(),
+			"Condition %s must be a bool, got %s", condition, out.Type().TypeName())
+		return false, nil
+	}
+
+	// The condition isn't true yet
+	timeout, err := getWaitTimeout(run)
+	if err != nil {
+		return false, err
+	}
+	elapsed := time.Since(run.GetStartTime().Time)
+	if elapsed >= timeout {
+		logger.Infof("Run %s/%s timed out after %s waiting for %s", run.GetNamespace(), run.GetName(), timeout, condition)
+		run.MarkFailed(variablestorev1alpha1.ReasonWaitTimeout.String(),
+			"Run timed out after %s waiting for %s", timeout, condition)
+		return false, nil
+	}
+
+	if err := r.Tracker.TrackReference(storeReference(run), run); err != nil {
+		return false, err
+	}
+	r.enqueueAfter(ktypes.NamespacedName{Namespace: run.GetNamespace(), Name: run.GetName()}, timeout-elapsed)
+	run.MarkRunning(variablestorev1alpha1.ReasonWaiting.String(), "Waiting for %s", condition)
+	return false, nil
+}
+
+// storeReference returns the reference to the VariableStore of the Run the Tracker tracks.
+func storeReference(run customRun) tracker.Reference {
+	name, _ := storeName(run)
+	return tracker.Reference{
+		APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(),
+		Kind:       "VariableStore",
+		Namespace:  run.GetNamespace(),
+		Name:       name,
+	}
+}
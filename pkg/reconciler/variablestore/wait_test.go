@@ -0,0 +1,192 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package variablestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	variablestorev1alpha1 "github.com/vincentpli/cel-tekton/pkg/apis/variablestores/v1alpha1"
+	fakevariableclientset "github.com/vincentpli/cel-tekton/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/tracker"
+	"sigs.k8s.io/yaml"
+)
+
+func TestReconcileWait(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		vars        []variablestorev1alpha1.Var
+		annotations map[string]string
+		started     time.Duration
+		cancelled   bool
+		storeName   string
+		wantReason  string
+		wantRunning bool
+	}{{
+		name:        "true",
+		vars:        []variablestorev1alpha1.Var{{Name: "approved", Value: "yes"}},
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'"},
+		wantReason:  variablestorev1alpha1.ReasonEvaluationSuccess.String(),
+	}, {
+		name:        "false",
+		vars:        []variablestorev1alpha1.Var{{Name: "approved", Value: "no"}},
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'"},
+		wantReason:  variablestorev1alpha1.ReasonWaiting.String(),
+		wantRunning: true,
+	}, {
+		name:        "unknown",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'"},
+		wantReason:  variablestorev1alpha1.ReasonWaiting.String(),
+		wantRunning: true,
+	}, {
+		name:        "timed out",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'", "custom.tekton.dev/wait-timeout": "30m"},
+		started:     time.Hour,
+		wantReason:  variablestorev1alpha1.ReasonWaitTimeout.String(),
+	}, {
+		name:        "cancelled",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'"},
+		cancelled:   true,
+		wantReason:  variablestorev1alpha1.ReasonCancelled.String(),
+	}, {
+		name:        "not a bool",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "'yes'"},
+		wantReason:  variablestorev1alpha1.ReasonEvaluationError.String(),
+	}, {
+		name:        "invalid timeout",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'", "custom.tekton.dev/wait-timeout": "soon"},
+		wantReason:  variablestorev1alpha1.ReasonFailedValidation.String(),
+	}, {
+		name:        "no VariableStore",
+		annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'yes'"},
+		storeName:   "-",
+		wantReason:  variablestorev1alpha1.ReasonFailedValidation.String(),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &variablestorev1alpha1.VariableStore{
+				ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"},
+				Spec:       variablestorev1alpha1.VariableStoreSpec{Vars: tc.vars},
+			}
+			var tracked []ktypes.NamespacedName
+			var delay time.Duration
+			r := &Reconciler{
+				variablestoreClientSet: fakevariableclientset.NewSimpleClientset(store),
+				Tracker: tracker.New(func(key ktypes.NamespacedName) {
+					tracked = append(tracked, key)
+				}, time.Hour),
+				enqueueAfter: func(key ktypes.NamespacedName, after time.Duration) {
+					delay = after
+				},
+			}
+			storeName := "store"
+			if tc.storeName == "-" {
+				storeName = ""
+			}
+			run := &v1alpha1.Run{
+				ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default", Annotations: tc.annotations},
+				Spec: v1alpha1.RunSpec{
+					Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: storeName},
+					Params: []v1beta1.Param{stringParam("deploy", "'now'")},
+				},
+			}
+			if tc.cancelled {
+				run.Spec.Status = v1alpha1.RunSpecStatusCancelled
+			}
+			run.Status.InitializeConditions()
+			run.Status.StartTime = &metav1.Time{Time: time.Now().Add(-tc.started)}
+			if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+				t.Fatalf("reconcile() = %v", err)
+			}
+
+			condition := run.Status.GetCondition(apis.ConditionSucceeded)
+			if condition.Reason != tc.wantReason {
+				t.Fatalf("reconcile() set the condition %v, want the reason %s", condition, tc.wantReason)
+			}
+			if !tc.wantRunning {
+				return
+			}
+			if !condition.IsUnknown() {
+				t.Errorf("reconcile() set the condition %v, want it running", condition)
+			}
+			if len(run.Status.Results) != 0 {
+				t.Errorf("results = %v, want none while waiting", run.Status.Results)
+			}
+			if delay <= 59*time.Minute || delay > time.Hour {
+				t.Errorf("enqueueAfter() delay = %s, want the remaining hour", delay)
+			}
+
+			// Tracking the VariableStore reconciles the Run once to catch up, then every change of the
+			// VariableStore reconciles it again
+			want := ktypes.NamespacedName{Namespace: "default", Name: "run"}
+			if len(tracked) != 1 || tracked[0] != want {
+				t.Errorf("tracked = %v, want %v", tracked, want)
+			}
+			tracked = nil
+			store.SetGroupVersionKind(variablestorev1alpha1.SchemeGroupVersion.WithKind("VariableStore"))
+			r.Tracker.OnChanged(store)
+			if len(tracked) != 1 || tracked[0] != want {
+				t.Errorf("tracked = %v, want %v", tracked, want)
+			}
+		})
+	}
+}
+
+func TestReconcileWaitDefaulted(t *testing.T) {
+	// The approval of the README, set with `kubectl edit` in the forms YAML reads as true, goes through the
+	// defaulting webhook before the Run sees it
+	for _, value := range []string{`true`, `"true"`, `yes`} {
+		t.Run(value, func(t *testing.T) {
+			ctx := context.Background()
+			store := &variablestorev1alpha1.VariableStore{ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "default"}}
+			if err := yaml.Unmarshal([]byte("vars:\n- name: approved\n  value: "+value), &store.Spec); err != nil {
+				t.Fatalf("Unmarshal() = %v", err)
+			}
+			store.SetDefaults(ctx)
+			r := &Reconciler{
+				variablestoreClientSet: fakevariableclientset.NewSimpleClientset(store),
+				Tracker:                tracker.New(func(ktypes.NamespacedName) {}, time.Hour),
+				enqueueAfter:           func(ktypes.NamespacedName, time.Duration) {},
+			}
+			run := &v1alpha1.Run{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "run",
+					Namespace:   "default",
+					Annotations: map[string]string{"custom.tekton.dev/wait-for": "approved == 'true'"},
+				},
+				Spec: v1alpha1.RunSpec{
+					Ref:    &v1beta1.TaskRef{APIVersion: variablestorev1alpha1.SchemeGroupVersion.String(), Kind: "VariableStore", Name: "store"},
+					Params: []v1beta1.Param{stringParam("deploy", "'now'")},
+				},
+			}
+			run.Status.InitializeConditions()
+			run.Status.StartTime = &metav1.Time{Time: time.Now()}
+			if err := r.reconcile(ctx, v1alpha1Run{run}); err != nil {
+				t.Fatalf("reconcile() = %v", err)
+			}
+			if c := run.Status.GetCondition(apis.ConditionSucceeded); !c.IsTrue() {
+				t.Errorf("reconcile() set the condition %v, want success", c)
+			}
+		})
+	}
+}